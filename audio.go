@@ -0,0 +1,165 @@
+package main
+
+import (
+    "fmt"
+    "sync"
+
+    "github.com/go-gst/go-gst/gst"
+    "github.com/go-gst/go-gst/gst/app"
+    "github.com/godbus/dbus/v5"
+)
+
+type AudioVoice struct {
+    pipeline *gst.Pipeline
+    src      *app.Source
+    volume   *gst.Element
+}
+
+// AudioOutput plays every guest voice through its own GStreamer pipeline.
+type AudioOutput struct {
+    mu     sync.Mutex
+    voices map[uint64]*AudioVoice
+}
+
+func NewAudioOutput() *AudioOutput {
+    return &AudioOutput{voices: make(map[uint64]*AudioVoice)}
+}
+
+func audioFormat(bits byte, is_signed, is_float, be bool) string {
+    var format string
+
+    switch {
+    case is_float:
+        format = fmt.Sprintf("F%d", bits)
+    case is_signed:
+        format = fmt.Sprintf("S%d", bits)
+    default:
+        format = fmt.Sprintf("U%d", bits)
+    }
+
+    if bits == 8 {
+        return format
+    }
+
+    if be {
+        return format + "BE"
+    }
+    return format + "LE"
+}
+
+func (ao *AudioOutput) Init(id uint64, bits byte, is_signed, is_float bool, freq uint32, nchannels byte, bytes_per_frame, bytes_per_second uint32, be bool) *dbus.Error {
+    // fmt.Printf("Audio Init: voice %d, %d bits, signed %t, float %t, %d Hz, %d channels, be %t\n", id, bits, is_signed, is_float, freq, nchannels, be)
+
+    pipeline, err := gst.NewPipelineFromString("appsrc format=time do-timestamp=true is-live=true name=src ! audioconvert ! audioresample ! volume name=volume ! autoaudiosink")
+    if err != nil {
+        return dbus.MakeFailedError(err)
+    }
+
+    elem, err := pipeline.GetElementByName("src")
+    if err != nil {
+        return dbus.MakeFailedError(err)
+    }
+
+    src := app.SrcFromElement(elem)
+    src.SetCaps(gst.NewCapsFromString(fmt.Sprintf("audio/x-raw,format=%s,layout=interleaved,rate=%d,channels=%d", audioFormat(bits, is_signed, is_float, be), freq, nchannels)))
+
+    volume, err := pipeline.GetElementByName("volume")
+    if err != nil {
+        return dbus.MakeFailedError(err)
+    }
+
+    ao.mu.Lock()
+    defer ao.mu.Unlock()
+
+    if old, ok := ao.voices[id]; ok {
+        old.pipeline.BlockSetState(gst.StateNull)
+    }
+    ao.voices[id] = &AudioVoice{pipeline, src, volume}
+
+    return nil
+}
+
+func (ao *AudioOutput) Fini(id uint64) *dbus.Error {
+    ao.mu.Lock()
+    defer ao.mu.Unlock()
+
+    if voice, ok := ao.voices[id]; ok {
+        voice.src.EndStream()
+        voice.pipeline.BlockSetState(gst.StateNull)
+        delete(ao.voices, id)
+    }
+
+    return nil
+}
+
+// Reset stops all the voices, QEMU does not get to Fini them when it goes
+// away.
+func (ao *AudioOutput) Reset() {
+    ao.mu.Lock()
+    defer ao.mu.Unlock()
+
+    for id, voice := range ao.voices {
+        voice.pipeline.BlockSetState(gst.StateNull)
+        delete(ao.voices, id)
+    }
+}
+
+func (ao *AudioOutput) SetEnabled(id uint64, enabled bool) *dbus.Error {
+    ao.mu.Lock()
+    defer ao.mu.Unlock()
+
+    voice, ok := ao.voices[id]
+    if !ok {
+        fmt.Println("Audio SetEnabled before Init?")
+        return nil
+    }
+
+    if enabled {
+        voice.pipeline.SetState(gst.StatePlaying)
+    } else {
+        voice.pipeline.SetState(gst.StatePaused)
+    }
+
+    return nil
+}
+
+func (ao *AudioOutput) SetVolume(id uint64, mute bool, volume []byte) *dbus.Error {
+    ao.mu.Lock()
+    defer ao.mu.Unlock()
+
+    voice, ok := ao.voices[id]
+    if !ok {
+        fmt.Println("Audio SetVolume before Init?")
+        return nil
+    }
+
+    // GStreamer has a single volume for all channels, so average them
+    level := 1.0
+    if len(volume) > 0 {
+        sum := 0
+        for _, v := range volume {
+            sum += int(v)
+        }
+        level = float64(sum) / float64(len(volume)) / 255
+    }
+
+    voice.volume.SetProperty("mute", mute)
+    voice.volume.SetProperty("volume", level)
+
+    return nil
+}
+
+func (ao *AudioOutput) Write(id uint64, data []byte) *dbus.Error {
+    ao.mu.Lock()
+    voice, ok := ao.voices[id]
+    ao.mu.Unlock()
+
+    if !ok {
+        fmt.Println("Audio Write before Init?")
+        return nil
+    }
+
+    voice.src.PushBuffer(gst.NewBufferFromBytes(data))
+
+    return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "os"
+    "os/signal"
+    "syscall"
+
+    "github.com/go-gst/go-glib/glib"
+    "github.com/go-gst/go-gst/gst"
+
+    "qemu/capture"
+)
+
+func runCapture(fs *flag.FlagSet, args []string) error {
+    cf := addConnFlags(fs)
+    consoleSpec := fs.String("console", "0", "console to capture: a console ID or a label")
+    output := fs.String("o", "", "capture file to write")
+    fs.Parse(args)
+    needArgs(fs, 0, false)
+
+    if *output == "" {
+        return fmt.Errorf("-o is needed")
+    }
+
+    vm, closeVM, err := cf.connect()
+    if err != nil {
+        return err
+    }
+    defer closeVM()
+
+    console, err := findConsole(vm, *consoleSpec)
+    if err != nil {
+        return err
+    }
+
+    f, err := os.Create(*output)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+
+    recorder, err := capture.NewRecorder(f, nil)
+    if err != nil {
+        return err
+    }
+
+    listener := recorder.Listener()
+    if err = console.RegisterListener(listener); err != nil {
+        return err
+    }
+
+    signals := make(chan os.Signal, 1)
+    signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+    defer signal.Stop(signals)
+
+    fmt.Printf("Capturing console %d of %s to %s, Ctrl+C to stop\n", console.ID(), vm.Name(), *output)
+    select {
+    case <-signals:
+    case <-vm.Disconnected():
+        fmt.Println("Disconnected from the VM")
+    }
+
+    console.UnregisterListener(listener)
+    if err = recorder.Close(); err != nil {
+        return err
+    }
+    return f.Close()
+}
+
+func runReplay(fs *flag.FlagSet, args []string) error {
+    fast := fs.Bool("fast", false, "replay as fast as possible rather than at the recorded pace")
+    pipeline := fs.String("pipeline", DefaultViewPipeline, "GStreamer pipeline showing the frames, fed by an appsrc")
+    fs.Parse(args)
+    needArgs(fs, 1, false)
+
+    f, err := os.Open(fs.Arg(0))
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+
+    gst.Init(nil)
+
+    mainLoop := glib.NewMainLoop(glib.MainContextDefault(), false)
+
+    window, err := NewWindow(0, &ViewOptions{
+        Pipeline: *pipeline,
+        Scale:    1,
+        Quit:     mainLoop.Quit,
+    })
+    if err != nil {
+        return err
+    }
+    defer window.Close()
+
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+
+    done := make(chan error, 1)
+    go func() {
+        err := capture.Replay(ctx, f, window.listener, !*fast)
+        if err != nil {
+            mainLoop.Quit()
+        } else {
+            fmt.Println("End of the capture, close the window to quit")
+        }
+        done <- err
+    }()
+
+    mainLoop.Run()
+    cancel()
+
+    if err = <-done; err == context.Canceled {
+        // The window was closed first
+        return nil
+    }
+    return err
+}
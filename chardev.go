@@ -0,0 +1,95 @@
+package main
+
+import (
+    "fmt"
+    "io"
+    "os"
+
+    "golang.org/x/sys/unix"
+
+    "qemu"
+)
+
+// Ctrl-], same as telnet and virsh console
+const chardevEscape = 0x1d
+
+func makeRaw(fd int) (*unix.Termios, error) {
+    old, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+    if err != nil {
+        return nil, err
+    }
+
+    raw := *old
+    raw.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+    raw.Oflag &^= unix.OPOST
+    raw.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+    raw.Cflag &^= unix.CSIZE | unix.PARENB
+    raw.Cflag |= unix.CS8
+    raw.Cc[unix.VMIN] = 1
+    raw.Cc[unix.VTIME] = 0
+
+    if err := unix.IoctlSetTermios(fd, unix.TCSETS, &raw); err != nil {
+        return nil, err
+    }
+
+    return old, nil
+}
+
+// runChardev attaches the terminal to the chardev called name until the
+// escape character is typed or QEMU closes the stream.
+func runChardev(vm *qemu.VM, name string) error {
+    chardev, err := vm.GetChardev(name)
+    if err != nil {
+        return err
+    }
+
+    if owner := chardev.Owner(); owner != "" {
+        fmt.Printf("Chardev %s is owned by %s, taking over\n", chardev.Name(), owner)
+    }
+
+    stream, err := chardev.Open()
+    if err != nil {
+        return err
+    }
+    defer stream.Close()
+
+    fmt.Printf("Connected to chardev %s, escape character is ^]\n", chardev.Name())
+
+    stdin := int(os.Stdin.Fd())
+    if old, err := makeRaw(stdin); err == nil {
+        defer unix.IoctlSetTermios(stdin, unix.TCSETS, old)
+    }
+
+    done := make(chan error, 2)
+
+    go func() {
+        _, err := io.Copy(os.Stdout, stream)
+        done <- err
+    }()
+
+    go func() {
+        buf := make([]byte, 1024)
+        for {
+            n, err := os.Stdin.Read(buf)
+            if err != nil {
+                done <- err
+                return
+            }
+
+            for i := range n {
+                if buf[i] == chardevEscape {
+                    stream.Write(buf[:i])
+                    done <- nil
+                    return
+                }
+            }
+
+            if _, err := stream.Write(buf[:n]); err != nil {
+                done <- err
+                return
+            }
+        }
+    }()
+
+    return <-done
+}
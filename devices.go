@@ -0,0 +1,81 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "strings"
+
+    "qemu"
+)
+
+// Devices are the input side of a viewed console. They belong to one
+// connection and are swapped as a whole when the session reconnects.
+type Devices struct {
+    console  *qemu.Console
+    keyboard *qemu.Keyboard
+    pointer  *Pointer
+    touch    *TouchInput
+    locks    *LockSync
+    resizer  *Resizer
+}
+
+func printVM(vm *qemu.VM) {
+    fmt.Println("Connected to a VM:")
+
+    fmt.Printf("  Name: %v\n", vm.Name())
+    fmt.Printf("  UUID: %v\n", vm.UUID())
+    fmt.Printf("  Number of consoles: %d\n", vm.NumConsoles())
+}
+
+// NewDevices gets the input devices of console, the ones with a life of
+// their own stop with ctx.
+func NewDevices(ctx context.Context, console *qemu.Console, opts *ViewOptions) (*Devices, error) {
+    fmt.Printf("Connected to a console %d:\n", console.ID())
+    fmt.Printf("  %s display \"%s\": %dx%d\n", console.Type(), console.Label(), console.Width(), console.Height())
+
+    mouse, err := console.GetMouse()
+    if err != nil {
+        return nil, err
+    }
+
+    keyboard, err := console.GetKeyboard()
+    if err != nil {
+        return nil, err
+    }
+
+    var touch *TouchInput
+    if multiTouch, err := console.GetMultiTouch(); err == nil {
+        touch = NewTouchInput(multiTouch)
+    }
+
+    fmt.Printf("  Interfaces: %s\n", strings.Join(console.Interfaces(), ", "))
+    fmt.Printf("  Mouse is absolute: %t\n", mouse.IsAbsolute())
+    if touch != nil {
+        fmt.Printf("  Touch slots: %d\n", touch.touch.MaxSlots())
+    }
+    if !mouse.IsAbsolute() {
+        fmt.Printf("  Click into the window to grab the pointer, %s releases it\n", grabHotkeyName)
+    }
+
+    var confiner *Confiner
+    if opts.X11 != nil {
+        confiner = opts.X11.NewConfiner()
+    }
+
+    var locks *LockSync
+    if opts.SyncLocks {
+        locks, err = NewLockSync(ctx, keyboard)
+        if err != nil {
+            fmt.Println("Cannot sync the lock keys:", err)
+        }
+    }
+
+    return &Devices{
+        console:  console,
+        keyboard: keyboard,
+        pointer:  NewPointer(mouse, confiner),
+        touch:    touch,
+        locks:    locks,
+        resizer:  NewResizer(console, opts.Scale),
+    }, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+const grabHotkeyName = "Ctrl+Alt+G"
+
+type Hotkey int
+
+const (
+    HotkeyNone Hotkey = iota
+    HotkeyGrab
+    HotkeyPause
+    HotkeyReset
+    HotkeyPowerdown
+)
+
+// Ctrl+Alt+<key> shortcuts. Delete is left alone, the guest wants it.
+var hotkeys = map[string]Hotkey{
+    "g": HotkeyGrab,
+    "p": HotkeyPause,
+    "r": HotkeyReset,
+    "d": HotkeyPowerdown,
+}
+
+const hotkeysHelp = "Ctrl+Alt+P pauses/resumes, Ctrl+Alt+R resets, Ctrl+Alt+D powers down"
+
+// Hotkeys tracks the host modifiers to catch viewer shortcuts before the
+// keys reach the guest.
+type Hotkeys struct {
+    ctrl bool
+    alt  bool
+}
+
+func lookupHotkey(key string) Hotkey {
+    if len(key) == 1 && key[0] >= 'A' && key[0] <= 'Z' {
+        key = string(key[0] + 'a' - 'A')
+    }
+    return hotkeys[key]
+}
+
+// Press returns the shortcut key completes, the key must not be forwarded
+// unless it is HotkeyNone.
+func (h *Hotkeys) Press(key string) Hotkey {
+    switch key {
+    case "Control_L", "Control_R":
+        h.ctrl = true
+    case "Alt_L", "Alt_R":
+        h.alt = true
+    default:
+        if h.ctrl && h.alt {
+            return lookupHotkey(key)
+        }
+    }
+    return HotkeyNone
+}
+
+// Release returns true if key is the release of a shortcut.
+func (h *Hotkeys) Release(key string) bool {
+    switch key {
+    case "Control_L", "Control_R":
+        h.ctrl = false
+    case "Alt_L", "Alt_R":
+        h.alt = false
+    default:
+        return h.ctrl && h.alt && lookupHotkey(key) != HotkeyNone
+    }
+    return false
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "strings"
+
+    "qemu"
+)
+
+func runInfo(fs *flag.FlagSet, args []string) error {
+    cf := addConnFlags(fs)
+    fs.Parse(args)
+    needArgs(fs, 0, false)
+
+    vm, closeVM, err := cf.connect()
+    if err != nil {
+        return err
+    }
+    defer closeVM()
+
+    return printInfo(vm)
+}
+
+func printInfo(vm *qemu.VM) error {
+    fmt.Printf("Name: %s\n", vm.Name())
+    fmt.Printf("UUID: %s\n", vm.UUID())
+
+    fmt.Println("Consoles:")
+    for _, id := range vm.ConsoleIDs() {
+        console, err := vm.GetConsoleByID(id)
+        if err != nil {
+            return err
+        }
+
+        fmt.Printf("  %d: %s display \"%s\", %dx%d\n", id, console.Type(), console.Label(), console.Width(), console.Height())
+        fmt.Printf("     Interfaces: %s\n", strings.Join(console.Interfaces(), ", "))
+    }
+
+    chardevs, err := vm.Chardevs()
+    if err != nil {
+        // Older QEMUs do not export any
+        debugf("Cannot list chardevs: %v\n", err)
+        return nil
+    }
+
+    if len(chardevs) > 0 {
+        fmt.Println("Chardevs:")
+    }
+    for _, chardev := range chardevs {
+        fmt.Printf("  %s (%s): owner %q, opened %t\n", chardev.Name(), chardev.ID(), chardev.Owner(), chardev.FEOpened())
+    }
+
+    return nil
+}
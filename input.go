@@ -0,0 +1,124 @@
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "strings"
+    "time"
+
+    "qemu/keymap"
+)
+
+// keyAliases are the usual names of keys which QEMU calls otherwise
+var keyAliases = map[string]string{
+    "del":      "delete",
+    "enter":    "ret",
+    "return":   "ret",
+    "escape":   "esc",
+    "win":      "meta_l",
+    "super":    "meta_l",
+    "altgr":    "alt_r",
+    "pageup":   "pgup",
+    "pagedown": "pgdn",
+}
+
+// parseCombo parses keys joined by "-", like ctrl-alt-delete. Each key is
+// a QEMU QKeyCode name, an alias or an X11 keysym.
+func parseCombo(combo string) ([]keymap.Key, error) {
+    var keys []keymap.Key
+    for _, name := range strings.Split(combo, "-") {
+        qcode := strings.ToLower(name)
+        if alias, ok := keyAliases[qcode]; ok {
+            qcode = alias
+        }
+
+        // Keysyms are case sensitive
+        key, ok := keymap.LookupQCode(qcode)
+        if !ok {
+            key, ok = keymap.LookupKeysym(name)
+        }
+        if !ok {
+            return nil, fmt.Errorf("unknown key %q in %q", name, combo)
+        }
+        keys = append(keys, key)
+    }
+
+    return keys, nil
+}
+
+func runSendKey(fs *flag.FlagSet, args []string) error {
+    cf := addConnFlags(fs)
+    consoleSpec := fs.String("console", "0", "console to send to: a console ID or a label")
+    hold := fs.Duration("hold", 100*time.Millisecond, "how long the keys of a combination are held")
+    fs.Parse(args)
+    needArgs(fs, 1, true)
+
+    // Check them all before sending anything
+    var combos [][]keymap.Key
+    for _, arg := range fs.Args() {
+        keys, err := parseCombo(arg)
+        if err != nil {
+            return err
+        }
+        combos = append(combos, keys)
+    }
+
+    vm, closeVM, err := cf.connect()
+    if err != nil {
+        return err
+    }
+    defer closeVM()
+
+    console, err := findConsole(vm, *consoleSpec)
+    if err != nil {
+        return err
+    }
+
+    keyboard, err := console.GetKeyboard()
+    if err != nil {
+        return err
+    }
+
+    for _, keys := range combos {
+        err = keyboard.SendKeys(context.Background(), keys, *hold)
+        if err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+func runType(fs *flag.FlagSet, args []string) error {
+    cf := addConnFlags(fs)
+    consoleSpec := fs.String("console", "0", "console to type on: a console ID or a label")
+    layoutName := fs.String("keymap", "us", "keyboard layout of the guest")
+    delay := fs.Duration("delay", 10*time.Millisecond, "pause after every key event")
+    fs.Parse(args)
+    needArgs(fs, 1, true)
+
+    layout, ok := keymap.LayoutByName(*layoutName)
+    if !ok {
+        return fmt.Errorf("unknown keymap %q, known ones are %s", *layoutName, strings.Join(keymap.Layouts(), ", "))
+    }
+
+    vm, closeVM, err := cf.connect()
+    if err != nil {
+        return err
+    }
+    defer closeVM()
+
+    console, err := findConsole(vm, *consoleSpec)
+    if err != nil {
+        return err
+    }
+
+    keyboard, err := console.GetKeyboard()
+    if err != nil {
+        return err
+    }
+    keyboard.SetTypeDelay(*delay)
+
+    return keyboard.TypeText(context.Background(), strings.Join(fs.Args(), " "), layout)
+}
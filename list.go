@@ -0,0 +1,104 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "flag"
+    "fmt"
+    "os"
+    "strconv"
+    "strings"
+    "text/tabwriter"
+
+    "qemu/discover"
+)
+
+// searchDirs are the colon separated directories to look for libvirt VMs
+// in, on top of the default ones.
+type searchDirs struct {
+    state  string
+    socket string
+}
+
+func addSearchFlags(fs *flag.FlagSet) *searchDirs {
+    dirs := &searchDirs{}
+    fs.StringVar(&dirs.state, "state-dir", "", "colon separated extra directories to look for libvirt domain XML files in")
+    fs.StringVar(&dirs.socket, "socket-dir", "", "colon separated extra directories to look for VM bus sockets in")
+    return dirs
+}
+
+func scanVMs(dirs *searchDirs) ([]discover.VM, error) {
+    s := discover.Scanner{
+        StateDirs:  []string{discover.DefaultStateDir},
+        SocketDirs: []string{discover.DefaultSocketDir},
+    }
+    if dirs.state != "" {
+        s.StateDirs = append(s.StateDirs, strings.Split(dirs.state, ":")...)
+    }
+    if dirs.socket != "" {
+        s.SocketDirs = append(s.SocketDirs, strings.Split(dirs.socket, ":")...)
+    }
+
+    return s.Scan(context.Background())
+}
+
+func runList(dirs *searchDirs) error {
+    vms, err := scanVMs(dirs)
+    if err != nil {
+        return err
+    }
+
+    w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+    fmt.Fprintln(w, "NAME\tUUID\tADDRESS\tSTATUS")
+    for _, vm := range vms {
+        status := "ok"
+        if vm.Err != nil {
+            status = vm.Err.Error()
+        }
+        fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", vm.Name, vm.UUID, vm.Address, status)
+    }
+
+    return w.Flush()
+}
+
+// pickAddress returns the address of one of the reachable discovered VMs,
+// asking on the terminal if there are several. Without any it returns "",
+// for the session bus, which is where a QEMU started by hand puts its
+// display.
+func pickAddress(dirs *searchDirs) (string, error) {
+    found, err := scanVMs(dirs)
+    if err != nil {
+        return "", err
+    }
+
+    var vms []discover.VM
+    for _, vm := range found {
+        if vm.Err == nil {
+            vms = append(vms, vm)
+        }
+    }
+
+    switch len(vms) {
+    case 0:
+        return "", nil
+    case 1:
+        return vms[0].Address, nil
+    }
+
+    for i, vm := range vms {
+        fmt.Printf("%3d) %s  %s\n", i+1, vm.Name, vm.UUID)
+    }
+
+    in := bufio.NewScanner(os.Stdin)
+    for {
+        fmt.Print("VM to connect to: ")
+        if !in.Scan() {
+            return "", fmt.Errorf("no VM selected")
+        }
+
+        n, err := strconv.Atoi(strings.TrimSpace(in.Text()))
+        if err == nil && n >= 1 && n <= len(vms) {
+            return vms[n-1].Address, nil
+        }
+    }
+}
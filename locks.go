@@ -0,0 +1,144 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+
+    "qemu"
+    "qemu/keymap"
+)
+
+// hostLEDs finds the lock LEDs of the host keyboards, which show the lock
+// state the same under X11, Wayland and the console.
+func hostLEDs() ([]string, error) {
+    leds, err := filepath.Glob("/sys/class/leds/input*::*lock")
+    if err != nil {
+        return nil, err
+    }
+    if len(leds) == 0 {
+        return nil, fmt.Errorf("no keyboard LEDs to read the host locks from")
+    }
+    return leds, nil
+}
+
+// hostLocks reads the host lock state from leds.
+func hostLocks(leds []string) qemu.KeyboardModifier {
+    var mods qemu.KeyboardModifier
+    for _, led := range leds {
+        brightness, err := os.ReadFile(filepath.Join(led, "brightness"))
+        if err != nil || strings.TrimSpace(string(brightness)) == "0" {
+            continue
+        }
+
+        switch {
+        case strings.HasSuffix(led, "::capslock"):
+            mods |= qemu.Caps
+        case strings.HasSuffix(led, "::numlock"):
+            mods |= qemu.Num
+        case strings.HasSuffix(led, "::scrolllock"):
+            mods |= qemu.Scroll
+        }
+    }
+
+    return mods
+}
+
+// LockSync toggles the guest lock keys to match the host when the window
+// gets the focus, which only X11 tells us about, and before the first key
+// press of a connection. The LEDs are looked up once, keyboards plugged in
+// later are not followed.
+type LockSync struct {
+    keyboard *qemu.Keyboard
+    leds     []string
+
+    mu     sync.Mutex
+    guest  qemu.KeyboardModifier
+    known  bool
+    synced bool
+}
+
+func NewLockSync(ctx context.Context, keyboard *qemu.Keyboard) (*LockSync, error) {
+    leds, err := hostLEDs()
+    if err != nil {
+        return nil, err
+    }
+
+    mods, err := keyboard.WatchModifiers(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    ls := &LockSync{keyboard: keyboard, leds: leds}
+
+    go func() {
+        for mod := range mods {
+            ls.mu.Lock()
+            ls.guest = mod
+            ls.known = true
+            ls.mu.Unlock()
+        }
+    }()
+
+    return ls, nil
+}
+
+var lockKeys = []struct {
+    mod   qemu.KeyboardModifier
+    qcode string
+    sym   string
+}{
+    {qemu.Caps, "caps_lock", "Caps_Lock"},
+    {qemu.Num, "num_lock", "Num_Lock"},
+}
+
+// KeyPress is called with the keysym about to be pressed, it syncs the
+// locks if nothing did yet.
+func (ls *LockSync) KeyPress(sym string) {
+    for _, lock := range lockKeys {
+        if sym == lock.sym {
+            // The user is toggling it right now
+            return
+        }
+    }
+
+    ls.mu.Lock()
+    defer ls.mu.Unlock()
+
+    if !ls.synced {
+        ls.syncLocked()
+    }
+}
+
+// Sync matches the guest locks to the host, for a window that got the
+// focus. It may be called from any goroutine.
+func (ls *LockSync) Sync() {
+    ls.mu.Lock()
+    defer ls.mu.Unlock()
+
+    ls.syncLocked()
+}
+
+func (ls *LockSync) syncLocked() {
+    if !ls.known {
+        return
+    }
+    ls.synced = true
+
+    host := hostLocks(ls.leds)
+    for _, lock := range lockKeys {
+        if host&lock.mod == ls.guest&lock.mod {
+            continue
+        }
+
+        key, _ := keymap.LookupQCode(lock.qcode)
+        ls.keyboard.Press(key.Qnum)
+        ls.keyboard.Release(key.Qnum)
+
+        // Assume it worked until QEMU says otherwise, not to toggle twice
+        ls.guest ^= lock.mod
+    }
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "os"
+    "strings"
+
+    "qemu"
+)
+
+type command struct {
+    name  string
+    args  string
+    short string
+    run   func(fs *flag.FlagSet, args []string) error
+}
+
+var commands = []command{
+    {"view", "", "show the consoles of the VM (the default)", runView},
+    {"list", "", "list the libvirt VMs with a D-Bus display", runListCmd},
+    {"info", "", "describe the VM and its consoles", runInfo},
+    {"screenshot", "-o FILE", "save a console as a PNG or PPM image", runScreenshot},
+    {"sendkey", "KEY[-KEY...]...", "press key combinations, e.g. ctrl-alt-delete", runSendKey},
+    {"type", "TEXT", "type text on the guest keyboard", runType},
+    {"record", "-o FILE", "record a console to a video file", runRecord},
+    {"capture", "-o FILE", "record the display calls of a console, for bug reports", runCapture},
+    {"replay", "FILE", "show a capture in a window", runReplay},
+    {"serve-vnc", "[ADDRESS]", "serve a console to VNC clients, on " + defaultVNCAddress + " by default", runServeVNC},
+    {"serve-web", "[ADDRESS]", "serve a console to web browsers, on " + defaultWebAddress + " by default", runServeWeb},
+    {"chardev", "NAME", "attach the terminal to a chardev", runChardevCmd},
+    {"power", "ACTION", "pause, resume, reset, powerdown, poweroff or status over QMP", runPowerCmd},
+}
+
+// verbose enables the -v debug output
+var verbose bool
+
+func debugf(format string, args ...interface{}) {
+    if verbose {
+        fmt.Printf(format, args...)
+    }
+}
+
+func usage() {
+    out := flag.CommandLine.Output()
+    fmt.Fprintf(out, "Usage: %s [COMMAND] [FLAGS] [ARGS]\n\nCommands:\n", os.Args[0])
+    for _, c := range commands {
+        fmt.Fprintf(out, "  %-12s%s\n", c.name, c.short)
+    }
+    fmt.Fprintf(out, "\nRun %s COMMAND -h for the flags of a command.\n", os.Args[0])
+}
+
+func main() {
+    args := os.Args[1:]
+
+    // Plain flags mean view, as before there were commands
+    name := "view"
+    if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+        name, args = args[0], args[1:]
+    }
+
+    if name == "help" {
+        usage()
+        return
+    }
+
+    for _, c := range commands {
+        if c.name == name {
+            if err := c.run(newFlagSet(c), args); err != nil {
+                fmt.Fprintf(os.Stderr, "%s: %v\n", c.name, err)
+                os.Exit(1)
+            }
+            return
+        }
+    }
+
+    fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+    usage()
+    os.Exit(2)
+}
+
+// newFlagSet returns the flag set of c, with -v.
+func newFlagSet(c command) *flag.FlagSet {
+    fs := flag.NewFlagSet(c.name, flag.ExitOnError)
+    fs.BoolVar(&verbose, "v", false, "print debug output")
+    fs.Usage = func() {
+        fmt.Fprintf(fs.Output(), "Usage: %s %s [FLAGS] %s\n\n%s\n\nFlags:\n", os.Args[0], c.name, c.args, c.short)
+        fs.PrintDefaults()
+    }
+
+    return fs
+}
+
+// needArgs exits with the usage unless fs got n arguments, or at least n
+// if atLeast is set.
+func needArgs(fs *flag.FlagSet, n int, atLeast bool) {
+    if fs.NArg() == n || atLeast && fs.NArg() > n {
+        return
+    }
+
+    fs.Usage()
+    os.Exit(2)
+}
+
+// connFlags are the flags telling how to reach the VM.
+type connFlags struct {
+    address string
+    dirs    *searchDirs
+    qmp     string
+    p2p     bool
+}
+
+func addConnFlags(fs *flag.FlagSet) *connFlags {
+    cf := &connFlags{}
+    fs.StringVar(&cf.address, "address", "", "D-Bus address of the VM (default: pick a libvirt VM or use the session bus)")
+    cf.dirs = addSearchFlags(fs)
+    fs.StringVar(&cf.qmp, "qmp", "", "path of the QMP socket of the VM, enables the power shortcuts")
+    fs.BoolVar(&cf.p2p, "p2p", false, "connect to -display dbus,p2p=yes through QMP add_client")
+    return cf
+}
+
+// monitor dials QMP if -qmp was given, nil otherwise.
+func (cf *connFlags) monitor() (*Monitor, error) {
+    if cf.qmp == "" {
+        return nil, nil
+    }
+    return DialMonitor(context.Background(), cf.qmp)
+}
+
+// dialer resolves the VM to connect to and returns a function connecting to
+// it, which can be called again to reconnect.
+func (cf *connFlags) dialer(monitor *Monitor) (func(ctx context.Context) (*qemu.VM, error), error) {
+    if cf.p2p {
+        if monitor == nil {
+            return nil, fmt.Errorf("-p2p needs -qmp")
+        }
+        return func(ctx context.Context) (*qemu.VM, error) {
+            client, err := monitor.Client(ctx)
+            if err != nil {
+                return nil, err
+            }
+            fd, err := client.AddDisplayClient(ctx)
+            if err != nil {
+                return nil, err
+            }
+            return qemu.Connect(ctx, qemu.WithFD(fd))
+        }, nil
+    }
+
+    address := cf.address
+    if address == "" {
+        var err error
+        address, err = pickAddress(cf.dirs)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    var opts []qemu.Option
+    if address != "" {
+        opts = append(opts, qemu.WithAddress(address))
+    }
+    return func(ctx context.Context) (*qemu.VM, error) {
+        return qemu.Connect(ctx, opts...)
+    }, nil
+}
+
+// connect connects once, for the commands which do not stay around.
+func (cf *connFlags) connect() (*qemu.VM, func(), error) {
+    monitor, err := cf.monitor()
+    if err != nil {
+        return nil, nil, err
+    }
+
+    closeAll := func() {
+        if monitor != nil {
+            monitor.Close()
+        }
+    }
+
+    dial, err := cf.dialer(monitor)
+    if err == nil {
+        var vm *qemu.VM
+        vm, err = dial(context.Background())
+        if err == nil {
+            return vm, func() {
+                vm.Close()
+                closeAll()
+            }, nil
+        }
+    }
+
+    closeAll()
+    return nil, nil, err
+}
+
+// findConsole returns the console given by -console, a console ID or a
+// label.
+func findConsole(vm *qemu.VM, spec string) (*qemu.Console, error) {
+    filter := ParseConsoleFilter(spec)
+    for _, id := range vm.ConsoleIDs() {
+        console, err := vm.GetConsoleByID(id)
+        if err != nil {
+            return nil, err
+        }
+        if filter(console) {
+            return console, nil
+        }
+    }
+
+    return nil, fmt.Errorf("no console matches %q", spec)
+}
+
+func runListCmd(fs *flag.FlagSet, args []string) error {
+    dirs := addSearchFlags(fs)
+    fs.Parse(args)
+
+    return runList(dirs)
+}
+
+func runChardevCmd(fs *flag.FlagSet, args []string) error {
+    cf := addConnFlags(fs)
+    fs.Parse(args)
+    needArgs(fs, 1, false)
+
+    vm, closeVM, err := cf.connect()
+    if err != nil {
+        return err
+    }
+    defer closeVM()
+
+    return runChardev(vm, fs.Arg(0))
+}
+
+func runPowerCmd(fs *flag.FlagSet, args []string) error {
+    cf := addConnFlags(fs)
+    fs.Parse(args)
+    needArgs(fs, 1, false)
+
+    monitor, err := cf.monitor()
+    if err != nil {
+        return err
+    }
+    if monitor == nil {
+        return fmt.Errorf("-qmp is needed")
+    }
+    defer monitor.Close()
+
+    client, err := monitor.Client(context.Background())
+    if err != nil {
+        return err
+    }
+
+    return runPower(client, fs.Arg(0))
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+    "fmt"
+    "math"
+
+    "qemu"
+)
+
+// Pointer forwards host pointer motion to the guest. Guests without an
+// absolute device only get relative motion, and only while the pointer is
+// grabbed: a click into the window grabs it, the grab hotkey toggles it.
+//
+// On X11 the confiner hides the grabbed host pointer and keeps it in the
+// window. Without one the host pointer stays visible and the deltas stop at
+// the window edges, release and grab again to recenter.
+type Pointer struct {
+    mouse    *qemu.Mouse
+    confiner *Confiner
+    grabbed  bool
+
+    hasLast bool
+    lastX   float64
+    lastY   float64
+}
+
+// NewPointer forwards motion to mouse, confiner may be nil.
+func NewPointer(mouse *qemu.Mouse, confiner *Confiner) *Pointer {
+    return &Pointer{mouse: mouse, confiner: confiner}
+}
+
+func (p *Pointer) relative() bool {
+    return !p.mouse.IsAbsolute()
+}
+
+func (p *Pointer) SetGrab(grab bool) {
+    if grab == p.grabbed {
+        return
+    }
+
+    p.grabbed = grab
+    p.hasLast = false
+
+    if grab {
+        fmt.Printf("Pointer grabbed, press %s to release\n", grabHotkeyName)
+        if p.confiner != nil {
+            if err := p.confiner.Start(); err != nil {
+                fmt.Println("Cannot confine the pointer:", err)
+            }
+        }
+    } else {
+        fmt.Println("Pointer released")
+        p.Detach()
+    }
+}
+
+// Detach gives the host pointer back without a word, for a connection that
+// went away while grabbed. It may be called from any goroutine.
+func (p *Pointer) Detach() {
+    if p.confiner != nil {
+        p.confiner.Stop()
+    }
+}
+
+func (p *Pointer) ToggleGrab() {
+    p.SetGrab(!p.grabbed)
+}
+
+func (p *Pointer) Move(x, y float64) {
+    if !p.relative() {
+        p.mouse.SetAbsPosition(uint32(x), uint32(y))
+        return
+    }
+
+    if !p.grabbed {
+        return
+    }
+
+    if p.confiner != nil {
+        if dx, dy, ok := p.confiner.Delta(); ok {
+            if dx != 0 || dy != 0 {
+                p.mouse.RelMotion(dx, dy)
+            }
+            return
+        }
+    }
+
+    if p.hasLast {
+        dx := int32(math.Round(x - p.lastX))
+        dy := int32(math.Round(y - p.lastY))
+        if dx != 0 || dy != 0 {
+            p.mouse.RelMotion(dx, dy)
+        }
+    }
+
+    p.hasLast = true
+    p.lastX = x
+    p.lastY = y
+}
+
+// mouseButton maps GStreamer (X11) button numbers to QEMU buttons. Buttons
+// 4 to 7 never show up here, GStreamer reports them as scroll events.
+func mouseButton(button int) (qemu.MouseButton, bool) {
+    switch button {
+    case 1:
+        return qemu.ButtonLeft, true
+    case 2:
+        return qemu.ButtonMiddle, true
+    case 3:
+        return qemu.ButtonRight, true
+    case 8:
+        return qemu.ButtonSide, true
+    case 9:
+        return qemu.ButtonExtra, true
+    }
+    return 0, false
+}
+
+func (p *Pointer) Press(button qemu.MouseButton) {
+    if p.relative() && !p.grabbed {
+        // The click that grabs the pointer is not meant for the guest
+        p.SetGrab(true)
+        return
+    }
+
+    p.mouse.Press(button)
+}
+
+func (p *Pointer) Release(button qemu.MouseButton) {
+    if p.relative() && !p.grabbed {
+        return
+    }
+
+    p.mouse.Release(button)
+}
+
+func (p *Pointer) Scroll(dx, dy float64) {
+    if p.relative() && !p.grabbed {
+        return
+    }
+
+    p.mouse.Scroll(dx, dy)
+}
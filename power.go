@@ -0,0 +1,195 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sync"
+    "time"
+
+    "qemu/qmp"
+)
+
+const powerTimeout = 5 * time.Second
+
+var errMonitorClosed = errors.New("QMP monitor closed")
+
+// Monitor is a QMP connection which is dialed again after QEMU restarted.
+type Monitor struct {
+    path string
+
+    mu     sync.Mutex
+    client *qmp.Client
+    closed bool
+}
+
+func DialMonitor(ctx context.Context, path string) (*Monitor, error) {
+    m := &Monitor{path: path}
+    if _, err := m.Client(ctx); err != nil {
+        return nil, err
+    }
+    return m, nil
+}
+
+// Client returns the QMP client, connecting again if the last one is gone.
+func (m *Monitor) Client(ctx context.Context) (*qmp.Client, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    if m.closed {
+        return nil, errMonitorClosed
+    }
+
+    if m.client != nil {
+        select {
+        case <-m.client.Done():
+            m.client = nil
+        default:
+            return m.client, nil
+        }
+    }
+
+    client, err := qmp.Dial(ctx, m.path)
+    if err != nil {
+        return nil, err
+    }
+    m.client = client
+
+    return client, nil
+}
+
+// Close closes the QMP client, Client fails afterwards.
+func (m *Monitor) Close() error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    m.closed = true
+    if m.client == nil {
+        return nil
+    }
+    return m.client.Close()
+}
+
+// Power drives the VM life cycle over QMP for the viewer shortcuts.
+type Power struct {
+    monitor *Monitor
+}
+
+// NewPower prints the life cycle events of the VM until ctx is done or
+// monitor is closed.
+func NewPower(ctx context.Context, monitor *Monitor) *Power {
+    p := &Power{monitor}
+    go p.watch(ctx)
+    return p
+}
+
+func (p *Power) watch(ctx context.Context) {
+    for ctx.Err() == nil {
+        client, err := p.monitor.Client(ctx)
+        if err == errMonitorClosed {
+            return
+        }
+        if err != nil {
+            select {
+            case <-time.After(time.Second):
+            case <-ctx.Done():
+            }
+            continue
+        }
+
+        p.printEvents(ctx, client)
+    }
+}
+
+func (p *Power) printEvents(ctx context.Context, client *qmp.Client) {
+    for {
+        select {
+        case ev, ok := <-client.Events():
+            if !ok {
+                return
+            }
+            printEvent(ev)
+        case <-ctx.Done():
+            return
+        }
+    }
+}
+
+func printEvent(ev qmp.Event) {
+    switch ev.Name {
+    case "STOP":
+        fmt.Println("VM paused")
+    case "RESUME":
+        fmt.Println("VM resumed")
+    case "RESET":
+        fmt.Println("VM reset")
+    case "POWERDOWN":
+        fmt.Println("VM asked to power down")
+    case "SHUTDOWN":
+        fmt.Println("VM shut down")
+    }
+}
+
+// Handle runs the action of a life cycle hotkey in the background, so that
+// a stuck monitor does not block the UI.
+func (p *Power) Handle(key Hotkey) {
+    go func() {
+        ctx, cancel := context.WithTimeout(context.Background(), powerTimeout)
+        defer cancel()
+
+        client, err := p.monitor.Client(ctx)
+        if err == nil {
+            switch key {
+            case HotkeyPause:
+                err = togglePause(ctx, client)
+            case HotkeyReset:
+                err = client.SystemReset(ctx)
+            case HotkeyPowerdown:
+                err = client.SystemPowerdown(ctx)
+            }
+        }
+        if err != nil {
+            fmt.Println("QMP command failed:", err)
+        }
+    }()
+}
+
+func togglePause(ctx context.Context, client *qmp.Client) error {
+    st, err := client.QueryStatus(ctx)
+    if err != nil {
+        return err
+    }
+
+    if st.Running {
+        return client.Stop(ctx)
+    }
+    return client.Cont(ctx)
+}
+
+// runPower runs one of the power commands given on the command line.
+func runPower(client *qmp.Client, action string) error {
+    ctx, cancel := context.WithTimeout(context.Background(), powerTimeout)
+    defer cancel()
+
+    switch action {
+    case "pause":
+        return client.Stop(ctx)
+    case "resume":
+        return client.Cont(ctx)
+    case "reset":
+        return client.SystemReset(ctx)
+    case "powerdown":
+        return client.SystemPowerdown(ctx)
+    case "poweroff":
+        return client.Quit(ctx)
+    case "status":
+        st, err := client.QueryStatus(ctx)
+        if err != nil {
+            return err
+        }
+        fmt.Println(st.Status)
+        return nil
+    default:
+        return fmt.Errorf("unknown power action %q, expected pause, resume, reset, powerdown, poweroff or status", action)
+    }
+}
@@ -0,0 +1,91 @@
+package qemu
+
+import (
+    "fmt"
+    "net"
+    "slices"
+
+    "github.com/godbus/dbus/v5"
+)
+
+// AudioOutListener receives the guest playback streams. Every stream (voice)
+// is identified by id, which is announced by Init and retired by Fini.
+type AudioOutListener interface {
+    Init(id uint64, bits byte, is_signed, is_float bool, freq uint32, nchannels byte, bytes_per_frame, bytes_per_second uint32, be bool) *dbus.Error
+    Fini(id uint64) *dbus.Error
+    SetEnabled(id uint64, enabled bool) *dbus.Error
+    SetVolume(id uint64, mute bool, volume []byte) *dbus.Error
+    Write(id uint64, data []byte) *dbus.Error
+}
+
+// AudioInListener feeds the guest capture streams. Read must return up to
+// size bytes of samples in the format announced by Init.
+type AudioInListener interface {
+    Init(id uint64, bits byte, is_signed, is_float bool, freq uint32, nchannels byte, bytes_per_frame, bytes_per_second uint32, be bool) *dbus.Error
+    Fini(id uint64) *dbus.Error
+    SetEnabled(id uint64, enabled bool) *dbus.Error
+    SetVolume(id uint64, mute bool, volume []byte) *dbus.Error
+    Read(id uint64, size uint64) ([]byte, *dbus.Error)
+}
+
+type Audio struct {
+    conn      *dbus.Conn
+    audio     dbus.BusObject
+    listeners []audioListenerConn
+}
+
+type audioListenerConn struct {
+    conn *dbus.Conn
+    unix *net.UnixConn
+    impl interface{}
+}
+
+func newAudio(conn *dbus.Conn) (*Audio, error) {
+    return &Audio{conn, conn.Object(qemuIntf, audioPath), nil}, nil
+}
+
+func (vm *VM) GetAudio() (*Audio, error) {
+    if !slices.Contains(vm.interfaces, audioIntf) {
+        return nil, fmt.Errorf("audio is not supported by the VM")
+    }
+
+    return newAudio(vm.conn)
+}
+
+func (a *Audio) RegisterOutListener(listener AudioOutListener) error {
+    return a.register(listener, audioRegisterOutListener, audioOutListenerPath, audioOutListenerIntf)
+}
+
+func (a *Audio) RegisterInListener(listener AudioInListener) error {
+    return a.register(listener, audioRegisterInListener, audioInListenerPath, audioInListenerIntf)
+}
+
+func (a *Audio) register(listener interface{}, method string, path dbus.ObjectPath, intf string) error {
+    conn, us, err := dialPeer(a.audio, method, func(conn *dbus.Conn) error {
+        return conn.Export(listener, path, intf)
+    })
+    if conn == nil {
+        return err
+    }
+
+    a.listeners = append(a.listeners, audioListenerConn{conn, us, listener})
+
+    return err
+}
+
+// UnregisterListener drops a listener previously passed to RegisterOutListener
+// or RegisterInListener.
+func (a *Audio) UnregisterListener(listener interface{}) error {
+    var newListeners []audioListenerConn
+    for _, v := range a.listeners {
+        if v.impl == listener {
+            v.conn.Close()
+            v.unix.Close()
+        } else {
+            newListeners = append(newListeners, v)
+        }
+    }
+    a.listeners = newListeners
+
+    return nil
+}
@@ -0,0 +1,345 @@
+package automation
+
+import (
+    "errors"
+    "image"
+    "math"
+    "sort"
+)
+
+var (
+    // ErrFlatTemplate is returned for templates of a single colour, which
+    // match anything as well as nothing
+    ErrFlatTemplate = errors.New("template has a single colour")
+
+    errTooBig = errors.New("template is bigger than the region")
+)
+
+// flat is the variance under which a window counts as a single colour
+const flat = 1e-6
+
+// gray is a grayscale image, with values from 0 to 1.
+type gray struct {
+    w, h int
+    pix  []float64
+}
+
+// toGray converts r of img with the Rec. 601 luma weights.
+func toGray(img image.Image, r image.Rectangle) gray {
+    g := gray{r.Dx(), r.Dy(), make([]float64, r.Dx()*r.Dy())}
+
+    if rgba, ok := img.(*image.RGBA); ok {
+        for y := 0; y < g.h; y++ {
+            row := rgba.Pix[rgba.PixOffset(r.Min.X, r.Min.Y+y):]
+            for x := 0; x < g.w; x++ {
+                p := row[x*4:]
+                g.pix[y*g.w+x] = (0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])) / 255
+            }
+        }
+        return g
+    }
+
+    for y := 0; y < g.h; y++ {
+        for x := 0; x < g.w; x++ {
+            cr, cg, cb, _ := img.At(r.Min.X+x, r.Min.Y+y).RGBA()
+            g.pix[y*g.w+x] = (0.299*float64(cr) + 0.587*float64(cg) + 0.114*float64(cb)) / 0xffff
+        }
+    }
+    return g
+}
+
+// shrink averages blocks of f x f pixels.
+func (g gray) shrink(f int) gray {
+    s := gray{g.w / f, g.h / f, make([]float64, (g.w/f)*(g.h/f))}
+    for y := 0; y < s.h; y++ {
+        for x := 0; x < s.w; x++ {
+            sum := 0.0
+            for j := 0; j < f; j++ {
+                row := g.pix[(y*f+j)*g.w+x*f:]
+                for i := 0; i < f; i++ {
+                    sum += row[i]
+                }
+            }
+            s.pix[y*s.w+x] = sum / float64(f*f)
+        }
+    }
+    return s
+}
+
+// pattern is a template less its mean, and its sum of squares.
+type pattern struct {
+    tpl  gray
+    norm float64
+}
+
+func newPattern(tpl gray) (*pattern, error) {
+    p := &pattern{tpl: gray{tpl.w, tpl.h, make([]float64, len(tpl.pix))}}
+
+    mean := 0.0
+    for _, v := range tpl.pix {
+        mean += v
+    }
+    mean /= float64(len(tpl.pix))
+    for i, v := range tpl.pix {
+        p.tpl.pix[i] = v - mean
+        p.norm += (v - mean) * (v - mean)
+    }
+    if p.norm < flat {
+        return nil, ErrFlatTemplate
+    }
+
+    return p, nil
+}
+
+// searcher scores the windows of img against a pattern by normalized
+// cross-correlation. Summed-area tables give the mean and variance of any
+// window at once.
+type searcher struct {
+    img       gray
+    sum, sum2 []float64
+
+    *pattern
+}
+
+func newSearcher(img gray, p *pattern) *searcher {
+    s := &searcher{img: img, pattern: p}
+
+    stride := img.w + 1
+    s.sum = make([]float64, stride*(img.h+1))
+    s.sum2 = make([]float64, stride*(img.h+1))
+    for y := 0; y < img.h; y++ {
+        row, row2 := 0.0, 0.0
+        for x := 0; x < img.w; x++ {
+            v := img.pix[y*img.w+x]
+            row += v
+            row2 += v * v
+            s.sum[(y+1)*stride+x+1] = s.sum[y*stride+x+1] + row
+            s.sum2[(y+1)*stride+x+1] = s.sum2[y*stride+x+1] + row2
+        }
+    }
+
+    return s
+}
+
+// positions is the rectangle of the places the template fits at.
+func (s *searcher) positions() image.Rectangle {
+    return image.Rect(0, 0, s.img.w-s.tpl.w+1, s.img.h-s.tpl.h+1)
+}
+
+// score returns the correlation of the template at x, y, from -1 to 1. A
+// window of a single colour scores 0.
+func (s *searcher) score(x, y int) float64 {
+    stride := s.img.w + 1
+    w, h := s.tpl.w, s.tpl.h
+    area := func(t []float64) float64 {
+        return t[(y+h)*stride+x+w] - t[y*stride+x+w] - t[(y+h)*stride+x] + t[y*stride+x]
+    }
+
+    n := float64(w * h)
+    sum := area(s.sum)
+    variance := area(s.sum2) - sum*sum/n
+    if variance < flat {
+        return 0
+    }
+
+    // The template sums to 0, so the mean of the window drops out
+    cross := 0.0
+    for j := 0; j < h; j++ {
+        row := s.img.pix[(y+j)*s.img.w+x:]
+        trow := s.tpl.pix[j*w:]
+        for i := 0; i < w; i++ {
+            cross += row[i] * trow[i]
+        }
+    }
+
+    return cross / math.Sqrt(variance*s.norm)
+}
+
+// best returns the best scoring position within r.
+func (s *searcher) best(r image.Rectangle) (image.Point, float64) {
+    r = r.Intersect(s.positions())
+    at, best := r.Min, math.Inf(-1)
+    for y := r.Min.Y; y < r.Max.Y; y++ {
+        for x := r.Min.X; x < r.Max.X; x++ {
+            if v := s.score(x, y); v > best {
+                at, best = image.Pt(x, y), v
+            }
+        }
+    }
+    return at, best
+}
+
+// Coarse search: the largest factor the images are shrunk by first, and
+// how many of the best places found are looked at closely.
+const (
+    maxShrink  = 4
+    minShrunk  = 8
+    candidates = 8
+)
+
+// crop returns g without its first x columns and y rows.
+func (g gray) crop(x, y int) gray {
+    c := gray{g.w - x, g.h - y, make([]float64, 0, (g.w-x)*(g.h-y))}
+    for j := y; j < g.h; j++ {
+        c.pix = append(c.pix, g.pix[j*g.w+x:(j+1)*g.w]...)
+    }
+    return c
+}
+
+// matcher holds what is worked out of a template once, to look for it in
+// many frames.
+type matcher struct {
+    size image.Point
+    full *pattern
+
+    // The factor the coarse search shrinks by, and the template shrunk
+    // from every offset modulo it, nil where it is flat
+    f      int
+    coarse []*pattern
+}
+
+func newMatcher(template image.Image) (*matcher, error) {
+    tr := template.Bounds()
+    g := toGray(template, tr)
+
+    full, err := newPattern(g)
+    if err != nil {
+        return nil, err
+    }
+    m := &matcher{size: tr.Size(), full: full, f: 1}
+
+    for m.f*2 <= maxShrink && tr.Dx()/(m.f*2) >= minShrunk && tr.Dy()/(m.f*2) >= minShrunk {
+        m.f *= 2
+    }
+    if m.f > 1 {
+        for oy := 0; oy < m.f; oy++ {
+            for ox := 0; ox < m.f; ox++ {
+                // A template only made of fine detail is flat once shrunk
+                p, _ := newPattern(full.tpl.crop(ox, oy).shrink(m.f))
+                m.coarse = append(m.coarse, p)
+            }
+        }
+    }
+
+    return m, nil
+}
+
+// fits tells if the template fits in r.
+func (m *matcher) fits(r image.Rectangle) bool {
+    return m.size.X <= r.Dx() && m.size.Y <= r.Dy()
+}
+
+// Match finds where template looks the most like the part of img within
+// region, or anywhere in img if region is empty. It returns where it is
+// and the normalized cross-correlation of their grayscale there, from -1
+// to 1: 1 is a perfect match, however brighter or more contrasted img is.
+//
+// Large searches are done on shrunk images first, then refined around the
+// best places found.
+func Match(img, template image.Image, region image.Rectangle) (image.Rectangle, float64, error) {
+    m, err := newMatcher(template)
+    if err != nil {
+        return image.Rectangle{}, 0, err
+    }
+    return m.match(img, region)
+}
+
+func (m *matcher) match(img image.Image, region image.Rectangle) (image.Rectangle, float64, error) {
+    if region.Empty() {
+        region = img.Bounds()
+    }
+    region = region.Intersect(img.Bounds())
+
+    if !m.fits(region) {
+        return image.Rectangle{}, 0, errTooBig
+    }
+
+    full := newSearcher(toGray(img, region), m.full)
+
+    var at image.Point
+    var score float64
+    if m.f > 1 {
+        at, score = m.coarseSearch(full)
+    } else {
+        at, score = full.best(full.positions())
+    }
+
+    match := image.Rectangle{Max: m.size}.Add(region.Min).Add(at)
+    return match, score, nil
+}
+
+type place struct {
+    at    image.Point
+    score float64
+}
+
+// coarseSearch shrinks the image by f to find the best places quickly
+// with the shrunk templates, then searches full around them. The template
+// is shrunk from every offset modulo f, so that its blocks line up with
+// those of the image wherever it is.
+func (m *matcher) coarseSearch(full *searcher) (image.Point, float64) {
+    f := m.f
+    small := full.img.shrink(f)
+
+    var top []place
+    for oy := 0; oy < f; oy++ {
+        for ox := 0; ox < f; ox++ {
+            p := m.coarse[oy*f+ox]
+            if p == nil {
+                continue
+            }
+            coarse := newSearcher(small, p)
+            r := coarse.positions()
+            for y := r.Min.Y; y < r.Max.Y; y++ {
+                for x := r.Min.X; x < r.Max.X; x++ {
+                    at := image.Pt(x*f-ox, y*f-oy)
+                    if at.In(full.positions()) {
+                        top = keepBest(top, place{at, coarse.score(x, y)}, f)
+                    }
+                }
+            }
+        }
+    }
+
+    if len(top) == 0 {
+        return full.best(full.positions())
+    }
+
+    at, best := image.Point{}, math.Inf(-1)
+    for _, p := range top {
+        window := image.Rectangle{Min: p.at.Sub(image.Pt(1, 1)), Max: p.at.Add(image.Pt(2, 2))}
+        if a, v := full.best(window); v > best {
+            at, best = a, v
+        }
+    }
+    return at, best
+}
+
+// keepBest adds p to the best places, sorted by score, unless a better
+// one is nearer than d: they are the same match.
+func keepBest(top []place, p place, d int) []place {
+    if len(top) == candidates && p.score <= top[len(top)-1].score {
+        return top
+    }
+
+    for i, q := range top {
+        diff := p.at.Sub(q.at)
+        if diff.X > -d && diff.X < d && diff.Y > -d && diff.Y < d {
+            if p.score <= q.score {
+                return top
+            }
+            top = append(top[:i], top[i+1:]...)
+            break
+        }
+    }
+
+    i := sort.Search(len(top), func(i int) bool { return top[i].score < p.score })
+    top = append(top, place{})
+    copy(top[i+1:], top[i:])
+    top[i] = p
+
+    if len(top) > candidates {
+        top = top[:candidates]
+    }
+    return top
+}
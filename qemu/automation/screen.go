@@ -0,0 +1,227 @@
+// Package automation waits for things to show on the screen of a console,
+// to drive a guest from a test: wait for the installer to show a button,
+// click it with the Mouse of the console, wait for the next page, type on
+// its Keyboard.
+//
+// A Screen follows the console through a framebuffer, and only looks at
+// the frame again when the damage QEMU reports touches the part being
+// waited on, at most ten times a second. The guest cursor is not part of
+// what is looked at. WaitForImage, WaitForStable and WaitForChange wait
+// once on a console without a Screen to keep.
+package automation
+
+import (
+    "bytes"
+    "context"
+    "image"
+    "time"
+
+    "qemu"
+    "qemu/framebuffer"
+)
+
+type Screen struct {
+    console *qemu.Console
+    fb      *framebuffer.Framebuffer
+}
+
+// New starts following the screen of console.
+func New(console *qemu.Console) (*Screen, error) {
+    s := &Screen{console: console, fb: framebuffer.New()}
+    if err := console.RegisterListener(s.fb); err != nil {
+        return nil, err
+    }
+    return s, nil
+}
+
+// Close stops following the console.
+func (s *Screen) Close() error {
+    s.console.UnregisterListener(s.fb)
+    return s.fb.Close()
+}
+
+// Framebuffer returns the framebuffer the screen is followed in, to take
+// screenshots of it.
+func (s *Screen) Framebuffer() *framebuffer.Framebuffer {
+    return s.fb
+}
+
+// checkInterval is the least time between two checks of the frame, the
+// damage coming meanwhile is looked at at once
+const checkInterval = 100 * time.Millisecond
+
+// changes calls check with the frame whenever it changes within region, or
+// anywhere if region is empty, and once first if there is a frame already.
+// It returns when check says done or ctx is done.
+func (s *Screen) changes(ctx context.Context, region image.Rectangle, check func(img *image.RGBA) bool) error {
+    ctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+
+    damage := s.fb.Watch(ctx)
+
+    // A busy screen is checked every checkInterval, not after every damage
+    var changed bool
+    var wait <-chan time.Time
+    for {
+        select {
+        case d, ok := <-damage:
+            if !ok {
+                return ctx.Err()
+            }
+            if !d.Resized && (d.Rect.Empty() || !region.Empty() && !d.Rect.Overlaps(region)) {
+                continue
+            }
+            changed = true
+        case <-wait:
+            wait = nil
+        }
+
+        if !changed || wait != nil {
+            continue
+        }
+        changed = false
+
+        img, _ := s.fb.Snapshot().(*image.RGBA)
+        if img != nil && check(img) {
+            return nil
+        }
+        wait = time.After(checkInterval)
+    }
+}
+
+// WaitForImage waits until template shows within region, or anywhere on
+// the screen if region is empty, and returns where. It matches when the
+// normalized cross-correlation of their grayscale reaches threshold, 0.9
+// being a good start: see Match.
+func (s *Screen) WaitForImage(ctx context.Context, template image.Image, region image.Rectangle, threshold float64) (image.Rectangle, error) {
+    m, err := newMatcher(template)
+    if err != nil {
+        return image.Rectangle{}, err
+    }
+    // The screen may grow, but not the region
+    if !region.Empty() && !m.fits(region) {
+        return image.Rectangle{}, errTooBig
+    }
+
+    var found image.Rectangle
+    var matchErr error
+
+    err = s.changes(ctx, region, func(img *image.RGBA) bool {
+        r, score, err := m.match(img, region)
+        if err == errTooBig {
+            // The screen may grow yet
+            return false
+        }
+        if err != nil {
+            matchErr = err
+            return true
+        }
+
+        found = r
+        return score >= threshold
+    })
+    if matchErr != nil {
+        return image.Rectangle{}, matchErr
+    }
+    return found, err
+}
+
+// WaitForStable waits until the screen has not changed for d. Only pixel
+// changes count, not the cursor moving.
+func (s *Screen) WaitForStable(ctx context.Context, d time.Duration) error {
+    ctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+
+    damage := s.fb.Watch(ctx)
+
+    // Stopped until there is a frame
+    timer := time.NewTimer(d)
+    if s.fb.Size() == (image.Point{}) {
+        timer.Stop()
+    }
+    defer timer.Stop()
+
+    for {
+        select {
+        case dmg := <-damage:
+            if dmg.Rect.Empty() && !dmg.Resized {
+                continue
+            }
+            timer.Reset(d)
+        case <-timer.C:
+            return nil
+        case <-ctx.Done():
+            return ctx.Err()
+        }
+    }
+}
+
+// WaitForChange waits until the pixels within region, or anywhere on the
+// screen if region is empty, are not what they were when it was called.
+// Before the first frame any frame is a change.
+func (s *Screen) WaitForChange(ctx context.Context, region image.Rectangle) error {
+    before, _ := s.fb.Snapshot().(*image.RGBA)
+
+    return s.changes(ctx, region, func(img *image.RGBA) bool {
+        if before == nil || img.Rect != before.Rect {
+            return true
+        }
+
+        r := img.Rect
+        if !region.Empty() {
+            r = r.Intersect(region)
+        }
+        for y := r.Min.Y; y < r.Max.Y; y++ {
+            i, j := img.PixOffset(r.Min.X, y), img.PixOffset(r.Max.X, y)
+            if !bytes.Equal(img.Pix[i:j], before.Pix[i:j]) {
+                return true
+            }
+        }
+        return false
+    })
+}
+
+// withScreen runs wait on a new Screen of console.
+func withScreen(console *qemu.Console, wait func(s *Screen) error) error {
+    s, err := New(console)
+    if err != nil {
+        return err
+    }
+    defer s.Close()
+
+    return wait(s)
+}
+
+// WaitForImage waits until template shows on the screen of console, see
+// Screen.WaitForImage.
+func WaitForImage(ctx context.Context, console *qemu.Console, template image.Image, region image.Rectangle, threshold float64) (image.Rectangle, error) {
+    var found image.Rectangle
+    err := withScreen(console, func(s *Screen) error {
+        var err error
+        found, err = s.WaitForImage(ctx, template, region, threshold)
+        return err
+    })
+    return found, err
+}
+
+// WaitForStable waits until the screen of console has not changed for d,
+// see Screen.WaitForStable.
+func WaitForStable(ctx context.Context, console *qemu.Console, d time.Duration) error {
+    return withScreen(console, func(s *Screen) error {
+        return s.WaitForStable(ctx, d)
+    })
+}
+
+// WaitForChange waits until the pixels of the screen of console change
+// within region, or anywhere if region is empty, from what they are once
+// it shows a first frame. See Screen.WaitForChange.
+func WaitForChange(ctx context.Context, console *qemu.Console, region image.Rectangle) error {
+    return withScreen(console, func(s *Screen) error {
+        // QEMU sends the current frame to a new listener first
+        err := s.changes(ctx, image.Rectangle{}, func(*image.RGBA) bool { return true })
+        if err != nil {
+            return err
+        }
+        return s.WaitForChange(ctx, region)
+    })
+}
@@ -0,0 +1,213 @@
+package automation_test
+
+import (
+    "context"
+    "encoding/binary"
+    "image"
+    "image/color"
+    "testing"
+    "time"
+
+    "qemu"
+    "qemu/automation"
+    "qemu/qemutest"
+)
+
+// connect runs register with the first console of a fake QEMU, and
+// returns the listener it registers.
+func connect(t *testing.T, ctx context.Context, register func(*qemu.Console)) *qemutest.Listener {
+    t.Helper()
+
+    srv, err := qemutest.NewServer(qemutest.VM{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { srv.Close() })
+
+    vm, err := qemu.Connect(ctx, qemu.WithAddress(srv.Address()))
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(vm.Close)
+
+    console, err := vm.GetConsole(0)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    go register(console)
+    l, err := srv.WaitListener(ctx, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return l
+}
+
+// pattern is a frame with some shapes on a gray background.
+func pattern(w, h int) *image.RGBA {
+    img := image.NewRGBA(image.Rect(0, 0, w, h))
+    for y := 0; y < h; y++ {
+        for x := 0; x < w; x++ {
+            v := uint8(0x80)
+            if (x/7+y/5)%3 == 0 {
+                v = uint8(x * y)
+            }
+            img.SetRGBA(x, y, color.RGBA{v, v / 2, 0xff - v, 0xff})
+        }
+    }
+    return img
+}
+
+// scanout plays img as a new frame.
+func scanout(t *testing.T, l *qemutest.Listener, img *image.RGBA) {
+    t.Helper()
+
+    w, h := img.Rect.Dx(), img.Rect.Dy()
+    data := make([]byte, 0, w*h*4)
+    for y := 0; y < h; y++ {
+        for x := 0; x < w; x++ {
+            c := img.RGBAAt(x, y)
+            data = binary.LittleEndian.AppendUint32(data, uint32(c.R)<<16|uint32(c.G)<<8|uint32(c.B))
+        }
+    }
+
+    err := l.Play(qemutest.Scanout{Width: uint32(w), Height: uint32(h), Stride: uint32(w * 4), Format: qemutest.FormatX8R8G8B8, Data: data})
+    if err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestMatch(t *testing.T) {
+    img := pattern(200, 150)
+
+    // Large enough to be searched shrunk first, and at an odd place
+    want := image.Rect(61, 37, 101, 69)
+    template := img.SubImage(want)
+
+    found, score, err := automation.Match(img, template, image.Rectangle{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if found != want || score < 0.999 {
+        t.Errorf("found at %v with %v, want %v", found, score, want)
+    }
+
+    // Only looked for within the region
+    if found, _, _ = automation.Match(img, template, image.Rect(100, 0, 200, 150)); found.Min.X < 100 {
+        t.Errorf("found at %v outside the region", found)
+    }
+
+    flat := image.NewRGBA(image.Rect(0, 0, 10, 10))
+    if _, _, err := automation.Match(img, flat, image.Rectangle{}); err != automation.ErrFlatTemplate {
+        t.Errorf("flat template returned %v", err)
+    }
+}
+
+func TestWaitForImage(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    img := pattern(200, 150)
+    want := image.Rect(10, 100, 40, 130)
+
+    result := make(chan error, 1)
+    var found image.Rectangle
+    l := connect(t, ctx, func(console *qemu.Console) {
+        var err error
+        found, err = automation.WaitForImage(ctx, console, img.SubImage(want), image.Rectangle{}, 0.99)
+        result <- err
+    })
+
+    // Not there yet
+    scanout(t, l, pattern(20, 20))
+    scanout(t, l, img)
+
+    if err := <-result; err != nil {
+        t.Fatal(err)
+    }
+    if found != want {
+        t.Errorf("found at %v, want %v", found, want)
+    }
+}
+
+func TestWaitForImageTooBig(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    srv, err := qemutest.NewServer(qemutest.VM{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer srv.Close()
+
+    vm, err := qemu.Connect(ctx, qemu.WithAddress(srv.Address()))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer vm.Close()
+
+    console, err := vm.GetConsole(0)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // The region cannot grow, no need to wait for a frame
+    _, err = automation.WaitForImage(ctx, console, pattern(30, 30), image.Rect(0, 0, 20, 50), 0.9)
+    if err == nil || ctx.Err() != nil {
+        t.Errorf("template bigger than the region returned %v once ctx is %v", err, ctx.Err())
+    }
+}
+
+func TestWaitForChange(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    result := make(chan error, 1)
+    l := connect(t, ctx, func(console *qemu.Console) {
+        result <- automation.WaitForChange(ctx, console, image.Rect(0, 0, 4, 4))
+    })
+
+    scanout(t, l, pattern(20, 20))
+
+    // Outside the region
+    err := l.Play(qemutest.Update{X: 10, Y: 10, Width: 1, Height: 1, Stride: 4, Format: qemutest.FormatX8R8G8B8, Data: []byte{1, 2, 3, 0}})
+    if err != nil {
+        t.Fatal(err)
+    }
+    select {
+    case err := <-result:
+        t.Fatalf("returned %v on a change outside the region", err)
+    case <-time.After(200 * time.Millisecond):
+    }
+
+    err = l.Play(qemutest.Update{X: 1, Y: 1, Width: 1, Height: 1, Stride: 4, Format: qemutest.FormatX8R8G8B8, Data: []byte{1, 2, 3, 0}})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := <-result; err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestWaitForStable(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    result := make(chan error, 1)
+    start := time.Now()
+    l := connect(t, ctx, func(console *qemu.Console) {
+        result <- automation.WaitForStable(ctx, console, 300*time.Millisecond)
+    })
+
+    scanout(t, l, pattern(20, 20))
+    time.Sleep(100 * time.Millisecond)
+    scanout(t, l, pattern(20, 21))
+    changed := time.Now()
+
+    if err := <-result; err != nil {
+        t.Fatal(err)
+    }
+    if d := time.Since(changed); d < 300*time.Millisecond {
+        t.Errorf("stable %v after the last change, %v after the start", d, time.Since(start))
+    }
+}
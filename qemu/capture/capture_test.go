@@ -0,0 +1,263 @@
+package capture
+
+import (
+    "bytes"
+    "compress/zlib"
+    "context"
+    "errors"
+    "fmt"
+    "hash/crc32"
+    "image"
+    "slices"
+    "strings"
+    "syscall"
+    "testing"
+    "time"
+
+    "github.com/godbus/dbus/v5"
+
+    "qemu"
+    "qemu/framebuffer"
+    "qemu/qemutest"
+)
+
+// logger notes the calls a framebuffer gets, each with a checksum of the
+// frame and cursor it left.
+type logger struct {
+    *framebuffer.Framebuffer
+    calls []string
+}
+
+func newLogger() *logger {
+    return &logger{Framebuffer: framebuffer.New()}
+}
+
+func (l *logger) log(err *dbus.Error, format string, args ...interface{}) *dbus.Error {
+    call := fmt.Sprintf(format, args...)
+
+    if img, ok := l.Snapshot().(*image.RGBA); ok {
+        call += fmt.Sprintf(" frame %x", crc32.ChecksumIEEE(img.Pix))
+    }
+    if c := l.Cursor(); c.Image != nil {
+        call += fmt.Sprintf(" cursor %x at %v", crc32.ChecksumIEEE(c.Image.Pix), c.Pos)
+    }
+    if err != nil {
+        call += " failed: " + err.Error()
+    }
+
+    l.calls = append(l.calls, call)
+    return err
+}
+
+func (l *logger) Scanout(width, height, stride, format uint32, data []byte) *dbus.Error {
+    return l.log(l.Framebuffer.Scanout(width, height, stride, format, data), "Scanout %dx%d", width, height)
+}
+
+func (l *logger) Update(x, y, width, height int32, stride, format uint32, data []byte) *dbus.Error {
+    return l.log(l.Framebuffer.Update(x, y, width, height, stride, format, data), "Update %d,%d %dx%d", x, y, width, height)
+}
+
+func (l *logger) ScanoutMap(fd dbus.UnixFD, offset, width, height, stride, format uint32) *dbus.Error {
+    return l.log(l.Framebuffer.ScanoutMap(fd, offset, width, height, stride, format), "ScanoutMap %dx%d", width, height)
+}
+
+func (l *logger) UpdateMap(x, y, width, height int32) *dbus.Error {
+    return l.log(l.Framebuffer.UpdateMap(x, y, width, height), "UpdateMap %d,%d %dx%d", x, y, width, height)
+}
+
+func (l *logger) CursorDefine(width, height, hot_x, hot_y int, data []byte) *dbus.Error {
+    return l.log(l.Framebuffer.CursorDefine(width, height, hot_x, hot_y, data), "CursorDefine %dx%d", width, height)
+}
+
+func (l *logger) MouseSet(x, y, on int) *dbus.Error {
+    return l.log(l.Framebuffer.MouseSet(x, y, on), "MouseSet %d,%d %d", x, y, on)
+}
+
+// gradient returns w x h X8R8G8B8 pixels, different for every seed.
+func gradient(w, h int, seed byte) []byte {
+    data := make([]byte, 0, w*h*4)
+    for y := 0; y < h; y++ {
+        for x := 0; x < w; x++ {
+            data = append(data, byte(x)+seed, byte(y)*3, seed, 0)
+        }
+    }
+    return data
+}
+
+// record captures the events played on the console of a fake QEMU, as
+// seen by a logger behind the recorder.
+func record(t *testing.T, events ...qemutest.Event) ([]byte, *logger) {
+    t.Helper()
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    srv, err := qemutest.NewServer(qemutest.VM{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer srv.Close()
+
+    vm, err := qemu.Connect(ctx, qemu.WithAddress(srv.Address()))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer vm.Close()
+
+    console, err := vm.GetConsole(0)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var buf bytes.Buffer
+    live := newLogger()
+    defer live.Close()
+
+    r, err := NewRecorder(&buf, live)
+    if err != nil {
+        t.Fatal(err)
+    }
+    listener := r.Listener()
+    if err = console.RegisterListener(listener); err != nil {
+        t.Fatal(err)
+    }
+
+    l, err := srv.WaitListener(ctx, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err = l.Play(events...); err != nil {
+        t.Fatal(err)
+    }
+
+    console.UnregisterListener(listener)
+    if err = r.Close(); err != nil {
+        t.Fatal(err)
+    }
+    return buf.Bytes(), live
+}
+
+func TestRecordReplay(t *testing.T) {
+    capture, live := record(t,
+        qemutest.Scanout{Width: 8, Height: 4, Stride: 32, Format: qemutest.FormatX8R8G8B8, Data: gradient(8, 4, 1)},
+        qemutest.Update{X: 2, Y: 1, Width: 3, Height: 2, Stride: 12, Format: qemutest.FormatX8R8G8B8, Data: gradient(3, 2, 2)},
+        qemutest.CursorDefine{Width: 2, Height: 2, HotX: 1, HotY: 1, Data: gradient(2, 2, 3)},
+        qemutest.MouseSet{X: 3, Y: 2, On: 1},
+        qemutest.ScanoutMap{Width: 8, Height: 5, Stride: 32, Format: qemutest.FormatX8R8G8B8, Data: gradient(8, 5, 4)},
+        qemutest.UpdateMap{X: 1, Y: 3, Width: 4, Height: 2, Data: gradient(4, 2, 5)},
+        qemutest.UpdateMap{X: 0, Y: 0, Width: 8, Height: 1, Data: gradient(8, 1, 6)},
+        qemutest.MouseSet{X: 5, Y: 4, On: 1},
+    )
+
+    want := []string{"Scanout", "Update", "CursorDefine", "MouseSet", "ScanoutMap", "UpdateMap", "UpdateMap", "MouseSet"}
+    if len(live.calls) != len(want) {
+        t.Fatalf("recorded %q", live.calls)
+    }
+    for i, call := range live.calls {
+        if !strings.HasPrefix(call, want[i]+" ") || strings.Contains(call, "failed") {
+            t.Fatalf("call %d is %q, want a %s", i, call, want[i])
+        }
+    }
+
+    replayed := newLogger()
+    defer replayed.Close()
+    if err := Replay(context.Background(), bytes.NewReader(capture), replayed, false); err != nil {
+        t.Fatal(err)
+    }
+    if !slices.Equal(replayed.calls, live.calls) {
+        t.Errorf("replayed\n%q\nrecorded\n%q", replayed.calls, live.calls)
+    }
+}
+
+// compress makes a capture of records.
+func compress(records []byte) []byte {
+    var buf bytes.Buffer
+    buf.WriteString(magic)
+    buf.WriteByte(version)
+
+    z := zlib.NewWriter(&buf)
+    z.Write(records)
+    z.Close()
+    return buf.Bytes()
+}
+
+func TestReplayBroken(t *testing.T) {
+    capture, _ := record(t,
+        qemutest.Scanout{Width: 8, Height: 4, Stride: 32, Format: qemutest.FormatX8R8G8B8, Data: gradient(8, 4, 1)},
+        qemutest.ScanoutMap{Width: 8, Height: 4, Stride: 32, Format: qemutest.FormatX8R8G8B8, Data: gradient(8, 4, 2)},
+        qemutest.UpdateMap{X: 0, Y: 0, Width: 8, Height: 4, Data: gradient(8, 4, 3)},
+    )
+
+    replay := func(capture []byte) error {
+        l := newLogger()
+        defer l.Close()
+        return Replay(context.Background(), bytes.NewReader(capture), l, false)
+    }
+
+    // Cut anywhere
+    for n := 0; n < len(capture); n++ {
+        if err := replay(capture[:n]); err == nil {
+            t.Fatalf("capture cut to %d of %d bytes replayed", n, len(capture))
+        }
+    }
+
+    // With a wrong checksum
+    corrupt := bytes.Clone(capture)
+    corrupt[len(corrupt)-1] ^= 0xff
+    if err := replay(corrupt); err == nil {
+        t.Error("capture with a wrong checksum replayed")
+    }
+
+    corrupt = bytes.Clone(capture)
+    corrupt[len(magic)]++
+    if err := replay(corrupt); err == nil {
+        t.Error("capture of the next version replayed")
+    }
+
+    var e encoder
+    e.WriteByte(opScanout)
+    e.uint(0)
+    for _, v := range []uint64{8, 4, 32, uint64(qemutest.FormatX8R8G8B8), maxBytes + 1} {
+        e.uint(v)
+    }
+    if err := replay(compress(e.Bytes())); !errors.Is(err, errTooBig) {
+        t.Errorf("oversized scanout returned %v", err)
+    }
+
+    e.Reset()
+    e.WriteByte(opScanoutMap)
+    e.uint(0)
+    for _, v := range []uint64{7, 0, 8, 4, 32, uint64(qemutest.FormatX8R8G8B8)} {
+        e.uint(v)
+    }
+    if err := replay(compress(e.Bytes())); err == nil {
+        t.Error("scanout of an unknown buffer replayed")
+    }
+
+    e.Reset()
+    e.WriteByte(0xff)
+    e.uint(0)
+    if err := replay(compress(e.Bytes())); err == nil {
+        t.Error("unknown op replayed")
+    }
+}
+
+func TestRecordUnmappable(t *testing.T) {
+    var buf bytes.Buffer
+    r, err := NewRecorder(&buf, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // A pipe has no size to map
+    var p [2]int
+    if err := syscall.Pipe(p[:]); err != nil {
+        t.Fatal(err)
+    }
+    defer syscall.Close(p[1])
+
+    r.ScanoutMap(dbus.UnixFD(p[0]), 0, 8, 4, 32, qemutest.FormatX8R8G8B8)
+    if err := r.Close(); err == nil {
+        t.Error("capture of an unmappable scanout succeeded")
+    }
+}
@@ -0,0 +1,172 @@
+// Package capture records the calls QEMU makes on a display listener to a
+// file, and plays them back on any listener.
+//
+// A capture keeps everything needed to drive a listener the same way
+// again: the pixels sent on the bus, and the contents of the shared memory
+// and DMABUFs QEMU hands out, as they were when each call came. It makes
+// rendering bugs reproducible away from the VM which showed them.
+//
+// The file starts with a magic string and a version byte, the rest is a
+// zlib stream of records. Every record is an op byte, the microseconds
+// since the previous record as a uvarint and the fields of the op:
+// unsigned integers as uvarints, signed ones as varints, booleans as a
+// byte, and byte slices and lists as their length followed by the items.
+package capture
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+)
+
+const magic = "QEMU-DISPLAY-CAPTURE"
+
+const version = 1
+
+const (
+    opScanout byte = iota + 1
+    opUpdate
+    opScanoutMap
+    opUpdateMap
+    opScanoutDMABUF
+    opUpdateDMABUF
+    opScanoutDMABUF2
+    opDisable
+    opMouseSet
+    opCursorDefine
+
+    // opBuffer gives the contents of a new shared buffer: id, data
+    opBuffer
+    // opWrite changes part of a shared buffer: id, offset, data
+    opWrite
+)
+
+// maxBytes bounds the slices read from a capture, which are at most the
+// size of a frame buffer
+const maxBytes = 1 << 30
+
+var errTooBig = errors.New("capture: slice too big")
+
+type encoder struct {
+    bytes.Buffer
+}
+
+func (e *encoder) uint(v uint64) {
+    e.Write(binary.AppendUvarint(e.AvailableBuffer(), v))
+}
+
+func (e *encoder) int(v int64) {
+    e.Write(binary.AppendVarint(e.AvailableBuffer(), v))
+}
+
+func (e *encoder) bool(v bool) {
+    if v {
+        e.WriteByte(1)
+    } else {
+        e.WriteByte(0)
+    }
+}
+
+func (e *encoder) bytes(b []byte) {
+    e.uint(uint64(len(b)))
+    e.Write(b)
+}
+
+func (e *encoder) uints(v []uint32) {
+    e.uint(uint64(len(v)))
+    for _, x := range v {
+        e.uint(uint64(x))
+    }
+}
+
+// decoder reads the fields of records, the first error sticks and makes
+// the rest read as zero.
+type decoder struct {
+    r   *bufio.Reader
+    err error
+}
+
+func (d *decoder) fail(err error) {
+    if d.err == nil {
+        if err == io.EOF {
+            err = io.ErrUnexpectedEOF
+        }
+        d.err = err
+    }
+}
+
+func (d *decoder) uint() uint64 {
+    if d.err != nil {
+        return 0
+    }
+    v, err := binary.ReadUvarint(d.r)
+    if err != nil {
+        d.fail(err)
+    }
+    return v
+}
+
+func (d *decoder) uint32() uint32 {
+    v := d.uint()
+    if v > 1<<32-1 {
+        d.fail(fmt.Errorf("capture: %d does not fit in 32 bits", v))
+    }
+    return uint32(v)
+}
+
+func (d *decoder) int() int64 {
+    if d.err != nil {
+        return 0
+    }
+    v, err := binary.ReadVarint(d.r)
+    if err != nil {
+        d.fail(err)
+    }
+    return v
+}
+
+func (d *decoder) bool() bool {
+    if d.err != nil {
+        return false
+    }
+    b, err := d.r.ReadByte()
+    if err != nil {
+        d.fail(err)
+    }
+    return b != 0
+}
+
+func (d *decoder) bytes() []byte {
+    n := d.uint()
+    if d.err != nil {
+        return nil
+    }
+    if n > maxBytes {
+        d.fail(errTooBig)
+        return nil
+    }
+
+    b := make([]byte, n)
+    if _, err := io.ReadFull(d.r, b); err != nil {
+        d.fail(err)
+        return nil
+    }
+    return b
+}
+
+func (d *decoder) uints() []uint32 {
+    n := d.uint()
+    if n > 64 {
+        d.fail(errTooBig)
+        return nil
+    }
+
+    v := make([]uint32, n)
+    for i := range v {
+        v[i] = d.uint32()
+    }
+    return v
+}
@@ -0,0 +1,456 @@
+package capture
+
+import (
+    "bufio"
+    "compress/zlib"
+    "fmt"
+    "io"
+    "sync"
+    "syscall"
+    "time"
+    "unsafe"
+
+    "github.com/godbus/dbus/v5"
+
+    "qemu"
+)
+
+// DMA_BUF_IOCTL_SYNC and its flags, from linux/dma-buf.h
+const (
+    dmaBufIoctlSync = 0x40086200
+    dmaBufSyncRead  = 1 << 0
+    dmaBufSyncStart = 0 << 2
+    dmaBufSyncEnd   = 1 << 2
+)
+
+// buffer is a shared buffer of the current scanout, mapped to read what
+// the updates change.
+type buffer struct {
+    id   uint32
+    fd   int
+    data []byte
+}
+
+// sync brackets reads of DMABUFs, it fails on anything else, which needs
+// no sync.
+func (b *buffer) sync(flags uint64) {
+    arg := struct{ flags uint64 }{flags}
+    syscall.Syscall(syscall.SYS_IOCTL, uintptr(b.fd), dmaBufIoctlSync, uintptr(unsafe.Pointer(&arg)))
+}
+
+// open maps a copy of fd, which is left unmapped if it is empty.
+func (b *buffer) open(fd int) error {
+    dup, err := syscall.Dup(fd)
+    if err != nil {
+        return err
+    }
+    b.fd = dup
+
+    size, err := syscall.Seek(dup, 0, io.SeekEnd)
+    if err != nil || size == 0 {
+        return err
+    }
+    b.data, err = syscall.Mmap(dup, 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+    return err
+}
+
+func (b *buffer) release() {
+    if b.data != nil {
+        syscall.Munmap(b.data)
+    }
+    syscall.Close(b.fd)
+}
+
+// layout tells where the rows of the scanout are in its first buffer. If
+// flipped they go from the bottom up.
+type layout struct {
+    offset, stride int
+    y, height      int
+    flipped        bool
+
+    // whole is set when the buffers are written in full on every update,
+    // for multi-planar scanouts
+    whole bool
+}
+
+// rows returns the bytes holding rows y to y+h of the scanout.
+func (l layout) rows(y, h int) (start, end int) {
+    first := l.y + y
+    if l.flipped {
+        first = l.height - l.y - y - h
+    }
+    return l.offset + first*l.stride, l.offset + (first+h)*l.stride
+}
+
+// Recorder writes the calls it gets to a capture, and passes them on to a
+// listener if it has one.
+type Recorder struct {
+    listener qemu.DisplayListener
+
+    mu      sync.Mutex
+    out     *bufio.Writer
+    z       *zlib.Writer
+    enc     encoder
+    last    time.Time
+    err     error
+    nextID  uint32
+    buffers []*buffer
+    layout  layout
+}
+
+// NewRecorder writes a capture to w. listener gets the calls after they
+// are recorded, it can be nil to only record.
+func NewRecorder(w io.Writer, listener qemu.DisplayListener) (*Recorder, error) {
+    out := bufio.NewWriter(w)
+    out.WriteString(magic)
+    out.WriteByte(version)
+
+    z, err := zlib.NewWriterLevel(out, zlib.BestSpeed)
+    if err != nil {
+        return nil, err
+    }
+
+    return &Recorder{listener: listener, out: out, z: z, last: time.Now()}, nil
+}
+
+// Listener returns what to register on the console: the recorder, taking
+// the same kinds of scanouts as the listener it passes calls to.
+func (r *Recorder) Listener() qemu.DisplayListener {
+    if r.listener == nil {
+        return r
+    }
+
+    _, unixMap := r.listener.(qemu.DisplayListenerUnixMap)
+    _, dmabuf2 := r.listener.(qemu.DisplayListenerUnixScanoutDMABUF2)
+    switch {
+    case unixMap && dmabuf2:
+        return r
+    case unixMap:
+        return struct {
+            qemu.DisplayListener
+            qemu.DisplayListenerUnixMap
+        }{r, r}
+    case dmabuf2:
+        return struct {
+            qemu.DisplayListener
+            qemu.DisplayListenerUnixScanoutDMABUF2
+        }{r, r}
+    default:
+        return struct{ qemu.DisplayListener }{r}
+    }
+}
+
+// Close finishes the capture, without closing the writer given to
+// NewRecorder. It returns the first error writing it or mapping a scanout
+// buffer, nothing is recorded after that error.
+func (r *Recorder) Close() error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    r.releaseLocked()
+
+    if err := r.z.Close(); r.err == nil {
+        r.err = err
+    }
+    if err := r.out.Flush(); r.err == nil {
+        r.err = err
+    }
+    return r.err
+}
+
+// beginLocked starts a record of op.
+func (r *Recorder) beginLocked(op byte) {
+    now := time.Now()
+    r.enc.Reset()
+    r.enc.WriteByte(op)
+    r.enc.uint(uint64(now.Sub(r.last).Microseconds()))
+    r.last = r.last.Add(now.Sub(r.last).Truncate(time.Microsecond))
+}
+
+func (r *Recorder) endLocked() {
+    if r.err != nil {
+        return
+    }
+    _, r.err = r.z.Write(r.enc.Bytes())
+}
+
+func (r *Recorder) releaseLocked() {
+    for _, b := range r.buffers {
+        b.release()
+    }
+    r.buffers = nil
+}
+
+// addBufferLocked records the contents of fd as a new buffer of the
+// scanout, and keeps a copy of fd to read the updates from.
+func (r *Recorder) addBufferLocked(fd int) {
+    b := &buffer{id: r.nextID, fd: -1}
+    r.nextID++
+    r.buffers = append(r.buffers, b)
+
+    if err := b.open(fd); err != nil {
+        // The capture would go on without the pixels, stop it instead
+        if r.err == nil {
+            r.err = fmt.Errorf("cannot map scanout buffer: %w", err)
+        }
+        return
+    }
+
+    b.sync(dmaBufSyncStart | dmaBufSyncRead)
+    r.beginLocked(opBuffer)
+    r.enc.uint(uint64(b.id))
+    r.enc.bytes(b.data)
+    r.endLocked()
+    b.sync(dmaBufSyncEnd | dmaBufSyncRead)
+}
+
+// writeLocked records bytes start to end of b as they are now.
+func (r *Recorder) writeLocked(b *buffer, start, end int) {
+    start, end = max(start, 0), min(end, len(b.data))
+    if start >= end {
+        return
+    }
+
+    b.sync(dmaBufSyncStart | dmaBufSyncRead)
+    r.beginLocked(opWrite)
+    r.enc.uint(uint64(b.id))
+    r.enc.uint(uint64(start))
+    r.enc.bytes(b.data[start:end])
+    r.endLocked()
+    b.sync(dmaBufSyncEnd | dmaBufSyncRead)
+}
+
+// updateLocked records what changed in the buffers for an update of rows
+// y to y+h.
+func (r *Recorder) updateLocked(y, h int) {
+    if len(r.buffers) == 0 {
+        return
+    }
+
+    if r.layout.whole {
+        for _, b := range r.buffers {
+            r.writeLocked(b, 0, len(b.data))
+        }
+        return
+    }
+
+    start, end := r.layout.rows(y, h)
+    r.writeLocked(r.buffers[0], start, end)
+}
+
+// forwardFDs gives the fds to the listener through call, or closes them
+// if there is no listener.
+func (r *Recorder) forwardFDs(call func() *dbus.Error, fds ...dbus.UnixFD) *dbus.Error {
+    if r.listener == nil {
+        for _, fd := range fds {
+            syscall.Close(int(fd))
+        }
+        return nil
+    }
+    return call()
+}
+
+func (r *Recorder) Scanout(width, height, stride, format uint32, data []byte) *dbus.Error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    r.releaseLocked()
+    r.beginLocked(opScanout)
+    r.enc.uint(uint64(width))
+    r.enc.uint(uint64(height))
+    r.enc.uint(uint64(stride))
+    r.enc.uint(uint64(format))
+    r.enc.bytes(data)
+    r.endLocked()
+
+    if r.listener == nil {
+        return nil
+    }
+    return r.listener.Scanout(width, height, stride, format, data)
+}
+
+func (r *Recorder) Update(x, y, width, height int32, stride, format uint32, data []byte) *dbus.Error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    r.beginLocked(opUpdate)
+    r.enc.int(int64(x))
+    r.enc.int(int64(y))
+    r.enc.int(int64(width))
+    r.enc.int(int64(height))
+    r.enc.uint(uint64(stride))
+    r.enc.uint(uint64(format))
+    r.enc.bytes(data)
+    r.endLocked()
+
+    if r.listener == nil {
+        return nil
+    }
+    return r.listener.Update(x, y, width, height, stride, format, data)
+}
+
+func (r *Recorder) ScanoutMap(fd dbus.UnixFD, offset, width, height, stride, format uint32) *dbus.Error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    r.releaseLocked()
+    r.addBufferLocked(int(fd))
+    r.layout = layout{offset: int(offset), stride: int(stride), height: int(height)}
+
+    r.beginLocked(opScanoutMap)
+    r.enc.uint(uint64(r.buffers[0].id))
+    r.enc.uint(uint64(offset))
+    r.enc.uint(uint64(width))
+    r.enc.uint(uint64(height))
+    r.enc.uint(uint64(stride))
+    r.enc.uint(uint64(format))
+    r.endLocked()
+
+    return r.forwardFDs(func() *dbus.Error {
+        return r.listener.(qemu.DisplayListenerUnixMap).ScanoutMap(fd, offset, width, height, stride, format)
+    }, fd)
+}
+
+func (r *Recorder) UpdateMap(x, y, width, height int32) *dbus.Error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    r.updateLocked(int(y), int(height))
+    r.beginLocked(opUpdateMap)
+    r.enc.int(int64(x))
+    r.enc.int(int64(y))
+    r.enc.int(int64(width))
+    r.enc.int(int64(height))
+    r.endLocked()
+
+    if r.listener == nil {
+        return nil
+    }
+    return r.listener.(qemu.DisplayListenerUnixMap).UpdateMap(x, y, width, height)
+}
+
+func (r *Recorder) ScanoutDMABUF(fd dbus.UnixFD, width, height, stride, fourcc uint32, modifier uint64, y0_top bool) *dbus.Error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    r.releaseLocked()
+    r.addBufferLocked(int(fd))
+    r.layout = layout{stride: int(stride), height: int(height), flipped: !y0_top}
+
+    r.beginLocked(opScanoutDMABUF)
+    r.enc.uint(uint64(r.buffers[0].id))
+    r.enc.uint(uint64(width))
+    r.enc.uint(uint64(height))
+    r.enc.uint(uint64(stride))
+    r.enc.uint(uint64(fourcc))
+    r.enc.uint(modifier)
+    r.enc.bool(y0_top)
+    r.endLocked()
+
+    return r.forwardFDs(func() *dbus.Error {
+        return r.listener.ScanoutDMABUF(fd, width, height, stride, fourcc, modifier, y0_top)
+    }, fd)
+}
+
+func (r *Recorder) ScanoutDMABUF2(fd []dbus.UnixFD, x, y, width, height uint32, offset, stride []uint32, num_planes, fourcc, backing_width, backing_height uint32, modifier uint64, y0_top bool) *dbus.Error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    r.releaseLocked()
+    ids := make([]uint32, len(fd))
+    for i, v := range fd {
+        r.addBufferLocked(int(v))
+        ids[i] = r.buffers[i].id
+    }
+
+    r.layout = layout{whole: true}
+    if len(fd) == 1 && num_planes == 1 && len(offset) > 0 && len(stride) > 0 {
+        r.layout = layout{offset: int(offset[0]), stride: int(stride[0]), y: int(y), height: int(backing_height), flipped: !y0_top}
+    }
+
+    r.beginLocked(opScanoutDMABUF2)
+    r.enc.uints(ids)
+    r.enc.uint(uint64(x))
+    r.enc.uint(uint64(y))
+    r.enc.uint(uint64(width))
+    r.enc.uint(uint64(height))
+    r.enc.uints(offset)
+    r.enc.uints(stride)
+    r.enc.uint(uint64(num_planes))
+    r.enc.uint(uint64(fourcc))
+    r.enc.uint(uint64(backing_width))
+    r.enc.uint(uint64(backing_height))
+    r.enc.uint(modifier)
+    r.enc.bool(y0_top)
+    r.endLocked()
+
+    return r.forwardFDs(func() *dbus.Error {
+        return r.listener.(qemu.DisplayListenerUnixScanoutDMABUF2).ScanoutDMABUF2(fd, x, y, width, height, offset, stride, num_planes, fourcc, backing_width, backing_height, modifier, y0_top)
+    }, fd...)
+}
+
+func (r *Recorder) UpdateDMABUF(x, y, width, height int32) *dbus.Error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    r.updateLocked(int(y), int(height))
+    r.beginLocked(opUpdateDMABUF)
+    r.enc.int(int64(x))
+    r.enc.int(int64(y))
+    r.enc.int(int64(width))
+    r.enc.int(int64(height))
+    r.endLocked()
+
+    if r.listener == nil {
+        return nil
+    }
+    return r.listener.UpdateDMABUF(x, y, width, height)
+}
+
+func (r *Recorder) Disable() *dbus.Error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    r.releaseLocked()
+    r.beginLocked(opDisable)
+    r.endLocked()
+
+    if r.listener == nil {
+        return nil
+    }
+    return r.listener.Disable()
+}
+
+func (r *Recorder) MouseSet(x, y, on int) *dbus.Error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    r.beginLocked(opMouseSet)
+    r.enc.int(int64(x))
+    r.enc.int(int64(y))
+    r.enc.int(int64(on))
+    r.endLocked()
+
+    if r.listener == nil {
+        return nil
+    }
+    return r.listener.MouseSet(x, y, on)
+}
+
+func (r *Recorder) CursorDefine(width, height, hot_x, hot_y int, data []byte) *dbus.Error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    r.beginLocked(opCursorDefine)
+    r.enc.int(int64(width))
+    r.enc.int(int64(height))
+    r.enc.int(int64(hot_x))
+    r.enc.int(int64(hot_y))
+    r.enc.bytes(data)
+    r.endLocked()
+
+    if r.listener == nil {
+        return nil
+    }
+    return r.listener.CursorDefine(width, height, hot_x, hot_y, data)
+}
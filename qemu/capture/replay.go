@@ -0,0 +1,286 @@
+package capture
+
+import (
+    "bufio"
+    "compress/zlib"
+    "context"
+    "fmt"
+    "io"
+    "os"
+    "syscall"
+    "time"
+
+    "github.com/godbus/dbus/v5"
+
+    "qemu"
+)
+
+// player keeps the shared buffers of a capture being replayed in unlinked
+// files, the listener gets a new fd of them with every scanout.
+type player struct {
+    d        decoder
+    listener qemu.DisplayListener
+    buffers  map[uint32]*os.File
+}
+
+// Replay makes the calls recorded in r on listener, spaced as they came if
+// realtime is set, as fast as possible otherwise. It returns at the end of
+// the capture, or with an error if the capture is broken, ctx is done or
+// listener cannot take a recorded call. What the calls return is ignored,
+// as QEMU does.
+//
+// Shared memory and DMABUFs are given to listener as files holding what
+// they held, which will not do for a listener importing DMABUFs into the
+// GPU.
+func Replay(ctx context.Context, r io.Reader, listener qemu.DisplayListener, realtime bool) error {
+    br := bufio.NewReader(r)
+
+    head := make([]byte, len(magic)+1)
+    if _, err := io.ReadFull(br, head); err != nil || string(head[:len(magic)]) != magic {
+        return fmt.Errorf("not a display capture")
+    }
+    if head[len(magic)] != version {
+        return fmt.Errorf("unsupported display capture version %d", head[len(magic)])
+    }
+
+    z, err := zlib.NewReader(br)
+    if err != nil {
+        return err
+    }
+    defer z.Close()
+
+    p := &player{
+        d:        decoder{r: bufio.NewReader(z)},
+        listener: listener,
+        buffers:  map[uint32]*os.File{},
+    }
+    defer p.keep()
+
+    start := time.Now()
+    var at time.Duration
+
+    for {
+        op, err := p.d.r.ReadByte()
+        if err == io.EOF {
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+
+        at += time.Duration(p.d.uint()) * time.Microsecond
+        if wait := time.Until(start.Add(at)); realtime && wait > 0 {
+            timer := time.NewTimer(wait)
+            select {
+            case <-timer.C:
+            case <-ctx.Done():
+                timer.Stop()
+                return ctx.Err()
+            }
+        } else if err := ctx.Err(); err != nil {
+            return err
+        }
+
+        if err := p.play(op); err != nil {
+            return err
+        }
+    }
+}
+
+// keep closes the buffers but ids, which the last scanout uses.
+func (p *player) keep(ids ...uint32) {
+    for id, f := range p.buffers {
+        used := false
+        for _, v := range ids {
+            used = used || v == id
+        }
+        if !used {
+            f.Close()
+            delete(p.buffers, id)
+        }
+    }
+}
+
+// fd returns a new fd of buffer id, for the listener to own.
+func (p *player) fd(id uint32) (dbus.UnixFD, error) {
+    f, ok := p.buffers[id]
+    if !ok {
+        return -1, fmt.Errorf("capture uses unknown buffer %d", id)
+    }
+
+    fd, err := syscall.Dup(int(f.Fd()))
+    return dbus.UnixFD(fd), err
+}
+
+func (p *player) unixMap() (qemu.DisplayListenerUnixMap, error) {
+    l, ok := p.listener.(qemu.DisplayListenerUnixMap)
+    if !ok {
+        return nil, fmt.Errorf("the listener does not take shared memory scanouts")
+    }
+    return l, nil
+}
+
+func (p *player) play(op byte) error {
+    d := &p.d
+
+    switch op {
+    case opBuffer:
+        id, data := d.uint32(), d.bytes()
+        if d.err != nil {
+            return d.err
+        }
+
+        f, err := os.CreateTemp("", "qemu-capture-")
+        if err != nil {
+            return err
+        }
+        os.Remove(f.Name())
+
+        if old, ok := p.buffers[id]; ok {
+            old.Close()
+        }
+        p.buffers[id] = f
+
+        if _, err = f.Write(data); err != nil {
+            return err
+        }
+
+    case opWrite:
+        id, offset, data := d.uint32(), d.uint(), d.bytes()
+        if d.err != nil {
+            return d.err
+        }
+
+        f, ok := p.buffers[id]
+        if !ok {
+            return fmt.Errorf("capture writes to unknown buffer %d", id)
+        }
+        if _, err := f.WriteAt(data, int64(offset)); err != nil {
+            return err
+        }
+
+    case opScanout:
+        width, height, stride, format, data := d.uint32(), d.uint32(), d.uint32(), d.uint32(), d.bytes()
+        if d.err != nil {
+            return d.err
+        }
+
+        p.keep()
+        p.listener.Scanout(width, height, stride, format, data)
+
+    case opUpdate:
+        x, y, width, height := int32(d.int()), int32(d.int()), int32(d.int()), int32(d.int())
+        stride, format, data := d.uint32(), d.uint32(), d.bytes()
+        if d.err != nil {
+            return d.err
+        }
+
+        p.listener.Update(x, y, width, height, stride, format, data)
+
+    case opScanoutMap:
+        id, offset, width, height, stride, format := d.uint32(), d.uint32(), d.uint32(), d.uint32(), d.uint32(), d.uint32()
+        if d.err != nil {
+            return d.err
+        }
+
+        l, err := p.unixMap()
+        if err != nil {
+            return err
+        }
+        p.keep(id)
+        fd, err := p.fd(id)
+        if err != nil {
+            return err
+        }
+        l.ScanoutMap(fd, offset, width, height, stride, format)
+
+    case opUpdateMap:
+        x, y, width, height := int32(d.int()), int32(d.int()), int32(d.int()), int32(d.int())
+        if d.err != nil {
+            return d.err
+        }
+
+        l, err := p.unixMap()
+        if err != nil {
+            return err
+        }
+        l.UpdateMap(x, y, width, height)
+
+    case opScanoutDMABUF:
+        id, width, height, stride, fourcc := d.uint32(), d.uint32(), d.uint32(), d.uint32(), d.uint32()
+        modifier, y0_top := d.uint(), d.bool()
+        if d.err != nil {
+            return d.err
+        }
+
+        p.keep(id)
+        fd, err := p.fd(id)
+        if err != nil {
+            return err
+        }
+        p.listener.ScanoutDMABUF(fd, width, height, stride, fourcc, modifier, y0_top)
+
+    case opScanoutDMABUF2:
+        ids := d.uints()
+        x, y, width, height := d.uint32(), d.uint32(), d.uint32(), d.uint32()
+        offset, stride := d.uints(), d.uints()
+        num_planes, fourcc, backing_width, backing_height := d.uint32(), d.uint32(), d.uint32(), d.uint32()
+        modifier, y0_top := d.uint(), d.bool()
+        if d.err != nil {
+            return d.err
+        }
+
+        l, ok := p.listener.(qemu.DisplayListenerUnixScanoutDMABUF2)
+        if !ok {
+            return fmt.Errorf("the listener does not take multi-planar DMABUF scanouts")
+        }
+
+        p.keep(ids...)
+        fds := make([]dbus.UnixFD, len(ids))
+        for i, id := range ids {
+            fd, err := p.fd(id)
+            if err != nil {
+                for _, v := range fds[:i] {
+                    syscall.Close(int(v))
+                }
+                return err
+            }
+            fds[i] = fd
+        }
+        l.ScanoutDMABUF2(fds, x, y, width, height, offset, stride, num_planes, fourcc, backing_width, backing_height, modifier, y0_top)
+
+    case opUpdateDMABUF:
+        x, y, width, height := int32(d.int()), int32(d.int()), int32(d.int()), int32(d.int())
+        if d.err != nil {
+            return d.err
+        }
+
+        p.listener.UpdateDMABUF(x, y, width, height)
+
+    case opDisable:
+        p.keep()
+        p.listener.Disable()
+
+    case opMouseSet:
+        x, y, on := int(d.int()), int(d.int()), int(d.int())
+        if d.err != nil {
+            return d.err
+        }
+
+        p.listener.MouseSet(x, y, on)
+
+    case opCursorDefine:
+        width, height, hot_x, hot_y := int(d.int()), int(d.int()), int(d.int()), int(d.int())
+        data := d.bytes()
+        if d.err != nil {
+            return d.err
+        }
+
+        p.listener.CursorDefine(width, height, hot_x, hot_y, data)
+
+    default:
+        return fmt.Errorf("unknown op %d in capture", op)
+    }
+
+    return nil
+}
@@ -0,0 +1,148 @@
+package qemu
+
+import (
+    "fmt"
+    "io"
+    "slices"
+    "strings"
+    "syscall"
+
+    "github.com/godbus/dbus/v5"
+)
+
+type Chardev struct {
+    conn    *dbus.Conn
+    chardev dbus.BusObject
+    id      string
+    name    string
+}
+
+// newChardev returns the chardev with the given -chardev id, which its
+// object path is made of.
+func newChardev(conn *dbus.Conn, id string) (*Chardev, error) {
+    chardevPath := dbus.ObjectPath(fmt.Sprintf(chardevPath, id))
+    chardev := conn.Object(qemuIntf, chardevPath)
+
+    label, err := getProp(chardev, chardevName)
+    if err != nil {
+        return nil, err
+    }
+
+    return &Chardev{conn, chardev, id, label.(string)}, nil
+}
+
+// Chardevs lists the character devices exported with -chardev dbus,name=...
+func (vm *VM) Chardevs() ([]*Chardev, error) {
+    var objects map[dbus.ObjectPath]map[string]map[string]dbus.Variant
+
+    err := vm.conn.Object(qemuIntf, displayPath).Call(objectManagerGetManagedObjects, 0).Store(&objects)
+    if err != nil {
+        return nil, err
+    }
+
+    prefix := fmt.Sprintf(chardevPath, "")
+
+    var ids []string
+    for path, intfs := range objects {
+        if _, ok := intfs[chardevIntf]; ok && strings.HasPrefix(string(path), prefix) {
+            ids = append(ids, strings.TrimPrefix(string(path), prefix))
+        }
+    }
+    slices.Sort(ids)
+
+    var chardevs []*Chardev
+    for _, id := range ids {
+        chardev, err := newChardev(vm.conn, id)
+        if err != nil {
+            return nil, err
+        }
+        chardevs = append(chardevs, chardev)
+    }
+
+    return chardevs, nil
+}
+
+// GetChardev returns the character device called name, the name= of
+// -chardev dbus, or failing that the one with name as its id.
+func (vm *VM) GetChardev(name string) (*Chardev, error) {
+    chardevs, err := vm.Chardevs()
+    if err != nil {
+        return nil, err
+    }
+
+    for _, c := range chardevs {
+        if c.name == name {
+            return c, nil
+        }
+    }
+    for _, c := range chardevs {
+        if c.id == name {
+            return c, nil
+        }
+    }
+
+    return nil, fmt.Errorf("no chardev called %q", name)
+}
+
+// ID is the id of the -chardev.
+func (c *Chardev) ID() string {
+    return c.id
+}
+
+func (c *Chardev) Name() string {
+    return c.name
+}
+
+// FEOpened reports whether the guest frontend has the device open.
+func (c *Chardev) FEOpened() bool {
+    opened, err := getProp(c.chardev, chardevFEOpened)
+    if err != nil {
+        return false
+    }
+    return opened.(bool)
+}
+
+func (c *Chardev) Echo() bool {
+    echo, err := getProp(c.chardev, chardevEcho)
+    if err != nil {
+        return false
+    }
+    return echo.(bool)
+}
+
+// Owner is the unique bus name of the peer the device is registered with,
+// or an empty string if it is free.
+func (c *Chardev) Owner() string {
+    owner, err := getProp(c.chardev, chardevOwner)
+    if err != nil {
+        return ""
+    }
+    return owner.(string)
+}
+
+// Open registers a new stream with QEMU and returns our end of it. QEMU
+// keeps only the most recently registered stream.
+func (c *Chardev) Open() (io.ReadWriteCloser, error) {
+    usFd, themFd, err := socketpair()
+    if err != nil {
+        return nil, err
+    }
+    defer syscall.Close(int(themFd))
+
+    us, err := fdToUnixConn(usFd, "us")
+    if err != nil {
+        return nil, err
+    }
+
+    err = c.chardev.Call(chardevRegister, 0, themFd).Err
+    if err != nil {
+        us.Close()
+        return nil, err
+    }
+
+    return us, nil
+}
+
+func (c *Chardev) SendBreak() error {
+    return c.chardev.Call(chardevSendBreak, 0).Err
+}
@@ -0,0 +1,105 @@
+package qemu
+
+import (
+    "fmt"
+    "slices"
+
+    "github.com/godbus/dbus/v5"
+)
+
+// ClipboardHandler receives clipboard requests from QEMU once the peer is
+// registered with Clipboard.Register.
+type ClipboardHandler interface {
+    // Grab is called when the guest takes ownership of a selection.
+    Grab(selection, serial uint32, mimes []string) *dbus.Error
+    // Release is called when the guest drops a selection.
+    Release(selection uint32) *dbus.Error
+    // Request is called when the guest wants the data we grabbed earlier.
+    Request(selection uint32, mimes []string) (string, []byte, *dbus.Error)
+}
+
+type ClipboardSelection uint32
+
+const (
+    SelectionClipboard ClipboardSelection = 0
+    SelectionPrimary   ClipboardSelection = 1
+    SelectionSecondary ClipboardSelection = 2
+)
+
+type Clipboard struct {
+    conn      *dbus.Conn
+    clipboard dbus.BusObject
+    handler   ClipboardHandler
+}
+
+func newClipboard(conn *dbus.Conn) (*Clipboard, error) {
+    return &Clipboard{conn, conn.Object(qemuIntf, clipboardPath), nil}, nil
+}
+
+func (vm *VM) GetClipboard() (*Clipboard, error) {
+    if !slices.Contains(vm.interfaces, clipboardIntf) {
+        return nil, fmt.Errorf("clipboard is not supported by the VM")
+    }
+
+    return newClipboard(vm.conn)
+}
+
+// Register exports handler on our side of the bus and announces it to QEMU.
+// Only one handler can be registered at a time.
+func (c *Clipboard) Register(handler ClipboardHandler) error {
+    if c.handler != nil {
+        return fmt.Errorf("clipboard handler is already registered")
+    }
+
+    err := c.conn.Export(handler, clipboardPath, clipboardIntf)
+    if err != nil {
+        return err
+    }
+
+    err = c.clipboard.Call(clipboardRegister, 0).Err
+    if err != nil {
+        c.conn.Export(nil, clipboardPath, clipboardIntf)
+        return err
+    }
+
+    c.handler = handler
+
+    return nil
+}
+
+func (c *Clipboard) Unregister() error {
+    if c.handler == nil {
+        return nil
+    }
+
+    err := c.clipboard.Call(clipboardUnregister, 0).Err
+    c.conn.Export(nil, clipboardPath, clipboardIntf)
+    c.handler = nil
+
+    return err
+}
+
+// Grab tells QEMU that we own selection and can provide it in any of mimes.
+// serial must be greater than the one of the last grab seen by QEMU.
+func (c *Clipboard) Grab(selection ClipboardSelection, serial uint32, mimes []string) error {
+    return c.clipboard.Call(clipboardGrab, 0, uint32(selection), serial, mimes).Err
+}
+
+func (c *Clipboard) Release(selection ClipboardSelection) error {
+    return c.clipboard.Call(clipboardRelease, 0, uint32(selection)).Err
+}
+
+// Request fetches the guest selection, returning its mime type and data.
+func (c *Clipboard) Request(selection ClipboardSelection, mimes []string) (string, []byte, error) {
+    var (
+        mime string
+        data []byte
+    )
+
+    err := c.clipboard.Call(clipboardRequest, 0, uint32(selection), mimes).Store(&mime, &data)
+    if err != nil {
+        return "", nil, err
+    }
+
+    return mime, data, nil
+}
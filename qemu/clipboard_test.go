@@ -0,0 +1,177 @@
+package qemu_test
+
+import (
+    "bytes"
+    "context"
+    "testing"
+    "time"
+
+    "github.com/godbus/dbus/v5"
+
+    "qemu"
+    "qemu/qemutest"
+)
+
+// clipboardOwner is a clipboard handler holding one text selection, and
+// noting what the guest grabs.
+type clipboardOwner struct {
+    text []byte
+
+    grabbed chan []string
+    release chan uint32
+}
+
+func (o *clipboardOwner) Grab(selection, serial uint32, mimes []string) *dbus.Error {
+    o.grabbed <- mimes
+    return nil
+}
+
+func (o *clipboardOwner) Release(selection uint32) *dbus.Error {
+    o.release <- selection
+    return nil
+}
+
+func (o *clipboardOwner) Request(selection uint32, mimes []string) (string, []byte, *dbus.Error) {
+    return "text/plain;charset=utf-8", o.text, nil
+}
+
+func getClipboard(t *testing.T, vm qemutest.VM) (*qemutest.Server, *qemu.Clipboard) {
+    t.Helper()
+
+    srv, err := qemutest.NewServer(vm)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { srv.Close() })
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    v, err := qemu.Connect(ctx, qemu.WithAddress(srv.Address()))
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(v.Close)
+
+    clipboard, err := v.GetClipboard()
+    if err != nil {
+        t.Fatal(err)
+    }
+    return srv, clipboard
+}
+
+func TestClipboardUnsupported(t *testing.T) {
+    srv, err := qemutest.NewServer(qemutest.VM{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer srv.Close()
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    vm, err := qemu.Connect(ctx, qemu.WithAddress(srv.Address()))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer vm.Close()
+
+    if _, err := vm.GetClipboard(); err == nil {
+        t.Error("got a clipboard from a VM without one")
+    }
+}
+
+func TestClipboardToGuest(t *testing.T) {
+    srv, clipboard := getClipboard(t, qemutest.VM{Clipboard: true})
+
+    owner := &clipboardOwner{text: []byte("from the host")}
+    if err := clipboard.Register(owner); err != nil {
+        t.Fatal(err)
+    }
+    if err := clipboard.Register(owner); err == nil {
+        t.Error("registered a second handler")
+    }
+
+    mimes := []string{"text/plain;charset=utf-8"}
+    if err := clipboard.Grab(qemu.SelectionClipboard, 1, mimes); err != nil {
+        t.Fatal(err)
+    }
+
+    // The guest pastes
+    mime, data, err := srv.RequestClipboard(uint32(qemu.SelectionClipboard), mimes)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if mime != mimes[0] || !bytes.Equal(data, owner.text) {
+        t.Errorf("guest got %s %q, want %q", mime, data, owner.text)
+    }
+
+    if err := clipboard.Release(qemu.SelectionClipboard); err != nil {
+        t.Fatal(err)
+    }
+    if err := clipboard.Unregister(); err != nil {
+        t.Fatal(err)
+    }
+
+    var methods []string
+    for _, call := range srv.Calls() {
+        if call.Console != qemutest.ClipboardConsole {
+            t.Errorf("clipboard call %s on console %d", call.Method, call.Console)
+        }
+        methods = append(methods, call.Method[len("org.qemu.Display1.Clipboard."):])
+    }
+    want := []string{"Register", "Grab", "Release", "Unregister"}
+    if len(methods) != len(want) {
+        t.Fatalf("clipboard calls are %v, want %v", methods, want)
+    }
+    for i := range want {
+        if methods[i] != want[i] {
+            t.Errorf("clipboard calls are %v, want %v", methods, want)
+            break
+        }
+    }
+
+    if _, _, err := srv.RequestClipboard(uint32(qemu.SelectionClipboard), mimes); err == nil {
+        t.Error("guest pasted from an unregistered clipboard")
+    }
+}
+
+func TestClipboardFromGuest(t *testing.T) {
+    srv, clipboard := getClipboard(t, qemutest.VM{Clipboard: true})
+
+    owner := &clipboardOwner{grabbed: make(chan []string, 1), release: make(chan uint32, 1)}
+    if err := clipboard.Register(owner); err != nil {
+        t.Fatal(err)
+    }
+
+    // The guest copies
+    text := []byte("from the guest")
+    if err := srv.GrabClipboard(uint32(qemu.SelectionPrimary), 1, "text/plain", text); err != nil {
+        t.Fatal(err)
+    }
+    if mimes := <-owner.grabbed; len(mimes) != 1 || mimes[0] != "text/plain" {
+        t.Errorf("guest grabbed %v", mimes)
+    }
+
+    mime, data, err := clipboard.Request(qemu.SelectionPrimary, []string{"text/plain"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if mime != "text/plain" || !bytes.Equal(data, text) {
+        t.Errorf("got %s %q, want %q", mime, data, text)
+    }
+
+    if _, _, err := clipboard.Request(qemu.SelectionPrimary, []string{"image/png"}); err == nil {
+        t.Error("got the text selection as an image")
+    }
+
+    if err := srv.ReleaseClipboard(uint32(qemu.SelectionPrimary)); err != nil {
+        t.Fatal(err)
+    }
+    if sel := <-owner.release; sel != uint32(qemu.SelectionPrimary) {
+        t.Errorf("guest released selection %d", sel)
+    }
+    if _, _, err := clipboard.Request(qemu.SelectionPrimary, []string{"text/plain"}); err == nil {
+        t.Error("got a released selection")
+    }
+}
@@ -1,9 +1,11 @@
 package qemu
 
 import (
+    "context"
     "fmt"
     "net"
-    "syscall"
+    "slices"
+    "sync"
 
     "github.com/godbus/dbus/v5"
     "github.com/godbus/dbus/v5/prop"
@@ -31,12 +33,24 @@ type DisplayListenerUnixScanoutDMABUF2 interface {
 type Console struct {
     conn        *dbus.Conn
     console     dbus.BusObject
-    label       string
+    id          uint32
     consoleType string
-    width       uint32
-    height      uint32
-    interfaces  []string
-    listeners   []listenerConn
+
+    mu         sync.Mutex
+    label      string
+    width      uint32
+    height     uint32
+    interfaces []string
+
+    listeners []listenerConn
+}
+
+// ConsoleState holds the console properties which may change at runtime.
+type ConsoleState struct {
+    Label      string
+    Width      uint32
+    Height     uint32
+    Interfaces []string
 }
 
 type listenerConn struct {
@@ -75,10 +89,28 @@ func newConsole(conn *dbus.Conn, n uint32) (*Console, error) {
         return nil, err
     }
 
-    return &Console{conn, console, label.(string), ctype.(string), width.(uint32), height.(uint32), intf.([]string), nil}, nil
+    return &Console{
+        conn:        conn,
+        console:     console,
+        id:          n,
+        consoleType: ctype.(string),
+        label:       label.(string),
+        width:       width.(uint32),
+        height:      height.(uint32),
+        interfaces:  intf.([]string),
+    }, nil
+}
+
+// ID returns the QEMU console index, which stays the same while consoles
+// come and go.
+func (c *Console) ID() uint32 {
+    return c.id
 }
 
 func (c *Console) Label() string {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
     return c.label
 }
 
@@ -87,106 +119,225 @@ func (c *Console) Type() string {
 }
 
 func (c *Console) Width() uint32 {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
     return c.width
 }
 
 func (c *Console) Height() uint32 {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
     return c.height
 }
 
-func (c *Console) GetMouse() (*Mouse, error) {
-    return newMouse(c.conn, c.console)
+// Interfaces lists the input interfaces available on the console.
+func (c *Console) Interfaces() []string {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    return c.interfaces
 }
 
-func (c *Console) GetKeyboard() (*Keyboard, error) {
-    return newKeyboard(c.conn, c.console)
+func (c *Console) state() ConsoleState {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    return ConsoleState{c.label, c.width, c.height, c.interfaces}
 }
 
-func (c *Console) RegisterListener(listener DisplayListener) error {
-    usFd, themFd, err := socketpair()
-    if err != nil {
-        return err
+// update applies changed properties to the cache and tells whether any of
+// them was of interest.
+func (c *Console) update(changed map[string]dbus.Variant) bool {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    updated := false
+    for name, v := range changed {
+        switch val := v.Value().(type) {
+        case string:
+            if name == "Label" {
+                c.label, updated = val, true
+            }
+        case uint32:
+            switch name {
+            case "Width":
+                c.width, updated = val, true
+            case "Height":
+                c.height, updated = val, true
+            }
+        case []string:
+            if name == "Interfaces" {
+                c.interfaces, updated = val, true
+            }
+        }
     }
 
-    us, err := fdToUnixConn(usFd, "us")
-    if err != nil {
-        return err
-    }
+    return updated
+}
 
-    conn, err := dbus.DialUnix(us)
-    if err != nil {
-        return err
+// refresh reads the properties named in invalidated again.
+func (c *Console) refresh(invalidated []string) bool {
+    changed := map[string]dbus.Variant{}
+    for _, name := range invalidated {
+        if v, err := c.console.GetProperty(consoleIntf + "." + name); err == nil {
+            changed[name] = v
+        }
     }
 
-    err = conn.Export(listener, listenerPath, listenerIntf)
+    return c.update(changed)
+}
+
+// Watch sends the current state and then the new one after each change of
+// the console label, size or interfaces, until ctx is done. The channel is
+// closed afterwards. The values returned by the accessors are kept up to
+// date while watching.
+func (c *Console) Watch(ctx context.Context) (<-chan ConsoleState, error) {
+    changes := make(chan ConsoleState, 16)
+
+    done, err := watchProperties(ctx, c.conn, c.console, consoleIntf, func(changed map[string]dbus.Variant, invalidated []string) {
+        updated := c.update(changed)
+        if c.refresh(invalidated) {
+            updated = true
+        }
+        if !updated {
+            return
+        }
+
+        select {
+        case changes <- c.state():
+        case <-ctx.Done():
+        }
+    })
     if err != nil {
-        return err
+        return nil, err
     }
 
-    interfaces := []string{listenerIntf}
+    // Subscribed, now catch up with what changed since newConsole
+    c.refresh([]string{"Label", "Width", "Height", "Interfaces"})
+    initial := c.state()
 
-    unixListener, ok := listener.(DisplayListenerUnixMap)
-    if ok {
-        err = conn.Export(unixListener, listenerPath, listenerUnixMapIntf)
-        if err != nil {
-            return err
+    states := make(chan ConsoleState)
+
+    go func() {
+        defer close(states)
+
+        state := initial
+        for {
+            select {
+            case states <- state:
+            case <-ctx.Done():
+                return
+            }
+
+            select {
+            case state = <-changes:
+            case <-done:
+                return
+            }
         }
-        interfaces = append(interfaces, listenerUnixMapIntf)
+    }()
+
+    return states, nil
+}
+
+// SetUIInfo tells the guest about the size of the client window, so that
+// it can change its resolution to match. Physical sizes are in millimeters
+// and may be zero when unknown.
+func (c *Console) SetUIInfo(width_mm, height_mm uint16, xoff, yoff int32, width, height uint32) error {
+    return c.console.Call(consoleSetUIInfo, 0, width_mm, height_mm, xoff, yoff, width, height).Err
+}
+
+func (c *Console) GetMouse() (*Mouse, error) {
+    return newMouse(c.conn, c.console)
+}
+
+func (c *Console) GetKeyboard() (*Keyboard, error) {
+    return newKeyboard(c.conn, c.console)
+}
+
+func (c *Console) GetMultiTouch() (*MultiTouch, error) {
+    if !slices.Contains(c.Interfaces(), multiTouchIntf) {
+        return nil, fmt.Errorf("multi-touch is not supported by console %s", c.Label())
     }
 
-    unixDmaBuf2Listener, ok := listener.(DisplayListenerUnixScanoutDMABUF2)
-    if ok {
-        err = conn.Export(unixDmaBuf2Listener, listenerPath, listenerUnixScanoutDMABUF2Intf)
+    return newMultiTouch(c.conn, c.console)
+}
+
+func (c *Console) RegisterListener(listener DisplayListener) error {
+    var props *prop.Properties
+
+    conn, us, err := dialPeer(c.console, consoleRegisterListener, func(conn *dbus.Conn) error {
+        err := conn.Export(listener, listenerPath, listenerIntf)
         if err != nil {
             return err
         }
-        interfaces = append(interfaces, listenerUnixScanoutDMABUF2Intf)
-    }
 
-    propsMap := map[string]map[string]*prop.Prop{
-        listenerIntf: {
-            "Interfaces": {
-                Value:    interfaces,
-                Writable: false,
-                Emit:     prop.EmitConst,
-                Callback: nil,
-            },
-        },
-    }
+        interfaces := []string{listenerIntf}
 
-    props, err := prop.Export(conn, listenerPath, propsMap)
-    if err != nil {
-        return err
-    }
+        unixListener, ok := listener.(DisplayListenerUnixMap)
+        if ok {
+            err = conn.Export(unixListener, listenerPath, listenerUnixMapIntf)
+            if err != nil {
+                return err
+            }
+            interfaces = append(interfaces, listenerUnixMapIntf)
+        }
 
-    c.listeners = append(c.listeners, listenerConn{conn, us, listener, props})
+        unixDmaBuf2Listener, ok := listener.(DisplayListenerUnixScanoutDMABUF2)
+        if ok {
+            err = conn.Export(unixDmaBuf2Listener, listenerPath, listenerUnixScanoutDMABUF2Intf)
+            if err != nil {
+                return err
+            }
+            interfaces = append(interfaces, listenerUnixScanoutDMABUF2Intf)
+        }
 
-    ret := c.console.Call(consoleRegisterListener, 0, themFd)
+        propsMap := map[string]map[string]*prop.Prop{
+            listenerIntf: {
+                "Interfaces": {
+                    Value:    interfaces,
+                    Writable: false,
+                    Emit:     prop.EmitConst,
+                    Callback: nil,
+                },
+            },
+        }
 
-    if err = conn.Auth(nil); err != nil {
+        props, err = prop.Export(conn, listenerPath, propsMap)
+        return err
+    })
+    if conn == nil {
         return err
     }
 
-    // FIXME: hangs here. Is this expected?
-    // <- ret.Done
-
-    syscall.Close(int(themFd))
+    c.mu.Lock()
+    c.listeners = append(c.listeners, listenerConn{conn, us, listener, props})
+    c.mu.Unlock()
 
-    return ret.Err
+    return err
 }
 
-
 func (c *Console) UnregisterListener(listener DisplayListener) error {
-    var newListeners []listenerConn
+    var newListeners, removed []listenerConn
+
+    c.mu.Lock()
     for _, v := range c.listeners {
         if v.impl == listener {
-            v.conn.Close()
-            v.unix.Close()
+            removed = append(removed, v)
         } else {
             newListeners = append(newListeners, v)
         }
     }
     c.listeners = newListeners
+    c.mu.Unlock()
+
+    // Closed unlocked, the connections may be busy with a call
+    for _, v := range removed {
+        v.conn.Close()
+        v.unix.Close()
+    }
 
     return nil
 }
@@ -0,0 +1,200 @@
+package qemu_test
+
+import (
+    "bytes"
+    "context"
+    "slices"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/godbus/dbus/v5"
+
+    "qemu"
+    "qemu/qemutest"
+)
+
+// connect starts a fake QEMU serving vm and returns its first console.
+func connect(t *testing.T, vm qemutest.VM) (*qemutest.Server, *qemu.Console) {
+    t.Helper()
+
+    srv, err := qemutest.NewServer(vm)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { srv.Close() })
+
+    dialCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    v, err := qemu.Connect(dialCtx, qemu.WithAddress(srv.Address()))
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(v.Close)
+
+    console, err := v.GetConsole(0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return srv, console
+}
+
+// recorder is a display listener noting the calls it gets.
+type recorder struct {
+    mu    sync.Mutex
+    calls []string
+    data  []byte
+}
+
+func (r *recorder) add(call string, data []byte) *dbus.Error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    r.calls = append(r.calls, call)
+    if data != nil {
+        r.data = append([]byte(nil), data...)
+    }
+    return nil
+}
+
+func (r *recorder) Scanout(width, height, stride, format uint32, data []byte) *dbus.Error {
+    return r.add("Scanout", data)
+}
+
+func (r *recorder) Update(x, y, width, height int32, stride, format uint32, data []byte) *dbus.Error {
+    return r.add("Update", data)
+}
+
+func (r *recorder) ScanoutDMABUF(fd dbus.UnixFD, width, height, stride, fourcc uint32, modifier uint64, y0_top bool) *dbus.Error {
+    return r.add("ScanoutDMABUF", nil)
+}
+
+func (r *recorder) UpdateDMABUF(x, y, width, height int32) *dbus.Error {
+    return r.add("UpdateDMABUF", nil)
+}
+
+func (r *recorder) Disable() *dbus.Error {
+    return r.add("Disable", nil)
+}
+
+func (r *recorder) MouseSet(x, y, on int) *dbus.Error {
+    return r.add("MouseSet", nil)
+}
+
+func (r *recorder) CursorDefine(width, height, hot_x, hot_y int, data []byte) *dbus.Error {
+    return r.add("CursorDefine", data)
+}
+
+func (r *recorder) recorded() ([]string, []byte) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    return append([]string(nil), r.calls...), r.data
+}
+
+func TestConsoleProperties(t *testing.T) {
+    _, console := connect(t, qemutest.VM{Consoles: []qemutest.Console{
+        {Label: "vga", Type: "Graphic", Width: 800, Height: 600, MouseAbsolute: true},
+    }})
+
+    if console.Label() != "vga" || console.Type() != "Graphic" {
+        t.Errorf("console is %q of type %q", console.Label(), console.Type())
+    }
+    if console.Width() != 800 || console.Height() != 600 {
+        t.Errorf("console is %dx%d, want 800x600", console.Width(), console.Height())
+    }
+    for _, intf := range []string{"org.qemu.Display1.Keyboard", "org.qemu.Display1.Mouse"} {
+        if !slices.Contains(console.Interfaces(), intf) {
+            t.Errorf("console interfaces %v lack %s", console.Interfaces(), intf)
+        }
+    }
+}
+
+func TestConsoleWatch(t *testing.T) {
+    srv, console := connect(t, qemutest.VM{})
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    states, err := console.Watch(ctx)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if state := <-states; state.Width != 640 || state.Height != 480 {
+        t.Fatalf("initial state is %+v", state)
+    }
+
+    srv.ResizeConsole(0, 1024, 768)
+    for state := range states {
+        if state.Width == 1024 && state.Height == 768 {
+            break
+        }
+    }
+    if console.Width() != 1024 || console.Height() != 768 {
+        t.Errorf("console is %dx%d after the resize", console.Width(), console.Height())
+    }
+
+    srv.SetConsoleLabel(0, "renamed")
+    for state := range states {
+        if state.Label == "renamed" {
+            break
+        }
+    }
+    if console.Label() != "renamed" {
+        t.Errorf("console label is %q after the change", console.Label())
+    }
+
+    cancel()
+    for range states {
+    }
+}
+
+func TestConsoleListener(t *testing.T) {
+    srv, console := connect(t, qemutest.VM{})
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    r := &recorder{}
+    if err := console.RegisterListener(r); err != nil {
+        t.Fatal(err)
+    }
+    l, err := srv.WaitListener(ctx, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // recorder does not take shared memory
+    interfaces, err := l.Interfaces()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !slices.Equal(interfaces, []string{"org.qemu.Display1.Listener"}) {
+        t.Errorf("listener interfaces are %v", interfaces)
+    }
+
+    pixel := []byte{1, 2, 3, 4}
+    err = l.Play(
+        qemutest.Scanout{Width: 1, Height: 1, Stride: 4, Format: qemutest.FormatX8R8G8B8, Data: pixel},
+        qemutest.Update{X: 0, Y: 0, Width: 1, Height: 1, Stride: 4, Format: qemutest.FormatX8R8G8B8, Data: pixel},
+        qemutest.MouseSet{X: 0, Y: 0, On: 1},
+        qemutest.Disable{},
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    calls, data := r.recorded()
+    if want := []string{"Scanout", "Update", "MouseSet", "Disable"}; !slices.Equal(calls, want) {
+        t.Errorf("listener got %v, want %v", calls, want)
+    }
+    if !bytes.Equal(data, pixel) {
+        t.Errorf("listener got pixels %v, want %v", data, pixel)
+    }
+
+    if err := console.UnregisterListener(r); err != nil {
+        t.Fatal(err)
+    }
+}
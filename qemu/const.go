@@ -24,6 +24,7 @@ const (
     consoleInterfaces = consoleIntf + ".Interfaces"
 
     consoleRegisterListener = consoleIntf + ".RegisterListener"
+    consoleSetUIInfo        = consoleIntf + ".SetUIInfo"
 
     mouseIntf = displayIntf + ".Mouse"
 
@@ -34,12 +35,57 @@ const (
     mouseRelMotion      = mouseIntf + ".RelMotion"
     mouseSetAbsPosition = mouseIntf + ".SetAbsPosition"
 
+    multiTouchIntf = displayIntf + ".MultiTouch"
+
+    multiTouchMaxSlots  = multiTouchIntf + ".MaxSlots"
+    multiTouchSendEvent = multiTouchIntf + ".SendEvent"
+
     keyboardIntf = displayIntf + ".Keyboard"
 
     keyboardPress     = keyboardIntf + ".Press"
     keyboardRelease   = keyboardIntf + ".Release"
     keyboardModifiers = keyboardIntf + ".Modifiers"
 
+    clipboardPath = displayPath + "/Clipboard"
+    clipboardIntf = displayIntf + ".Clipboard"
+
+    clipboardRegister   = clipboardIntf + ".Register"
+    clipboardUnregister = clipboardIntf + ".Unregister"
+    clipboardGrab       = clipboardIntf + ".Grab"
+    clipboardRelease    = clipboardIntf + ".Release"
+    clipboardRequest    = clipboardIntf + ".Request"
+
+    audioPath = displayPath + "/Audio"
+    audioIntf = displayIntf + ".Audio"
+
+    audioRegisterOutListener = audioIntf + ".RegisterOutListener"
+    audioRegisterInListener  = audioIntf + ".RegisterInListener"
+
+    audioOutListenerPath = displayPath + "/AudioOutListener"
+    audioOutListenerIntf = displayIntf + ".AudioOutListener"
+
+    audioInListenerPath = displayPath + "/AudioInListener"
+    audioInListenerIntf = displayIntf + ".AudioInListener"
+
+    chardevPath = displayPath + "/Chardev_%s"
+    chardevIntf = displayIntf + ".Chardev"
+
+    chardevName     = chardevIntf + ".Name"
+    chardevFEOpened = chardevIntf + ".FEOpened"
+    chardevEcho     = chardevIntf + ".Echo"
+    chardevOwner    = chardevIntf + ".Owner"
+
+    chardevRegister  = chardevIntf + ".Register"
+    chardevSendBreak = chardevIntf + ".SendBreak"
+
+    objectManagerGetManagedObjects = "org.freedesktop.DBus.ObjectManager.GetManagedObjects"
+
+    busIntf          = "org.freedesktop.DBus"
+    nameOwnerChanged = "NameOwnerChanged"
+
+    propertiesIntf    = "org.freedesktop.DBus.Properties"
+    propertiesChanged = "PropertiesChanged"
+
     listenerPath = displayPath + "/Listener"
     listenerIntf = displayIntf + ".Listener"
 
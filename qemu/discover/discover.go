@@ -0,0 +1,202 @@
+// Package discover finds QEMU D-Bus displays started by libvirt.
+//
+// libvirt runs a private bus per domain with <graphics type="dbus"/> and
+// puts its socket in /run/libvirt/qemu/dbus. The live domain XML kept in
+// /run/libvirt/qemu names the socket of each running domain.
+package discover
+
+import (
+    "context"
+    "encoding/xml"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "qemu"
+)
+
+const (
+    DefaultStateDir  = "/run/libvirt/qemu"
+    DefaultSocketDir = "/run/libvirt/qemu/dbus"
+)
+
+type VM struct {
+    Name    string
+    UUID    string
+    Address string
+    // Source is the file the VM was found through
+    Source string
+    // Err is set if the display could not be reached
+    Err error
+}
+
+// Domain is the part of a libvirt domain definition we care about.
+type Domain struct {
+    Name     string `xml:"name"`
+    UUID     string `xml:"uuid"`
+    Graphics []struct {
+        Type    string `xml:"type,attr"`
+        Address string `xml:"address,attr"`
+        P2P     string `xml:"p2p,attr"`
+    } `xml:"devices>graphics"`
+}
+
+// Address returns the bus address of the D-Bus display of d, or "" if it
+// has none or the display is peer-to-peer.
+func (d *Domain) Address() string {
+    for _, g := range d.Graphics {
+        if g.Type == "dbus" && g.P2P != "yes" && g.Address != "" {
+            return g.Address
+        }
+    }
+
+    return ""
+}
+
+// ParseDomain reads either a domain definition or the <domstatus> wrapper
+// libvirt stores for running domains.
+func ParseDomain(r io.Reader) (*Domain, error) {
+    var doc struct {
+        XMLName xml.Name
+        Domain
+        Status Domain `xml:"domain"`
+    }
+
+    if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+        return nil, err
+    }
+
+    switch doc.XMLName.Local {
+    case "domain":
+        return &doc.Domain, nil
+    case "domstatus":
+        return &doc.Status, nil
+    default:
+        return nil, fmt.Errorf("unexpected root element <%s>", doc.XMLName.Local)
+    }
+}
+
+type Scanner struct {
+    // StateDirs are searched for domain XML files
+    StateDirs []string
+    // SocketDirs are searched for bus sockets not named by any domain XML
+    SocketDirs []string
+    // Timeout bounds connecting to a single VM, 0 means 2 seconds
+    Timeout time.Duration
+}
+
+// Scan finds VMs with the default Scanner.
+func Scan(ctx context.Context) ([]VM, error) {
+    s := Scanner{
+        StateDirs:  []string{DefaultStateDir},
+        SocketDirs: []string{DefaultSocketDir},
+    }
+
+    return s.Scan(ctx)
+}
+
+// Scan returns the VMs found in the configured directories sorted by name.
+// Every VM is connected to in order to learn its name and UUID, the ones
+// which could not be reached are still returned with Err set.
+func (s *Scanner) Scan(ctx context.Context) ([]VM, error) {
+    var vms []VM
+    seen := map[string]bool{}
+
+    for _, dir := range s.StateDirs {
+        files, err := filepath.Glob(filepath.Join(dir, "*.xml"))
+        if err != nil {
+            return nil, err
+        }
+
+        for _, file := range files {
+            d, err := parseFile(file)
+            if err != nil {
+                continue
+            }
+
+            addr := d.Address()
+            if addr == "" || seen[addr] {
+                continue
+            }
+            seen[addr] = true
+
+            vms = append(vms, VM{Name: d.Name, UUID: d.UUID, Address: addr, Source: file})
+        }
+    }
+
+    for _, dir := range s.SocketDirs {
+        entries, err := os.ReadDir(dir)
+        if os.IsNotExist(err) {
+            continue
+        } else if err != nil {
+            return nil, err
+        }
+
+        for _, e := range entries {
+            path := filepath.Join(dir, e.Name())
+            if fi, err := os.Stat(path); err != nil || fi.Mode()&os.ModeSocket == 0 {
+                continue
+            }
+
+            addr := "unix:path=" + path
+            if seen[addr] {
+                continue
+            }
+            seen[addr] = true
+
+            vms = append(vms, VM{Name: strings.TrimSuffix(e.Name(), ".sock"), Address: addr, Source: path})
+        }
+    }
+
+    s.probe(ctx, vms)
+
+    sort.SliceStable(vms, func(i, j int) bool {
+        return vms[i].Name < vms[j].Name
+    })
+
+    return vms, nil
+}
+
+func (s *Scanner) probe(ctx context.Context, vms []VM) {
+    timeout := s.Timeout
+    if timeout == 0 {
+        timeout = 2 * time.Second
+    }
+
+    var wg sync.WaitGroup
+    for i := range vms {
+        wg.Add(1)
+        go func(v *VM) {
+            defer wg.Done()
+
+            ctx, cancel := context.WithTimeout(ctx, timeout)
+            defer cancel()
+
+            vm, err := qemu.Connect(ctx, qemu.WithAddress(v.Address))
+            if err != nil {
+                v.Err = err
+                return
+            }
+            defer vm.Close()
+
+            v.Name = vm.Name()
+            v.UUID = vm.UUID()
+        }(&vms[i])
+    }
+    wg.Wait()
+}
+
+func parseFile(name string) (*Domain, error) {
+    f, err := os.Open(name)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    return ParseDomain(f)
+}
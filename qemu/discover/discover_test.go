@@ -0,0 +1,96 @@
+package discover_test
+
+import (
+    "context"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+
+    "qemu/discover"
+    "qemu/qemutest"
+)
+
+func parse(t *testing.T, name string) *discover.Domain {
+    t.Helper()
+
+    f, err := os.Open(filepath.Join("testdata", name))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+
+    d, err := discover.ParseDomain(f)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return d
+}
+
+func TestParseDomain(t *testing.T) {
+    tests := []struct {
+        file    string
+        name    string
+        uuid    string
+        address string
+    }{
+        {"dbus.xml", "win11", "5f1d3a6e-8c7b-4f2a-9d0e-1b2c3d4e5f60", "unix:path=/run/libvirt/qemu/dbus/34-win11-dbus.sock"},
+        {"p2p.xml", "fedora", "0b9c8d7e-6f5a-4b3c-8d2e-1f0a9b8c7d6e", ""},
+        {"vnc.xml", "debian", "9a8b7c6d-5e4f-4a3b-2c1d-0e9f8a7b6c5d", ""},
+    }
+
+    for _, test := range tests {
+        d := parse(t, test.file)
+        if d.Name != test.name || d.UUID != test.uuid {
+            t.Errorf("%s is %q %q, want %q %q", test.file, d.Name, d.UUID, test.name, test.uuid)
+        }
+        if addr := d.Address(); addr != test.address {
+            t.Errorf("%s has address %q, want %q", test.file, addr, test.address)
+        }
+    }
+
+    if _, err := discover.ParseDomain(strings.NewReader("<capabilities/>")); err == nil {
+        t.Error("parsed <capabilities> as a domain")
+    }
+}
+
+func TestScan(t *testing.T) {
+    srv, err := qemutest.NewServer(qemutest.VM{Name: "running", UUID: "11111111-2222-3333-4444-555555555555"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer srv.Close()
+
+    // A domain naming the socket of srv, which is found in its directory
+    // too but listed once
+    stateDir := t.TempDir()
+    domain := `<domain><name>stale</name><devices><graphics type="dbus" address="` + srv.Address() + `"/></devices></domain>`
+    if err := os.WriteFile(filepath.Join(stateDir, "running.xml"), []byte(domain), 0o644); err != nil {
+        t.Fatal(err)
+    }
+
+    s := discover.Scanner{
+        StateDirs:  []string{"testdata", stateDir},
+        SocketDirs: []string{filepath.Dir(strings.TrimPrefix(srv.Address(), "unix:path=")), "/nonexistent"},
+        Timeout:    time.Second,
+    }
+    vms, err := s.Scan(context.Background())
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if len(vms) != 2 {
+        t.Fatalf("found %+v, want running and win11", vms)
+    }
+
+    // The name and UUID come from the VM once reached
+    if v := vms[0]; v.Name != "running" || v.UUID != "11111111-2222-3333-4444-555555555555" || v.Address != srv.Address() || v.Err != nil {
+        t.Errorf("found %+v", v)
+    }
+
+    // And from the XML otherwise
+    if v := vms[1]; v.Name != "win11" || v.Source != filepath.Join("testdata", "dbus.xml") || v.Err == nil {
+        t.Errorf("found %+v", v)
+    }
+}
@@ -0,0 +1,190 @@
+package framebuffer
+
+import (
+    "encoding/binary"
+    "fmt"
+    "image"
+)
+
+// Pixman format types, the channel order from the most significant bits
+const (
+    pixmanTypeARGB = 2
+    pixmanTypeABGR = 3
+    pixmanTypeBGRA = 8
+    pixmanTypeRGBA = 9
+)
+
+// PixmanA8R8G8B8 is the format of the cursor images
+const PixmanA8R8G8B8 uint32 = 0x20028888
+
+// DRM fourccs of the linear DMABUFs we can read, with the matching pixman
+// formats
+var drmFormats = map[uint32]uint32{
+    0x34325258: 0x20020888, // XR24, PIXMAN_x8r8g8b8
+    0x34325241: 0x20028888, // AR24, PIXMAN_a8r8g8b8
+    0x34324258: 0x20030888, // XB24, PIXMAN_x8b8g8r8
+    0x34324241: 0x20038888, // AB24, PIXMAN_a8b8g8r8
+    0x36314752: 0x10020565, // RG16, PIXMAN_r5g6b5
+}
+
+// format is a pixman format code taken apart. Pixels are words of bpp bits
+// in host order, which is little endian on every host QEMU runs the D-Bus
+// display on.
+type format struct {
+    code uint32
+    bpp  int
+
+    aShift, rShift, gShift, bShift uint
+    aBits, rBits, gBits, bBits     uint
+}
+
+func parseFormat(code uint32) (format, error) {
+    f := format{
+        code:  code,
+        bpp:   int(code >> 24),
+        aBits: uint(code >> 12 & 0xf),
+        rBits: uint(code >> 8 & 0xf),
+        gBits: uint(code >> 4 & 0xf),
+        bBits: uint(code & 0xf),
+    }
+
+    switch code >> 16 & 0xff {
+    case pixmanTypeARGB:
+        f.gShift = f.bBits
+        f.rShift = f.gShift + f.gBits
+        f.aShift = f.rShift + f.rBits
+    case pixmanTypeABGR:
+        f.gShift = f.rBits
+        f.bShift = f.gShift + f.gBits
+        f.aShift = f.bShift + f.bBits
+    case pixmanTypeBGRA:
+        f.bShift = uint(f.bpp) - f.bBits
+        f.gShift = f.bShift - f.gBits
+        f.rShift = f.gShift - f.rBits
+    case pixmanTypeRGBA:
+        f.rShift = uint(f.bpp) - f.rBits
+        f.gShift = f.rShift - f.gBits
+        f.bShift = f.gShift - f.bBits
+    default:
+        return format{}, fmt.Errorf("unsupported pixman format %#x", code)
+    }
+
+    if f.bpp != 16 && f.bpp != 24 && f.bpp != 32 || f.rBits == 0 || f.gBits == 0 || f.bBits == 0 {
+        return format{}, fmt.Errorf("unsupported pixman format %#x", code)
+    }
+
+    return f, nil
+}
+
+// bytes is the size of a pixel
+func (f format) bytes() int {
+    return f.bpp / 8
+}
+
+// hasAlpha tells if the alpha channel means something
+func (f format) hasAlpha() bool {
+    return f.aBits != 0
+}
+
+// fast tells if the channels are whole bytes, the usual case
+func (f format) fast() bool {
+    return f.bpp == 32 && f.rBits == 8 && f.gBits == 8 && f.bBits == 8 &&
+        f.rShift%8 == 0 && f.gShift%8 == 0 && f.bShift%8 == 0 && f.aShift%8 == 0
+}
+
+func (f format) pixel(p []byte) uint32 {
+    switch f.bpp {
+    case 32:
+        return binary.LittleEndian.Uint32(p)
+    case 24:
+        return uint32(p[0]) | uint32(p[1])<<8 | uint32(p[2])<<16
+    default:
+        return uint32(binary.LittleEndian.Uint16(p))
+    }
+}
+
+func channel(v uint32, shift, bits uint) uint8 {
+    max := uint32(1)<<bits - 1
+    return uint8(((v>>shift)&max*255 + max/2) / max)
+}
+
+// convertRow converts n pixels of src to RGBA in dst. Without opaque the
+// alpha of formats having one is kept as is, not premultiplied.
+func (f format) convertRow(dst []uint8, src []byte, n int, opaque bool) {
+    alpha := f.hasAlpha() && !opaque
+
+    if f.fast() {
+        r, g, b, a := f.rShift/8, f.gShift/8, f.bShift/8, f.aShift/8
+        for i := 0; i < n; i++ {
+            s, d := src[i*4:i*4+4], dst[i*4:i*4+4]
+            d[0], d[1], d[2], d[3] = s[r], s[g], s[b], 0xff
+            if alpha {
+                d[3] = s[a]
+            }
+        }
+        return
+    }
+
+    bytes := f.bytes()
+    for i := 0; i < n; i++ {
+        v := f.pixel(src[i*bytes:])
+        d := dst[i*4 : i*4+4]
+        d[0] = channel(v, f.rShift, f.rBits)
+        d[1] = channel(v, f.gShift, f.gBits)
+        d[2] = channel(v, f.bShift, f.bBits)
+        d[3] = 0xff
+        if alpha {
+            d[3] = channel(v, f.aShift, f.aBits)
+        }
+    }
+}
+
+// checkSize fails if data cannot hold height rows of width pixels.
+func (f format) checkSize(width, height, stride int, data []byte) error {
+    if width < 0 || height < 0 || stride < 0 {
+        return fmt.Errorf("negative size %dx%d or stride %d", width, height, stride)
+    }
+    if width == 0 || height == 0 {
+        return nil
+    }
+
+    row := width * f.bytes()
+    // Checked by division, stride*(height-1) may not fit in an int
+    if stride < row || len(data) < row || (len(data)-row)/stride < height-1 {
+        return fmt.Errorf("%d bytes are too few for %dx%d pixels with stride %d", len(data), width, height, stride)
+    }
+    return nil
+}
+
+// Decode converts pixels in a pixman format to an image: an *image.NRGBA if
+// the format has alpha and an *image.RGBA otherwise.
+func Decode(width, height, stride, format uint32, data []byte) (image.Image, error) {
+    f, err := parseFormat(format)
+    if err != nil {
+        return nil, err
+    }
+
+    w, h, s := int(width), int(height), int(stride)
+    if err = f.checkSize(w, h, s, data); err != nil {
+        return nil, err
+    }
+
+    r := image.Rect(0, 0, w, h)
+    var (
+        img image.Image
+        pix []uint8
+    )
+    if f.hasAlpha() {
+        nrgba := image.NewNRGBA(r)
+        img, pix = nrgba, nrgba.Pix
+    } else {
+        rgba := image.NewRGBA(r)
+        img, pix = rgba, rgba.Pix
+    }
+
+    for y := 0; y < h; y++ {
+        f.convertRow(pix[y*w*4:], data[y*s:], w, false)
+    }
+
+    return img, nil
+}
@@ -0,0 +1,508 @@
+// Package framebuffer keeps the screen of a console in memory, for programs
+// which want to look at the guest display rather than show it.
+//
+// A Framebuffer is a qemu.DisplayListener: register it on a console and it
+// follows the scanouts and updates QEMU sends, be they pixels on the bus,
+// shared memory or linear DMABUFs, converting them to RGBA as they come.
+package framebuffer
+
+import (
+    "bytes"
+    "context"
+    "fmt"
+    "image"
+    "image/draw"
+    "sync"
+    "syscall"
+    "unsafe"
+
+    "github.com/godbus/dbus/v5"
+)
+
+// DRM_FORMAT_MOD_LINEAR, the only layout which can be read through mmap
+const modLinear = 0
+
+// DMA_BUF_IOCTL_SYNC and its flags, from linux/dma-buf.h
+const (
+    dmaBufIoctlSync = 0x40086200
+    dmaBufSyncRead  = 1 << 0
+    dmaBufSyncStart = 0 << 2
+    dmaBufSyncEnd   = 1 << 2
+)
+
+// Damage tells what changed since the last one was received.
+type Damage struct {
+    // Rect is the part of the frame with new pixels, it may be empty
+    Rect image.Rectangle
+    // Resized is set if a scanout changed the size of the frame
+    Resized bool
+    // Cursor is set if the cursor image, position or visibility changed
+    Cursor bool
+}
+
+func (d Damage) empty() bool {
+    return d.Rect.Empty() && !d.Resized && !d.Cursor
+}
+
+func (d Damage) union(o Damage) Damage {
+    return Damage{d.Rect.Union(o.Rect), d.Resized || o.Resized, d.Cursor || o.Cursor}
+}
+
+// Cursor is the mouse pointer as the guest draws it.
+type Cursor struct {
+    Image *image.NRGBA
+    // Hot is the point of Image at Pos
+    Hot     image.Point
+    Pos     image.Point
+    Visible bool
+}
+
+// SameShape tells if c looks like o, wherever they are.
+func (c Cursor) SameShape(o Cursor) bool {
+    if c.Visible != o.Visible || c.Hot != o.Hot || (c.Image == nil) != (o.Image == nil) {
+        return false
+    }
+    if c.Image == nil {
+        return true
+    }
+    return c.Image.Rect == o.Image.Rect && bytes.Equal(c.Image.Pix, o.Image.Pix)
+}
+
+type watcher struct {
+    pending Damage
+    notify  chan struct{}
+}
+
+type Framebuffer struct {
+    mu    sync.Mutex
+    frame *image.RGBA
+
+    // The memory of the current ScanoutMap or DMABUF scanout, nil for
+    // plain Scanout
+    mapping []byte
+    source  []byte
+    stride  int
+    format  format
+    // flipped is set if the rows of source go from the bottom up
+    flipped bool
+    // dmabuf is the fd to sync with while reading, or -1
+    dmabuf int
+
+    cursor   Cursor
+    watchers []*watcher
+}
+
+func New() *Framebuffer {
+    return &Framebuffer{dmabuf: -1}
+}
+
+// Snapshot returns a copy of the current frame, or nil before the first
+// scanout.
+func (fb *Framebuffer) Snapshot() image.Image {
+    fb.mu.Lock()
+    defer fb.mu.Unlock()
+
+    if fb.frame == nil {
+        return nil
+    }
+
+    img := image.NewRGBA(fb.frame.Rect)
+    copy(img.Pix, fb.frame.Pix)
+    return img
+}
+
+// SnapshotWithCursor is Snapshot with the cursor drawn over the frame if it
+// is visible.
+func (fb *Framebuffer) SnapshotWithCursor() image.Image {
+    img := fb.Snapshot()
+    if img == nil {
+        return nil
+    }
+
+    c := fb.Cursor()
+    if c.Visible && c.Image != nil {
+        r := c.Image.Rect.Sub(c.Image.Rect.Min).Add(c.Pos.Sub(c.Hot))
+        draw.Draw(img.(*image.RGBA), r, c.Image, c.Image.Rect.Min, draw.Over)
+    }
+
+    return img
+}
+
+// Size returns the size of the frame, zero before the first scanout.
+func (fb *Framebuffer) Size() image.Point {
+    fb.mu.Lock()
+    defer fb.mu.Unlock()
+
+    if fb.frame == nil {
+        return image.Point{}
+    }
+    return fb.frame.Rect.Size()
+}
+
+// Cursor returns the cursor, with its own copy of the image.
+func (fb *Framebuffer) Cursor() Cursor {
+    fb.mu.Lock()
+    defer fb.mu.Unlock()
+
+    c := fb.cursor
+    if c.Image != nil {
+        img := image.NewNRGBA(c.Image.Rect)
+        copy(img.Pix, c.Image.Pix)
+        c.Image = img
+    }
+    return c
+}
+
+// Watch sends the damage done to the frame until ctx is done. Damage is
+// merged while the receiver is busy, so no change is missed however slow
+// it is. If there is a frame already, the first Damage covers it whole.
+func (fb *Framebuffer) Watch(ctx context.Context) <-chan Damage {
+    w := &watcher{notify: make(chan struct{}, 1)}
+    ch := make(chan Damage)
+
+    fb.mu.Lock()
+    fb.watchers = append(fb.watchers, w)
+    if fb.frame != nil {
+        // Only news to w, the others have seen the frame
+        w.pending = Damage{Rect: fb.frame.Rect, Resized: true}
+        w.notify <- struct{}{}
+    }
+    fb.mu.Unlock()
+
+    go func() {
+        defer close(ch)
+        defer fb.unwatch(w)
+
+        for {
+            select {
+            case <-w.notify:
+            case <-ctx.Done():
+                return
+            }
+
+            fb.mu.Lock()
+            d := w.pending
+            w.pending = Damage{}
+            fb.mu.Unlock()
+
+            if d.empty() {
+                continue
+            }
+
+            select {
+            case ch <- d:
+            case <-ctx.Done():
+                return
+            }
+        }
+    }()
+
+    return ch
+}
+
+func (fb *Framebuffer) unwatch(w *watcher) {
+    fb.mu.Lock()
+    defer fb.mu.Unlock()
+
+    for i, v := range fb.watchers {
+        if v == w {
+            fb.watchers = append(fb.watchers[:i], fb.watchers[i+1:]...)
+            break
+        }
+    }
+}
+
+func (fb *Framebuffer) damageLocked(d Damage) {
+    for _, w := range fb.watchers {
+        w.pending = w.pending.union(d)
+        select {
+        case w.notify <- struct{}{}:
+        default:
+        }
+    }
+}
+
+// Close releases the memory of the current scanout.
+func (fb *Framebuffer) Close() error {
+    fb.mu.Lock()
+    defer fb.mu.Unlock()
+
+    return fb.releaseLocked()
+}
+
+func (fb *Framebuffer) releaseLocked() error {
+    var err error
+    if fb.mapping != nil {
+        err = syscall.Munmap(fb.mapping)
+    }
+    if fb.dmabuf >= 0 {
+        syscall.Close(fb.dmabuf)
+    }
+
+    fb.mapping, fb.source, fb.dmabuf = nil, nil, -1
+    return err
+}
+
+// resizeLocked makes the frame width x height and tells if it was another
+// size before.
+func (fb *Framebuffer) resizeLocked(width, height uint32) bool {
+    r := image.Rect(0, 0, int(width), int(height))
+    if fb.frame != nil && fb.frame.Rect == r {
+        return false
+    }
+
+    fb.frame = image.NewRGBA(r)
+    return true
+}
+
+// mapLocked maps size bytes of fd, and points source at start in them.
+func (fb *Framebuffer) mapLocked(fd, start, size int) error {
+    mapping, err := syscall.Mmap(fd, 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+    if err != nil {
+        return fmt.Errorf("cannot map the scanout: %w", err)
+    }
+
+    fb.mapping = mapping
+    fb.source = mapping[start:]
+    return nil
+}
+
+func (fb *Framebuffer) dmabufSync(flags uint64) {
+    if fb.dmabuf < 0 {
+        return
+    }
+
+    // Fails on anything which is not a real DMABUF, which needs no sync
+    arg := struct{ flags uint64 }{flags}
+    syscall.Syscall(syscall.SYS_IOCTL, uintptr(fb.dmabuf), dmaBufIoctlSync, uintptr(unsafe.Pointer(&arg)))
+}
+
+// readSourceLocked converts r of the mapped scanout to the frame.
+func (fb *Framebuffer) readSourceLocked(r image.Rectangle) image.Rectangle {
+    if fb.source == nil {
+        return image.Rectangle{}
+    }
+
+    r = r.Intersect(fb.frame.Rect)
+    bytes := fb.format.bytes()
+
+    fb.dmabufSync(dmaBufSyncStart | dmaBufSyncRead)
+    defer fb.dmabufSync(dmaBufSyncEnd | dmaBufSyncRead)
+
+    for y := r.Min.Y; y < r.Max.Y; y++ {
+        row := y
+        if fb.flipped {
+            row = fb.frame.Rect.Dy() - 1 - y
+        }
+
+        src := fb.source[row*fb.stride+r.Min.X*bytes:]
+        fb.format.convertRow(fb.frame.Pix[fb.frame.PixOffset(r.Min.X, y):], src, r.Dx(), true)
+    }
+
+    return r
+}
+
+func (fb *Framebuffer) Scanout(width, height, stride, format uint32, data []byte) *dbus.Error {
+    f, err := parseFormat(format)
+    if err == nil {
+        err = f.checkSize(int(width), int(height), int(stride), data)
+    }
+    if err != nil {
+        return dbus.MakeFailedError(err)
+    }
+
+    fb.mu.Lock()
+    defer fb.mu.Unlock()
+
+    fb.releaseLocked()
+    resized := fb.resizeLocked(width, height)
+
+    for y := 0; y < int(height); y++ {
+        f.convertRow(fb.frame.Pix[y*fb.frame.Stride:], data[y*int(stride):], int(width), true)
+    }
+
+    fb.damageLocked(Damage{Rect: fb.frame.Rect, Resized: resized})
+    return nil
+}
+
+func (fb *Framebuffer) Update(x, y, width, height int32, stride, format uint32, data []byte) *dbus.Error {
+    f, err := parseFormat(format)
+    if err == nil {
+        err = f.checkSize(int(width), int(height), int(stride), data)
+    }
+    if err != nil {
+        return dbus.MakeFailedError(err)
+    }
+
+    fb.mu.Lock()
+    defer fb.mu.Unlock()
+
+    if fb.frame == nil {
+        return dbus.MakeFailedError(fmt.Errorf("Update before Scanout"))
+    }
+
+    rect := image.Rect(int(x), int(y), int(x)+int(width), int(y)+int(height))
+    r := rect.Intersect(fb.frame.Rect)
+    for row := r.Min.Y; row < r.Max.Y; row++ {
+        src := data[(row-rect.Min.Y)*int(stride)+(r.Min.X-rect.Min.X)*f.bytes():]
+        f.convertRow(fb.frame.Pix[fb.frame.PixOffset(r.Min.X, row):], src, r.Dx(), true)
+    }
+
+    fb.damageLocked(Damage{Rect: r})
+    return nil
+}
+
+func (fb *Framebuffer) ScanoutMap(fd dbus.UnixFD, offset, width, height, stride, format uint32) *dbus.Error {
+    // The mapping stays valid without the fd
+    defer syscall.Close(int(fd))
+
+    f, err := parseFormat(format)
+    if err != nil {
+        return dbus.MakeFailedError(err)
+    }
+
+    fb.mu.Lock()
+    defer fb.mu.Unlock()
+
+    return fb.scanoutMappedLocked(int(fd), int(offset), int(offset)+int(stride)*int(height), width, height, int(stride), f, false, -1)
+}
+
+func (fb *Framebuffer) UpdateMap(x, y, width, height int32) *dbus.Error {
+    fb.mu.Lock()
+    defer fb.mu.Unlock()
+
+    if fb.source == nil {
+        return dbus.MakeFailedError(fmt.Errorf("UpdateMap before ScanoutMap"))
+    }
+    if width < 0 || height < 0 {
+        return dbus.MakeFailedError(fmt.Errorf("negative update size %dx%d", width, height))
+    }
+
+    r := fb.readSourceLocked(image.Rect(int(x), int(y), int(x)+int(width), int(y)+int(height)))
+    fb.damageLocked(Damage{Rect: r})
+    return nil
+}
+
+// scanoutMappedLocked maps size bytes of fd and makes the width x height
+// pixels from start the frame. dmabuf is fd if it must be synced with.
+func (fb *Framebuffer) scanoutMappedLocked(fd, start, size int, width, height uint32, stride int, f format, flipped bool, dmabuf int) *dbus.Error {
+    fb.releaseLocked()
+
+    if start > size {
+        return dbus.MakeFailedError(fmt.Errorf("scanout starts at %d, past the %d bytes of the buffer", start, size))
+    }
+    if err := fb.mapLocked(fd, start, size); err != nil {
+        return dbus.MakeFailedError(err)
+    }
+    if err := f.checkSize(int(width), int(height), stride, fb.source); err != nil {
+        fb.releaseLocked()
+        return dbus.MakeFailedError(err)
+    }
+
+    fb.stride, fb.format, fb.flipped, fb.dmabuf = stride, f, flipped, dmabuf
+    resized := fb.resizeLocked(width, height)
+    fb.readSourceLocked(fb.frame.Rect)
+
+    fb.damageLocked(Damage{Rect: fb.frame.Rect, Resized: resized})
+    return nil
+}
+
+// scanoutDMABUF reads a linear DMABUF. Without y0_top the rows are stored
+// from the bottom up, as GL renders them.
+func (fb *Framebuffer) scanoutDMABUF(fd int, x, y, width, height, offset, stride, fourcc, backingHeight uint32, modifier uint64, y0_top bool) *dbus.Error {
+    if modifier != modLinear {
+        syscall.Close(fd)
+        return dbus.MakeFailedError(fmt.Errorf("cannot read DMABUF with modifier %#x, only linear ones", modifier))
+    }
+
+    code, ok := drmFormats[fourcc]
+    if !ok {
+        syscall.Close(fd)
+        return dbus.MakeFailedError(fmt.Errorf("unsupported DMABUF format %#x", fourcc))
+    }
+
+    f, err := parseFormat(code)
+    if err != nil {
+        syscall.Close(fd)
+        return dbus.MakeFailedError(err)
+    }
+
+    fb.mu.Lock()
+    defer fb.mu.Unlock()
+
+    // The shown part starts at its lowest row in memory, which is its
+    // bottom one if the rows go up
+    if !y0_top {
+        y = backingHeight - y - height
+    }
+    start := int(offset) + int(y)*int(stride) + int(x)*f.bytes()
+    size := int(offset) + int(stride)*int(backingHeight)
+
+    derr := fb.scanoutMappedLocked(fd, start, size, width, height, int(stride), f, !y0_top, fd)
+    if derr != nil {
+        syscall.Close(fd)
+    }
+    return derr
+}
+
+func (fb *Framebuffer) ScanoutDMABUF(fd dbus.UnixFD, width, height, stride, fourcc uint32, modifier uint64, y0_top bool) *dbus.Error {
+    return fb.scanoutDMABUF(int(fd), 0, 0, width, height, 0, stride, fourcc, height, modifier, y0_top)
+}
+
+func (fb *Framebuffer) ScanoutDMABUF2(fd []dbus.UnixFD, x, y, width, height uint32, offset, stride []uint32, num_planes, fourcc, backing_width, backing_height uint32, modifier uint64, y0_top bool) *dbus.Error {
+    if len(fd) != 1 || num_planes != 1 || len(offset) < 1 || len(stride) < 1 {
+        for _, v := range fd {
+            syscall.Close(int(v))
+        }
+        return dbus.MakeFailedError(fmt.Errorf("cannot read DMABUF with %d planes", num_planes))
+    }
+
+    return fb.scanoutDMABUF(int(fd[0]), x, y, width, height, offset[0], stride[0], fourcc, backing_height, modifier, y0_top)
+}
+
+func (fb *Framebuffer) UpdateDMABUF(x, y, width, height int32) *dbus.Error {
+    fb.mu.Lock()
+    defer fb.mu.Unlock()
+
+    if fb.source == nil {
+        return dbus.MakeFailedError(fmt.Errorf("UpdateDMABUF before ScanoutDMABUF"))
+    }
+
+    r := fb.readSourceLocked(image.Rect(int(x), int(y), int(x+width), int(y+height)))
+    fb.damageLocked(Damage{Rect: r})
+    return nil
+}
+
+// Disable keeps the last frame, only the memory QEMU shared is let go.
+func (fb *Framebuffer) Disable() *dbus.Error {
+    fb.mu.Lock()
+    defer fb.mu.Unlock()
+
+    fb.releaseLocked()
+    return nil
+}
+
+func (fb *Framebuffer) MouseSet(x, y, on int) *dbus.Error {
+    fb.mu.Lock()
+    defer fb.mu.Unlock()
+
+    fb.cursor.Pos = image.Pt(x, y)
+    fb.cursor.Visible = on != 0
+    fb.damageLocked(Damage{Cursor: true})
+    return nil
+}
+
+func (fb *Framebuffer) CursorDefine(width, height, hot_x, hot_y int, data []byte) *dbus.Error {
+    img, err := Decode(uint32(width), uint32(height), uint32(width*4), PixmanA8R8G8B8, data)
+    if err != nil {
+        return dbus.MakeFailedError(err)
+    }
+
+    fb.mu.Lock()
+    defer fb.mu.Unlock()
+
+    fb.cursor.Image = img.(*image.NRGBA)
+    fb.cursor.Hot = image.Pt(hot_x, hot_y)
+    fb.damageLocked(Damage{Cursor: true})
+    return nil
+}
@@ -0,0 +1,290 @@
+package framebuffer_test
+
+import (
+    "context"
+    "image"
+    "image/color"
+    "testing"
+    "time"
+
+    "qemu"
+    "qemu/framebuffer"
+    "qemu/qemutest"
+)
+
+// follow registers a new framebuffer on the console of a fake QEMU, and
+// returns the QEMU side of it.
+func follow(t *testing.T, ctx context.Context) (*framebuffer.Framebuffer, *qemutest.Listener) {
+    t.Helper()
+
+    srv, err := qemutest.NewServer(qemutest.VM{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { srv.Close() })
+
+    dialCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    vm, err := qemu.Connect(dialCtx, qemu.WithAddress(srv.Address()))
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(vm.Close)
+
+    console, err := vm.GetConsole(0)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    fb := framebuffer.New()
+    t.Cleanup(func() { fb.Close() })
+    if err := console.RegisterListener(fb); err != nil {
+        t.Fatal(err)
+    }
+
+    l, err := srv.WaitListener(ctx, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return fb, l
+}
+
+// xrgb returns a pixel in the X8R8G8B8 format, as stored in memory.
+func xrgb(r, g, b byte) []byte {
+    return []byte{b, g, r, 0}
+}
+
+func pixels(px ...[]byte) []byte {
+    var data []byte
+    for _, p := range px {
+        data = append(data, p...)
+    }
+    return data
+}
+
+func checkPixel(t *testing.T, img image.Image, x, y int, want color.RGBA) {
+    t.Helper()
+
+    rgba, ok := img.(*image.RGBA)
+    if !ok {
+        t.Fatalf("snapshot is %T", img)
+    }
+    if got := rgba.RGBAAt(x, y); got != want {
+        t.Errorf("pixel %d,%d is %v, want %v", x, y, got, want)
+    }
+}
+
+func TestScanoutUpdate(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    fb, l := follow(t, ctx)
+    damage := fb.Watch(ctx)
+
+    if fb.Snapshot() != nil {
+        t.Error("snapshot before the first scanout")
+    }
+
+    data := pixels(xrgb(1, 2, 3), xrgb(4, 5, 6), xrgb(7, 8, 9), xrgb(10, 11, 12))
+    if err := l.Play(qemutest.Scanout{Width: 2, Height: 2, Stride: 8, Format: qemutest.FormatX8R8G8B8, Data: data}); err != nil {
+        t.Fatal(err)
+    }
+    if d := <-damage; !d.Resized || d.Rect != image.Rect(0, 0, 2, 2) {
+        t.Errorf("scanout damage is %+v", d)
+    }
+    if size := fb.Size(); size != image.Pt(2, 2) {
+        t.Errorf("size is %v, want 2x2", size)
+    }
+
+    img := fb.Snapshot()
+    checkPixel(t, img, 1, 0, color.RGBA{4, 5, 6, 0xff})
+    checkPixel(t, img, 0, 1, color.RGBA{7, 8, 9, 0xff})
+
+    update := qemutest.Update{X: 1, Y: 1, Width: 1, Height: 1, Stride: 4, Format: qemutest.FormatX8R8G8B8, Data: xrgb(0xff, 0, 0)}
+    if err := l.Play(update); err != nil {
+        t.Fatal(err)
+    }
+    if d := <-damage; d.Resized || d.Rect != image.Rect(1, 1, 2, 2) {
+        t.Errorf("update damage is %+v", d)
+    }
+
+    checkPixel(t, fb.Snapshot(), 1, 1, color.RGBA{0xff, 0, 0, 0xff})
+
+    // Snapshots are copies
+    checkPixel(t, img, 1, 1, color.RGBA{10, 11, 12, 0xff})
+}
+
+func TestScanoutMap(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    fb, l := follow(t, ctx)
+    damage := fb.Watch(ctx)
+
+    interfaces, err := l.Interfaces()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(interfaces) < 2 || interfaces[1] != "org.qemu.Display1.Listener.Unix.Map" {
+        t.Fatalf("listener interfaces are %v", interfaces)
+    }
+
+    data := pixels(xrgb(1, 2, 3), xrgb(4, 5, 6), xrgb(7, 8, 9), xrgb(10, 11, 12))
+    if err := l.Play(qemutest.ScanoutMap{Width: 2, Height: 2, Stride: 8, Format: qemutest.FormatX8R8G8B8, Data: data}); err != nil {
+        t.Fatal(err)
+    }
+    if d := <-damage; !d.Resized {
+        t.Errorf("scanout damage is %+v", d)
+    }
+    checkPixel(t, fb.Snapshot(), 1, 1, color.RGBA{10, 11, 12, 0xff})
+
+    if err := l.Play(qemutest.UpdateMap{X: 0, Y: 1, Width: 1, Height: 1, Data: xrgb(50, 60, 70)}); err != nil {
+        t.Fatal(err)
+    }
+    if d := <-damage; d.Rect != image.Rect(0, 1, 1, 2) {
+        t.Errorf("update damage is %+v", d)
+    }
+    img := fb.Snapshot()
+    checkPixel(t, img, 0, 1, color.RGBA{50, 60, 70, 0xff})
+    checkPixel(t, img, 0, 0, color.RGBA{1, 2, 3, 0xff})
+}
+
+func TestScanoutDMABUF(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    fb, l := follow(t, ctx)
+    damage := fb.Watch(ctx)
+
+    // DRM_FORMAT_XRGB8888, rows from the bottom up
+    const xr24 = 0x34325258
+    data := pixels(xrgb(1, 1, 1), xrgb(2, 2, 2), xrgb(3, 3, 3), xrgb(4, 4, 4))
+    if err := l.Play(qemutest.ScanoutDMABUF{Width: 2, Height: 2, Stride: 8, Fourcc: xr24, Y0Top: false, Data: data}); err != nil {
+        t.Fatal(err)
+    }
+    if d := <-damage; !d.Resized {
+        t.Errorf("scanout damage is %+v", d)
+    }
+
+    img := fb.Snapshot()
+    checkPixel(t, img, 0, 0, color.RGBA{3, 3, 3, 0xff})
+    checkPixel(t, img, 1, 1, color.RGBA{2, 2, 2, 0xff})
+
+    // Tiled buffers cannot be read
+    if err := l.Play(qemutest.ScanoutDMABUF{Width: 2, Height: 2, Stride: 8, Fourcc: xr24, Modifier: 5, Y0Top: true, Data: data}); err == nil {
+        t.Error("scanout of a tiled DMABUF accepted")
+    }
+}
+
+func TestCursor(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    fb, l := follow(t, ctx)
+    damage := fb.Watch(ctx)
+
+    err := l.Play(
+        qemutest.Scanout{Width: 2, Height: 1, Stride: 8, Format: qemutest.FormatX8R8G8B8, Data: make([]byte, 8)},
+        // A8R8G8B8, opaque blue
+        qemutest.CursorDefine{Width: 1, Height: 1, Data: []byte{0xff, 0, 0, 0xff}},
+        qemutest.MouseSet{X: 1, Y: 0, On: 1},
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    for d := range damage {
+        if cursor := fb.Cursor(); d.Cursor && cursor.Visible && cursor.Pos == image.Pt(1, 0) {
+            break
+        }
+    }
+
+    img := fb.SnapshotWithCursor()
+    checkPixel(t, img, 1, 0, color.RGBA{0, 0, 0xff, 0xff})
+    checkPixel(t, img, 0, 0, color.RGBA{0, 0, 0, 0xff})
+    checkPixel(t, fb.Snapshot(), 1, 0, color.RGBA{0, 0, 0, 0xff})
+}
+
+func TestDecode(t *testing.T) {
+    // PIXMAN_r5g6b5, pure red
+    img, err := framebuffer.Decode(1, 1, 2, 0x10020565, []byte{0x00, 0xf8})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if r, g, b, a := img.At(0, 0).RGBA(); r != 0xffff || g != 0 || b != 0 || a != 0xffff {
+        t.Errorf("pixel is %v, want red", img.At(0, 0))
+    }
+
+    if _, err := framebuffer.Decode(2, 2, 8, qemutest.FormatX8R8G8B8, make([]byte, 4)); err == nil {
+        t.Error("decoded a frame from too little data")
+    }
+}
+
+func TestWatchTwice(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    fb, l := follow(t, ctx)
+    first := fb.Watch(ctx)
+
+    data := pixels(xrgb(1, 2, 3), xrgb(4, 5, 6), xrgb(7, 8, 9), xrgb(10, 11, 12))
+    if err := l.Play(qemutest.Scanout{Width: 2, Height: 2, Stride: 8, Format: qemutest.FormatX8R8G8B8, Data: data}); err != nil {
+        t.Fatal(err)
+    }
+    <-first
+
+    // A new watcher gets the whole frame, the first one nothing
+    second := fb.Watch(ctx)
+    if d := <-second; !d.Resized || d.Rect != image.Rect(0, 0, 2, 2) {
+        t.Errorf("second watcher started with %+v", d)
+    }
+    select {
+    case d := <-first:
+        t.Errorf("first watcher got %+v when the second one started", d)
+    case <-time.After(100 * time.Millisecond):
+    }
+
+    update := qemutest.Update{X: 0, Y: 1, Width: 1, Height: 1, Stride: 4, Format: qemutest.FormatX8R8G8B8, Data: xrgb(0xff, 0, 0)}
+    if err := l.Play(update); err != nil {
+        t.Fatal(err)
+    }
+    for _, damage := range []<-chan framebuffer.Damage{first, second} {
+        if d := <-damage; d.Resized || d.Rect != image.Rect(0, 1, 1, 2) {
+            t.Errorf("update damage is %+v", d)
+        }
+    }
+}
+
+func TestBadSizes(t *testing.T) {
+    fb := framebuffer.New()
+    defer fb.Close()
+
+    data := pixels(xrgb(1, 2, 3), xrgb(4, 5, 6), xrgb(7, 8, 9), xrgb(10, 11, 12))
+    if err := fb.Scanout(2, 2, 8, qemutest.FormatX8R8G8B8, data); err != nil {
+        t.Fatal(err)
+    }
+
+    updates := []struct {
+        x, y, width, height int32
+        stride              uint32
+    }{
+        {0, 0, -1, 1, 4},
+        {0, 0, 1, -1, 4},
+        {1, 1, -2, -2, 4},
+        // Rows far past the data
+        {0, 0, 1, 0x7fffffff, 0xffffffff},
+    }
+    for _, u := range updates {
+        if err := fb.Update(u.x, u.y, u.width, u.height, u.stride, qemutest.FormatX8R8G8B8, data); err == nil {
+            t.Errorf("update %+v accepted", u)
+        }
+    }
+
+    if _, err := framebuffer.Decode(1, 0xffffffff, 0xffffffff, qemutest.FormatX8R8G8B8, data); err == nil {
+        t.Error("decoded 4 bytes as 1x4294967295 pixels")
+    }
+
+    // The frame is untouched
+    checkPixel(t, fb.Snapshot(), 1, 1, color.RGBA{10, 11, 12, 0xff})
+}
@@ -1,12 +1,19 @@
 package qemu
 
 import (
+    "context"
+    "fmt"
+    "time"
+
     "github.com/godbus/dbus/v5"
+
+    "qemu/keymap"
 )
 
 type Keyboard struct {
-    conn     *dbus.Conn
-    keyboard dbus.BusObject
+    conn      *dbus.Conn
+    keyboard  dbus.BusObject
+    typeDelay time.Duration
 }
 
 type KeyboardModifier int
@@ -17,16 +24,64 @@ const (
     Caps   KeyboardModifier = 1 << 2
 )
 
+const defaultTypeDelay = 10 * time.Millisecond
+
 func newKeyboard(conn *dbus.Conn, keyboard dbus.BusObject) (*Keyboard, error) {
-    return &Keyboard{conn, keyboard}, nil
+    return &Keyboard{conn, keyboard, defaultTypeDelay}, nil
 }
 
 func (k *Keyboard) GetModifiers() KeyboardModifier {
     mod, err := getProp(k.keyboard, keyboardModifiers)
     if err != nil {
-        mod = 0
+        return 0
+    }
+    return KeyboardModifier(mod.(uint32))
+}
+
+// WatchModifiers sends the current lock state and then every change of it,
+// until ctx is done. The channel is closed afterwards.
+func (k *Keyboard) WatchModifiers(ctx context.Context) (<-chan KeyboardModifier, error) {
+    changes := make(chan KeyboardModifier, 16)
+
+    done, err := watchProperties(ctx, k.conn, k.keyboard, keyboardIntf, func(changed map[string]dbus.Variant, invalidated []string) {
+        if v, ok := changed["Modifiers"]; ok {
+            if mod, ok := v.Value().(uint32); ok {
+                select {
+                case changes <- KeyboardModifier(mod):
+                case <-ctx.Done():
+                }
+            }
+        }
+    })
+    if err != nil {
+        return nil, err
     }
-    return KeyboardModifier(mod.(int))
+
+    // Subscribe first, so that no change is lost between the two
+    initial := k.GetModifiers()
+
+    mods := make(chan KeyboardModifier)
+
+    go func() {
+        defer close(mods)
+
+        mod := initial
+        for {
+            select {
+            case mods <- mod:
+            case <-ctx.Done():
+                return
+            }
+
+            select {
+            case mod = <-changes:
+            case <-done:
+                return
+            }
+        }
+    }()
+
+    return mods, nil
 }
 
 func (k *Keyboard) Press(keycode uint32) {
@@ -36,3 +91,111 @@ func (k *Keyboard) Press(keycode uint32) {
 func (k *Keyboard) Release(keycode uint32) {
     k.keyboard.Call(keyboardRelease, 0, keycode)
 }
+
+// SetTypeDelay sets the pause TypeText makes after every key event, slow
+// guests may drop keys if it is too short.
+func (k *Keyboard) SetTypeDelay(delay time.Duration) {
+    k.typeDelay = delay
+}
+
+// TypeText types s as if it was entered on a keyboard with the given guest
+// layout. Nothing is typed if some character has no strokes in layout.
+func (k *Keyboard) TypeText(ctx context.Context, s string, layout *keymap.Layout) error {
+    var strokes []keymap.Stroke
+    for _, r := range s {
+        st, ok := layout.Strokes(r)
+        if !ok {
+            return fmt.Errorf("cannot type %q with the %s layout", r, layout.Name())
+        }
+        strokes = append(strokes, st...)
+    }
+
+    for _, st := range strokes {
+        err := k.typeStroke(ctx, st)
+        if err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+func (k *Keyboard) typeStroke(ctx context.Context, st keymap.Stroke) error {
+    var mods []uint32
+    if st.Mods&keymap.Shift != 0 {
+        mods = append(mods, typeModifier("shift"))
+    }
+    if st.Mods&keymap.AltGr != 0 {
+        mods = append(mods, typeModifier("alt_r"))
+    }
+
+    type keyEvent struct {
+        keycode uint32
+        press   bool
+    }
+
+    var events []keyEvent
+    for _, m := range mods {
+        events = append(events, keyEvent{m, true})
+    }
+    events = append(events, keyEvent{st.Key.Qnum, true}, keyEvent{st.Key.Qnum, false})
+    for i := len(mods) - 1; i >= 0; i-- {
+        events = append(events, keyEvent{mods[i], false})
+    }
+
+    // The keys down, in the order they were pressed
+    var pressed []uint32
+
+    for _, event := range events {
+        if event.press {
+            k.Press(event.keycode)
+            pressed = append(pressed, event.keycode)
+        } else {
+            k.Release(event.keycode)
+            pressed = pressed[:len(pressed)-1]
+        }
+
+        select {
+        case <-ctx.Done():
+            // Released in reverse order like SendKeys, stuck keys are worse
+            for i := len(pressed) - 1; i >= 0; i-- {
+                k.Release(pressed[i])
+            }
+            return ctx.Err()
+        case <-time.After(k.typeDelay):
+        }
+    }
+
+    return nil
+}
+
+// SendKeys presses keys in order, holds them for hold and releases them in
+// reverse order, like the QMP send-key command. Use it for combinations
+// such as Ctrl+Alt+Delete.
+func (k *Keyboard) SendKeys(ctx context.Context, keys []keymap.Key, hold time.Duration) error {
+    for _, key := range keys {
+        k.Press(key.Qnum)
+    }
+
+    var err error
+    select {
+    case <-ctx.Done():
+        err = ctx.Err()
+    case <-time.After(hold):
+    }
+
+    // Released even if canceled, stuck keys are worse
+    for i := len(keys) - 1; i >= 0; i-- {
+        k.Release(keys[i].Qnum)
+    }
+
+    return err
+}
+
+func typeModifier(qcode string) uint32 {
+    key, ok := keymap.LookupQCode(qcode)
+    if !ok {
+        panic(fmt.Sprintf("no %s key in the keymap", qcode))
+    }
+    return key.Qnum
+}
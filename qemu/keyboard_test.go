@@ -0,0 +1,201 @@
+package qemu_test
+
+import (
+    "context"
+    "slices"
+    "testing"
+    "time"
+
+    "qemu"
+    "qemu/keymap"
+    "qemu/qemutest"
+)
+
+type keyEvent struct {
+    method string
+    qcode  string
+}
+
+// keyEvents returns the keyboard calls recorded by srv.
+func keyEvents(t *testing.T, srv *qemutest.Server) []keyEvent {
+    t.Helper()
+
+    var events []keyEvent
+    for _, call := range srv.Calls() {
+        method := call.Method[len("org.qemu.Display1.Keyboard."):]
+        key, ok := keymap.LookupQnum(call.Args[0].(uint32))
+        if !ok {
+            t.Fatalf("%s of unknown key %v", call.Method, call.Args[0])
+        }
+        events = append(events, keyEvent{method, key.QCode})
+    }
+    return events
+}
+
+func getKeyboard(t *testing.T, console *qemu.Console) *qemu.Keyboard {
+    t.Helper()
+
+    keyboard, err := console.GetKeyboard()
+    if err != nil {
+        t.Fatal(err)
+    }
+    return keyboard
+}
+
+func TestTypeText(t *testing.T) {
+    srv, console := connect(t, qemutest.VM{})
+    keyboard := getKeyboard(t, console)
+    keyboard.SetTypeDelay(0)
+
+    if err := keyboard.TypeText(context.Background(), "aB", keymap.US); err != nil {
+        t.Fatal(err)
+    }
+
+    want := []keyEvent{
+        {"Press", "a"}, {"Release", "a"},
+        {"Press", "shift"}, {"Press", "b"}, {"Release", "b"}, {"Release", "shift"},
+    }
+    if got := keyEvents(t, srv); !slices.Equal(got, want) {
+        t.Errorf("typed %v, want %v", got, want)
+    }
+}
+
+func TestTypeTextLayouts(t *testing.T) {
+    // A key typed alone, with Shift or with AltGr
+    tap := func(qcode string) []keyEvent {
+        return []keyEvent{{"Press", qcode}, {"Release", qcode}}
+    }
+    with := func(mod, qcode string) []keyEvent {
+        return []keyEvent{{"Press", mod}, {"Press", qcode}, {"Release", qcode}, {"Release", mod}}
+    }
+
+    tests := []struct {
+        layout *keymap.Layout
+        text   string
+        want   []keyEvent
+    }{
+        {keymap.DE, "z", tap("y")},
+        {keymap.DE, "@", with("alt_r", "q")},
+        {keymap.DE, "€", with("alt_r", "e")},
+        {keymap.DE, "Ü", with("shift", "bracket_left")},
+        // Dead keys, followed by the base letter or by space
+        {keymap.DE, "é", append(tap("equal"), tap("e")...)},
+        {keymap.DE, "ê", append(tap("grave_accent"), tap("e")...)},
+        {keymap.DE, "^", append(tap("grave_accent"), tap("spc")...)},
+        {keymap.FR, "é", tap("2")},
+        {keymap.FR, "a", tap("q")},
+        {keymap.FR, "ê", append(tap("bracket_left"), tap("e")...)},
+        {keymap.FR, "ë", append(with("shift", "bracket_left"), tap("e")...)},
+        {keymap.RU, "д", tap("l")},
+        {keymap.RU, "Ж", with("shift", "semicolon")},
+        {keymap.RU, "№", with("shift", "3")},
+    }
+
+    for _, test := range tests {
+        srv, console := connect(t, qemutest.VM{})
+        keyboard := getKeyboard(t, console)
+        keyboard.SetTypeDelay(0)
+
+        if err := keyboard.TypeText(context.Background(), test.text, test.layout); err != nil {
+            t.Errorf("%s: %v", test.layout.Name(), err)
+            continue
+        }
+        if got := keyEvents(t, srv); !slices.Equal(got, test.want) {
+            t.Errorf("typing %q with %s typed %v, want %v", test.text, test.layout.Name(), got, test.want)
+        }
+    }
+}
+
+func TestTypeTextUntypable(t *testing.T) {
+    srv, console := connect(t, qemutest.VM{})
+    keyboard := getKeyboard(t, console)
+
+    if err := keyboard.TypeText(context.Background(), "a☃", keymap.US); err == nil {
+        t.Error("typed a snowman with the us layout")
+    }
+    if calls := srv.Calls(); len(calls) != 0 {
+        t.Errorf("typed %v before failing", calls)
+    }
+}
+
+func TestTypeTextCanceled(t *testing.T) {
+    tests := []struct {
+        text string
+        want []keyEvent
+    }{
+        {"a", []keyEvent{{"Press", "a"}, {"Release", "a"}}},
+        {"A", []keyEvent{{"Press", "shift"}, {"Release", "shift"}}},
+    }
+
+    for _, test := range tests {
+        srv, console := connect(t, qemutest.VM{})
+        keyboard := getKeyboard(t, console)
+        keyboard.SetTypeDelay(time.Hour)
+
+        ctx, cancel := context.WithCancel(context.Background())
+
+        // Cancel while the first key is down
+        go func() {
+            for len(srv.Calls()) < 1 {
+                time.Sleep(time.Millisecond)
+            }
+            cancel()
+        }()
+
+        if err := keyboard.TypeText(ctx, test.text, keymap.US); err != context.Canceled {
+            t.Fatalf("TypeText(%q) returned %v", test.text, err)
+        }
+        if got := keyEvents(t, srv); !slices.Equal(got, test.want) {
+            t.Errorf("typing %q typed %v, want %v", test.text, got, test.want)
+        }
+    }
+}
+
+func TestSendKeys(t *testing.T) {
+    srv, console := connect(t, qemutest.VM{})
+    keyboard := getKeyboard(t, console)
+
+    var keys []keymap.Key
+    for _, qcode := range []string{"ctrl", "alt", "delete"} {
+        key, _ := keymap.LookupQCode(qcode)
+        keys = append(keys, key)
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    if err := keyboard.SendKeys(ctx, keys, time.Hour); err != context.Canceled {
+        t.Fatalf("SendKeys returned %v", err)
+    }
+
+    want := []keyEvent{
+        {"Press", "ctrl"}, {"Press", "alt"}, {"Press", "delete"},
+        {"Release", "delete"}, {"Release", "alt"}, {"Release", "ctrl"},
+    }
+    if got := keyEvents(t, srv); !slices.Equal(got, want) {
+        t.Errorf("sent %v, want %v", got, want)
+    }
+}
+
+func TestWatchModifiers(t *testing.T) {
+    srv, console := connect(t, qemutest.VM{})
+    keyboard := getKeyboard(t, console)
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    mods, err := keyboard.WatchModifiers(ctx)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if mod := <-mods; mod != 0 {
+        t.Errorf("initial modifiers are %v", mod)
+    }
+
+    srv.SetModifiers(0, uint32(qemu.Num|qemu.Caps))
+    if mod := <-mods; mod != qemu.Num|qemu.Caps {
+        t.Errorf("modifiers changed to %v, want %v", mod, qemu.Num|qemu.Caps)
+    }
+    if mod := keyboard.GetModifiers(); mod != qemu.Num|qemu.Caps {
+        t.Errorf("GetModifiers returned %v, want %v", mod, qemu.Num|qemu.Caps)
+    }
+}
@@ -0,0 +1,32 @@
+package keymap
+
+type decomposition struct {
+    base rune
+    mark rune
+}
+
+// decompositions lists the precomposed characters that can be typed with
+// a dead key followed by the base letter.
+var decompositions = func() map[rune]decomposition {
+    m := make(map[rune]decomposition)
+
+    for _, d := range []struct {
+        mark  rune
+        pairs string
+    }{
+        {deadGrave, "aàeèiìoòuùAÀEÈIÌOÒUÙ"},
+        {deadAcute, "aáeéiíoóuúyýcćnńsśzźAÁEÉIÍOÓUÚYÝCĆNŃSŚZŹ"},
+        {deadCircumflex, "aâeêiîoôuûAÂEÊIÎOÔUÛ"},
+        {deadTilde, "aãnñoõAÃNÑOÕ"},
+        {deadDiaeresis, "aäeëiïoöuüyÿAÄEËIÏOÖUÜ"},
+        {deadAbovering, "aåuůAÅUŮ"},
+        {deadCedilla, "cçsşCÇSŞ"},
+    } {
+        pairs := []rune(d.pairs)
+        for i := 0; i+1 < len(pairs); i += 2 {
+            m[pairs[i+1]] = decomposition{pairs[i], d.mark}
+        }
+    }
+
+    return m
+}()
@@ -0,0 +1,172 @@
+package keymap
+
+// domCodes maps the KeyboardEvent.code values of browsers, which name
+// physical keys after the US layout, to QKeyCode names
+var domCodes = map[string]string{
+    "Escape":             "esc",
+    "Digit1":             "1",
+    "Digit2":             "2",
+    "Digit3":             "3",
+    "Digit4":             "4",
+    "Digit5":             "5",
+    "Digit6":             "6",
+    "Digit7":             "7",
+    "Digit8":             "8",
+    "Digit9":             "9",
+    "Digit0":             "0",
+    "Minus":              "minus",
+    "Equal":              "equal",
+    "Backspace":          "backspace",
+    "Tab":                "tab",
+    "KeyQ":               "q",
+    "KeyW":               "w",
+    "KeyE":               "e",
+    "KeyR":               "r",
+    "KeyT":               "t",
+    "KeyY":               "y",
+    "KeyU":               "u",
+    "KeyI":               "i",
+    "KeyO":               "o",
+    "KeyP":               "p",
+    "BracketLeft":        "bracket_left",
+    "BracketRight":       "bracket_right",
+    "Enter":              "ret",
+    "ControlLeft":        "ctrl",
+    "KeyA":               "a",
+    "KeyS":               "s",
+    "KeyD":               "d",
+    "KeyF":               "f",
+    "KeyG":               "g",
+    "KeyH":               "h",
+    "KeyJ":               "j",
+    "KeyK":               "k",
+    "KeyL":               "l",
+    "Semicolon":          "semicolon",
+    "Quote":              "apostrophe",
+    "Backquote":          "grave_accent",
+    "ShiftLeft":          "shift",
+    "Backslash":          "backslash",
+    "KeyZ":               "z",
+    "KeyX":               "x",
+    "KeyC":               "c",
+    "KeyV":               "v",
+    "KeyB":               "b",
+    "KeyN":               "n",
+    "KeyM":               "m",
+    "Comma":              "comma",
+    "Period":             "dot",
+    "Slash":              "slash",
+    "ShiftRight":         "shift_r",
+    "NumpadMultiply":     "kp_multiply",
+    "AltLeft":            "alt",
+    "Space":              "spc",
+    "CapsLock":           "caps_lock",
+    "F1":                 "f1",
+    "F2":                 "f2",
+    "F3":                 "f3",
+    "F4":                 "f4",
+    "F5":                 "f5",
+    "F6":                 "f6",
+    "F7":                 "f7",
+    "F8":                 "f8",
+    "F9":                 "f9",
+    "F10":                "f10",
+    "NumLock":            "num_lock",
+    "ScrollLock":         "scroll_lock",
+    "Numpad7":            "kp_7",
+    "Numpad8":            "kp_8",
+    "Numpad9":            "kp_9",
+    "NumpadSubtract":     "kp_subtract",
+    "Numpad4":            "kp_4",
+    "Numpad5":            "kp_5",
+    "Numpad6":            "kp_6",
+    "NumpadAdd":          "kp_add",
+    "Numpad1":            "kp_1",
+    "Numpad2":            "kp_2",
+    "Numpad3":            "kp_3",
+    "Numpad0":            "kp_0",
+    "NumpadDecimal":      "kp_decimal",
+    "Lang5":              "zenkakuhankaku",
+    "IntlBackslash":      "less",
+    "F11":                "f11",
+    "F12":                "f12",
+    "IntlRo":             "ro",
+    "Lang3":              "katakana",
+    "Lang4":              "hiragana",
+    "Convert":            "henkan",
+    "KanaMode":           "katakanahiragana",
+    "NonConvert":         "muhenkan",
+    "NumpadEnter":        "kp_enter",
+    "ControlRight":       "ctrl_r",
+    "NumpadDivide":       "kp_divide",
+    "PrintScreen":        "sysrq",
+    "AltRight":           "alt_r",
+    "Home":               "home",
+    "ArrowUp":            "up",
+    "PageUp":             "pgup",
+    "ArrowLeft":          "left",
+    "ArrowRight":         "right",
+    "End":                "end",
+    "ArrowDown":          "down",
+    "PageDown":           "pgdn",
+    "Insert":             "insert",
+    "Delete":             "delete",
+    "AudioVolumeMute":    "audiomute",
+    "AudioVolumeDown":    "volumedown",
+    "AudioVolumeUp":      "volumeup",
+    "Power":              "power",
+    "NumpadEqual":        "kp_equals",
+    "Pause":              "pause",
+    "NumpadComma":        "kp_comma",
+    "Lang1":              "lang1",
+    "Lang2":              "lang2",
+    "IntlYen":            "yen",
+    "MetaLeft":           "meta_l",
+    "MetaRight":          "meta_r",
+    "ContextMenu":        "compose",
+    "BrowserStop":        "stop",
+    "Again":              "again",
+    "Undo":               "undo",
+    "Copy":               "copy",
+    "Open":               "open",
+    "Paste":              "paste",
+    "Find":               "find",
+    "Cut":                "cut",
+    "Help":               "help",
+    "LaunchApp2":         "calculator",
+    "Sleep":              "sleep",
+    "WakeUp":             "wake",
+    "LaunchMail":         "mail",
+    "BrowserFavorites":   "ac_bookmarks",
+    "LaunchApp1":         "computer",
+    "BrowserBack":        "ac_back",
+    "BrowserForward":     "ac_forward",
+    "MediaTrackNext":     "audionext",
+    "MediaPlayPause":     "audioplay",
+    "MediaTrackPrevious": "audioprev",
+    "MediaStop":          "audiostop",
+    "BrowserHome":        "ac_home",
+    "BrowserRefresh":     "ac_refresh",
+    "F13":                "f13",
+    "F14":                "f14",
+    "F15":                "f15",
+    "F16":                "f16",
+    "F17":                "f17",
+    "F18":                "f18",
+    "F19":                "f19",
+    "F20":                "f20",
+    "F21":                "f21",
+    "F22":                "f22",
+    "F23":                "f23",
+    "F24":                "f24",
+    "MediaSelect":        "mediaselect",
+}
+
+// LookupDOMCode finds the key for a KeyboardEvent.code value.
+func LookupDOMCode(code string) (Key, bool) {
+    qcode, ok := domCodes[code]
+    if !ok {
+        return Key{}, false
+    }
+    return LookupQCode(qcode)
+}
@@ -0,0 +1,132 @@
+// Command gen turns keymaps.csv into the lookup tables of package keymap.
+//
+// Usage: go run ./internal/gen keymaps.csv tables.go
+package main
+
+import (
+    "bytes"
+    "encoding/csv"
+    "fmt"
+    "io"
+    "os"
+    "strconv"
+    "strings"
+)
+
+type key struct {
+    qcode   string
+    evdev   uint64
+    qnum    uint64
+    keysyms []string
+}
+
+func parse(r io.Reader) ([]key, error) {
+    cr := csv.NewReader(r)
+    cr.Comment = '#'
+    cr.FieldsPerRecord = 4
+
+    records, err := cr.ReadAll()
+    if err != nil {
+        return nil, err
+    }
+
+    if len(records) == 0 || strings.Join(records[0], ",") != "qcode,evdev,qnum,keysyms" {
+        return nil, fmt.Errorf("missing header")
+    }
+
+    var keys []key
+    qcodes := map[string]bool{}
+    evdevs := map[uint64]bool{}
+    qnums := map[uint64]bool{}
+    keysyms := map[string]string{}
+
+    for i, rec := range records[1:] {
+        line := i + 2
+
+        evdev, err := strconv.ParseUint(rec[1], 0, 16)
+        if err != nil {
+            return nil, fmt.Errorf("line %d: evdev: %w", line, err)
+        }
+
+        qnum, err := strconv.ParseUint(rec[2], 0, 32)
+        if err != nil {
+            return nil, fmt.Errorf("line %d: qnum: %w", line, err)
+        }
+
+        k := key{rec[0], evdev, qnum, strings.Fields(rec[3])}
+
+        if k.qcode == "" || qcodes[k.qcode] {
+            return nil, fmt.Errorf("line %d: empty or duplicate qcode %q", line, k.qcode)
+        }
+        if evdevs[k.evdev] {
+            return nil, fmt.Errorf("line %d: duplicate evdev code %d", line, k.evdev)
+        }
+        if qnums[k.qnum] {
+            return nil, fmt.Errorf("line %d: duplicate qnum 0x%02x", line, k.qnum)
+        }
+        for _, sym := range k.keysyms {
+            if other, ok := keysyms[sym]; ok {
+                return nil, fmt.Errorf("line %d: keysym %s is already on %s", line, sym, other)
+            }
+            keysyms[sym] = k.qcode
+        }
+
+        qcodes[k.qcode] = true
+        evdevs[k.evdev] = true
+        qnums[k.qnum] = true
+
+        keys = append(keys, k)
+    }
+
+    return keys, nil
+}
+
+func generate(keys []key) []byte {
+    var b bytes.Buffer
+
+    fmt.Fprintf(&b, "// Code generated by internal/gen from keymaps.csv; DO NOT EDIT.\n\n")
+    fmt.Fprintf(&b, "package keymap\n\n")
+
+    fmt.Fprintf(&b, "var keys = []Key{\n")
+    for _, k := range keys {
+        fmt.Fprintf(&b, "    {%q, %d, 0x%02x},\n", k.qcode, k.evdev, k.qnum)
+    }
+    fmt.Fprintf(&b, "}\n\n")
+
+    fmt.Fprintf(&b, "// keysyms maps X11 keysym names to indices in keys\n")
+    fmt.Fprintf(&b, "var keysyms = map[string]int{\n")
+    for i, k := range keys {
+        for _, sym := range k.keysyms {
+            fmt.Fprintf(&b, "    %q: %d,\n", sym, i)
+        }
+    }
+    fmt.Fprintf(&b, "}\n")
+
+    return b.Bytes()
+}
+
+func main() {
+    if len(os.Args) != 3 {
+        fmt.Fprintln(os.Stderr, "usage: gen keymaps.csv tables.go")
+        os.Exit(2)
+    }
+
+    in, err := os.Open(os.Args[1])
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
+    }
+    defer in.Close()
+
+    keys, err := parse(in)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[1], err)
+        os.Exit(1)
+    }
+
+    err = os.WriteFile(os.Args[2], generate(keys), 0644)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
+    }
+}
@@ -0,0 +1,77 @@
+// Package keymap translates host key identifiers into the key numbers QEMU
+// expects.
+//
+// The tables are generated from keymaps.csv, which lists every key QEMU
+// knows by its QKeyCode name, Linux evdev code and qnum (the XT scancode
+// set 1, with the 0xe0 prefix folded into the high bit), along with the X11
+// keysyms engraved on the key in the US layout.
+//
+// Keysyms identify a physical key, not a character: "exclam" and "1" both
+// map to the "1" key, the guest gets the shift state from the Shift key
+// events themselves.
+package keymap
+
+//go:generate go run ./internal/gen keymaps.csv tables.go
+
+type Key struct {
+    // QCode is the QEMU QKeyCode name, as used by QMP send-key
+    QCode string
+    // Evdev is the Linux input event code
+    Evdev uint16
+    // Qnum is what org.qemu.Display1.Keyboard.Press expects
+    Qnum uint32
+}
+
+var (
+    byQCode = index(func(k Key) string { return k.QCode })
+    byEvdev = index(func(k Key) uint16 { return k.Evdev })
+    byQnum  = index(func(k Key) uint32 { return k.Qnum })
+)
+
+func index[K comparable](id func(k Key) K) map[K]int {
+    m := make(map[K]int, len(keys))
+    for i, k := range keys {
+        m[id(k)] = i
+    }
+    return m
+}
+
+func lookup[K comparable](m map[K]int, k K) (Key, bool) {
+    i, ok := m[k]
+    if !ok {
+        return Key{}, false
+    }
+    return keys[i], true
+}
+
+// LookupKeysym finds the key for an X11 keysym name, as reported by
+// GStreamer navigation events.
+func LookupKeysym(name string) (Key, bool) {
+    return lookup(keysyms, name)
+}
+
+func LookupEvdev(code uint16) (Key, bool) {
+    return lookup(byEvdev, code)
+}
+
+// LookupX11 finds the key for an X11 hardware keycode. With the evdev
+// driver those are the Linux codes shifted by 8.
+func LookupX11(keycode uint32) (Key, bool) {
+    if keycode < 8 || keycode-8 > 0xffff {
+        return Key{}, false
+    }
+    return LookupEvdev(uint16(keycode - 8))
+}
+
+func LookupQCode(name string) (Key, bool) {
+    return lookup(byQCode, name)
+}
+
+func LookupQnum(qnum uint32) (Key, bool) {
+    return lookup(byQnum, qnum)
+}
+
+// Keys returns every known key.
+func Keys() []Key {
+    return append([]Key(nil), keys...)
+}
@@ -0,0 +1,171 @@
+package keymap
+
+import (
+    "bytes"
+    "encoding/csv"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "testing"
+)
+
+// csvKey is a line of keymaps.csv
+type csvKey struct {
+    key     Key
+    keysyms []string
+}
+
+func readCSV(t *testing.T) []csvKey {
+    f, err := os.Open("keymaps.csv")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+
+    r := csv.NewReader(f)
+    r.Comment = '#'
+    records, err := r.ReadAll()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var keys []csvKey
+    for _, rec := range records[1:] {
+        evdev, err := strconv.ParseUint(rec[1], 0, 16)
+        if err != nil {
+            t.Fatal(err)
+        }
+        qnum, err := strconv.ParseUint(rec[2], 0, 32)
+        if err != nil {
+            t.Fatal(err)
+        }
+        keys = append(keys, csvKey{Key{rec[0], uint16(evdev), uint32(qnum)}, strings.Fields(rec[3])})
+    }
+    return keys
+}
+
+func TestLookup(t *testing.T) {
+    keys := readCSV(t)
+    if len(keys) != len(Keys()) {
+        t.Fatalf("keymaps.csv has %d keys, the tables %d", len(keys), len(Keys()))
+    }
+
+    for _, k := range keys {
+        if got, ok := LookupQCode(k.key.QCode); !ok || got != k.key {
+            t.Errorf("LookupQCode(%q) = %v, %t, want %v", k.key.QCode, got, ok, k.key)
+        }
+        if got, ok := LookupEvdev(k.key.Evdev); !ok || got != k.key {
+            t.Errorf("LookupEvdev(%d) = %v, %t, want %v", k.key.Evdev, got, ok, k.key)
+        }
+        if got, ok := LookupX11(uint32(k.key.Evdev) + 8); !ok || got != k.key {
+            t.Errorf("LookupX11(%d) = %v, %t, want %v", k.key.Evdev+8, got, ok, k.key)
+        }
+        if got, ok := LookupQnum(k.key.Qnum); !ok || got != k.key {
+            t.Errorf("LookupQnum(%#x) = %v, %t, want %v", k.key.Qnum, got, ok, k.key)
+        }
+        for _, sym := range k.keysyms {
+            if got, ok := LookupKeysym(sym); !ok || got != k.key {
+                t.Errorf("LookupKeysym(%q) = %v, %t, want %v", sym, got, ok, k.key)
+            }
+        }
+    }
+
+    if _, ok := LookupKeysym("NoSuchKeysym"); ok {
+        t.Error("LookupKeysym found an unknown keysym")
+    }
+}
+
+// TestKnownKeys checks keys against values from the Linux headers and the
+// PC scancode set 1 rather than against keymaps.csv, which the tables are
+// made from.
+func TestKnownKeys(t *testing.T) {
+    for _, tt := range []struct {
+        sym  string
+        want Key
+    }{
+        {"a", Key{"a", 30, 0x1e}},
+        {"Return", Key{"ret", 28, 0x1c}},
+        {"Control_L", Key{"ctrl", 29, 0x1d}},
+        // Extended keys are e0 xx in set 1, qnum 0x80|xx
+        {"Control_R", Key{"ctrl_r", 97, 0x9d}},
+        {"KP_Enter", Key{"kp_enter", 96, 0x9c}},
+        {"Right", Key{"right", 106, 0xcd}},
+        {"Delete", Key{"delete", 111, 0xd3}},
+        {"Super_L", Key{"meta_l", 125, 0xdb}},
+    } {
+        if got, ok := LookupKeysym(tt.sym); !ok || got != tt.want {
+            t.Errorf("LookupKeysym(%q) = %v, %t, want %v", tt.sym, got, ok, tt.want)
+        }
+    }
+}
+
+func TestLookupKeysymValue(t *testing.T) {
+    for sym, name := range keysymNames {
+        want, ok := LookupKeysym(name)
+        if !ok {
+            t.Errorf("keysym %#x is %q, which is on no key", sym, name)
+            continue
+        }
+        if got, ok := LookupKeysymValue(sym); !ok || got != want {
+            t.Errorf("LookupKeysymValue(%#x) = %v, %t, want %v", sym, got, ok, want)
+        }
+    }
+
+    for _, r := range "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ" {
+        want, ok := LookupKeysym(string(r))
+        if !ok {
+            t.Errorf("keysym %q is on no key", r)
+            continue
+        }
+        if got, ok := LookupKeysymValue(uint32(r)); !ok || got != want {
+            t.Errorf("LookupKeysymValue(%q) = %v, %t, want %v", r, got, ok, want)
+        }
+    }
+
+    if _, ok := LookupKeysymValue(0xfffffff); ok {
+        t.Error("LookupKeysymValue found an unknown keysym")
+    }
+}
+
+func TestLookupDOMCode(t *testing.T) {
+    for code, qcode := range domCodes {
+        want, ok := LookupQCode(qcode)
+        if !ok {
+            t.Errorf("DOM code %q is %q, which is no key", code, qcode)
+            continue
+        }
+        if got, ok := LookupDOMCode(code); !ok || got != want {
+            t.Errorf("LookupDOMCode(%q) = %v, %t, want %v", code, got, ok, want)
+        }
+    }
+
+    if _, ok := LookupDOMCode("NoSuchCode"); ok {
+        t.Error("LookupDOMCode found an unknown code")
+    }
+}
+
+func TestTablesUpToDate(t *testing.T) {
+    if testing.Short() {
+        t.Skip("runs the generator")
+    }
+
+    out := filepath.Join(t.TempDir(), "tables.go")
+    cmd := exec.Command("go", "run", "./internal/gen", "keymaps.csv", out)
+    if b, err := cmd.CombinedOutput(); err != nil {
+        t.Fatalf("go run ./internal/gen: %v\n%s", err, b)
+    }
+
+    want, err := os.ReadFile(out)
+    if err != nil {
+        t.Fatal(err)
+    }
+    got, err := os.ReadFile("tables.go")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(got, want) {
+        t.Error("tables.go is out of date, run go generate")
+    }
+}
@@ -0,0 +1,185 @@
+package keymap
+
+// keysymNames names the keysym values of the keysyms in keymaps.csv which
+// are not a plain letter or digit, from X11/keysymdef.h and XF86keysym.h
+var keysymNames = map[uint32]string{
+    0x0020: "space",
+    0x0021: "exclam",
+    0x0022: "quotedbl",
+    0x0023: "numbersign",
+    0x0024: "dollar",
+    0x0025: "percent",
+    0x0026: "ampersand",
+    0x0027: "apostrophe",
+    0x0028: "parenleft",
+    0x0029: "parenright",
+    0x002a: "asterisk",
+    0x002b: "plus",
+    0x002c: "comma",
+    0x002d: "minus",
+    0x002e: "period",
+    0x002f: "slash",
+    0x003a: "colon",
+    0x003b: "semicolon",
+    0x003c: "less",
+    0x003d: "equal",
+    0x003e: "greater",
+    0x003f: "question",
+    0x0040: "at",
+    0x005b: "bracketleft",
+    0x005c: "backslash",
+    0x005d: "bracketright",
+    0x005e: "asciicircum",
+    0x005f: "underscore",
+    0x0060: "grave",
+    0x007b: "braceleft",
+    0x007c: "bar",
+    0x007d: "braceright",
+    0x007e: "asciitilde",
+    0x00a5: "yen",
+
+    0xfe03: "ISO_Level3_Shift",
+    0xfe20: "ISO_Left_Tab",
+
+    0xff08: "BackSpace",
+    0xff09: "Tab",
+    0xff0d: "Return",
+    0xff13: "Pause",
+    0xff14: "Scroll_Lock",
+    0xff15: "Sys_Req",
+    0xff1b: "Escape",
+    0xff20: "Multi_key",
+    0xff22: "Muhenkan",
+    0xff23: "Henkan_Mode",
+    0xff25: "Hiragana",
+    0xff26: "Katakana",
+    0xff27: "Hiragana_Katakana",
+    0xff2a: "Zenkaku_Hankaku",
+    0xff31: "Hangul",
+    0xff34: "Hangul_Hanja",
+    0xff50: "Home",
+    0xff51: "Left",
+    0xff52: "Up",
+    0xff53: "Right",
+    0xff54: "Down",
+    0xff55: "Prior",
+    0xff56: "Next",
+    0xff57: "End",
+    0xff61: "Print",
+    0xff63: "Insert",
+    0xff65: "Undo",
+    0xff66: "Redo",
+    0xff67: "Menu",
+    0xff68: "Find",
+    0xff69: "Cancel",
+    0xff6a: "Help",
+    0xff6b: "Break",
+    0xff7f: "Num_Lock",
+    0xff8d: "KP_Enter",
+    0xff95: "KP_Home",
+    0xff96: "KP_Left",
+    0xff97: "KP_Up",
+    0xff98: "KP_Right",
+    0xff99: "KP_Down",
+    0xff9a: "KP_Prior",
+    0xff9b: "KP_Next",
+    0xff9c: "KP_End",
+    0xff9d: "KP_Begin",
+    0xff9e: "KP_Insert",
+    0xff9f: "KP_Delete",
+    0xffaa: "KP_Multiply",
+    0xffab: "KP_Add",
+    0xffac: "KP_Separator",
+    0xffad: "KP_Subtract",
+    0xffae: "KP_Decimal",
+    0xffaf: "KP_Divide",
+    0xffb0: "KP_0",
+    0xffb1: "KP_1",
+    0xffb2: "KP_2",
+    0xffb3: "KP_3",
+    0xffb4: "KP_4",
+    0xffb5: "KP_5",
+    0xffb6: "KP_6",
+    0xffb7: "KP_7",
+    0xffb8: "KP_8",
+    0xffb9: "KP_9",
+    0xffbd: "KP_Equal",
+    0xffbe: "F1",
+    0xffbf: "F2",
+    0xffc0: "F3",
+    0xffc1: "F4",
+    0xffc2: "F5",
+    0xffc3: "F6",
+    0xffc4: "F7",
+    0xffc5: "F8",
+    0xffc6: "F9",
+    0xffc7: "F10",
+    0xffc8: "F11",
+    0xffc9: "F12",
+    0xffca: "F13",
+    0xffcb: "F14",
+    0xffcc: "F15",
+    0xffcd: "F16",
+    0xffce: "F17",
+    0xffcf: "F18",
+    0xffd0: "F19",
+    0xffd1: "F20",
+    0xffd2: "F21",
+    0xffd3: "F22",
+    0xffd4: "F23",
+    0xffd5: "F24",
+    0xffe1: "Shift_L",
+    0xffe2: "Shift_R",
+    0xffe3: "Control_L",
+    0xffe4: "Control_R",
+    0xffe5: "Caps_Lock",
+    0xffe7: "Meta_L",
+    0xffe8: "Meta_R",
+    0xffe9: "Alt_L",
+    0xffea: "Alt_R",
+    0xffeb: "Super_L",
+    0xffec: "Super_R",
+    0xffff: "Delete",
+
+    0x1008ff11: "XF86AudioLowerVolume",
+    0x1008ff12: "XF86AudioMute",
+    0x1008ff13: "XF86AudioRaiseVolume",
+    0x1008ff14: "XF86AudioPlay",
+    0x1008ff15: "XF86AudioStop",
+    0x1008ff16: "XF86AudioPrev",
+    0x1008ff17: "XF86AudioNext",
+    0x1008ff18: "XF86HomePage",
+    0x1008ff19: "XF86Mail",
+    0x1008ff1b: "XF86Search",
+    0x1008ff1d: "XF86Calculator",
+    0x1008ff26: "XF86Back",
+    0x1008ff27: "XF86Forward",
+    0x1008ff28: "XF86Stop",
+    0x1008ff29: "XF86Refresh",
+    0x1008ff2a: "XF86PowerOff",
+    0x1008ff2b: "XF86WakeUp",
+    0x1008ff2f: "XF86Sleep",
+    0x1008ff30: "XF86Favorites",
+    0x1008ff31: "XF86AudioPause",
+    0x1008ff32: "XF86AudioMedia",
+    0x1008ff33: "XF86MyComputer",
+    0x1008ff57: "XF86Copy",
+    0x1008ff58: "XF86Cut",
+    0x1008ff6b: "XF86Open",
+    0x1008ff6d: "XF86Paste",
+    0x1008ff73: "XF86Reload",
+}
+
+// LookupKeysymValue finds the key for an X11 keysym value, as sent by VNC
+// clients. Like with LookupKeysym it is the key of the US layout.
+func LookupKeysymValue(sym uint32) (Key, bool) {
+    if sym >= '0' && sym <= '9' || sym >= 'a' && sym <= 'z' || sym >= 'A' && sym <= 'Z' {
+        return LookupKeysym(string(rune(sym)))
+    }
+
+    name, ok := keysymNames[sym]
+    if !ok {
+        return Key{}, false
+    }
+    return LookupKeysym(name)
+}
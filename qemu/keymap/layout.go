@@ -0,0 +1,200 @@
+package keymap
+
+import (
+    "fmt"
+    "slices"
+    "strings"
+    "unicode/utf8"
+)
+
+type Modifier uint8
+
+const (
+    Shift Modifier = 1 << iota
+    AltGr
+)
+
+// Stroke is a key pressed while holding Mods.
+type Stroke struct {
+    Key  Key
+    Mods Modifier
+}
+
+// Layout describes which strokes produce which characters on a guest
+// keyboard layout.
+type Layout struct {
+    name  string
+    chars map[rune]Stroke
+}
+
+// Dead keys are stored under the combining character they apply
+const (
+    deadGrave      = '̀'
+    deadAcute      = '́'
+    deadCircumflex = '̂'
+    deadTilde      = '̃'
+    deadDiaeresis  = '̈'
+    deadAbovering  = '̊'
+    deadCedilla    = '̧'
+)
+
+var deadKeys = map[string]rune{
+    "dead_grave":      deadGrave,
+    "dead_acute":      deadAcute,
+    "dead_circumflex": deadCircumflex,
+    "dead_tilde":      deadTilde,
+    "dead_diaeresis":  deadDiaeresis,
+    "dead_abovering":  deadAbovering,
+    "dead_cedilla":    deadCedilla,
+}
+
+// Characters a dead key produces when followed by space
+var deadSpacing = map[rune]rune{
+    deadGrave:      '`',
+    deadAcute:      '´',
+    deadCircumflex: '^',
+    deadTilde:      '~',
+    deadDiaeresis:  '¨',
+    deadAbovering:  '°',
+    deadCedilla:    '¸',
+}
+
+var levels = []Modifier{0, Shift, AltGr, Shift | AltGr}
+
+// newLayout builds a layout from a map of qcodes to their levels: plain,
+// Shift, AltGr and Shift+AltGr, separated by spaces. A level is either a
+// single character, "space", "NoSymbol" or a dead key name.
+func newLayout(name string, keys map[string]string) (*Layout, error) {
+    l := &Layout{name, make(map[rune]Stroke)}
+
+    l.chars[' '], _ = strokeFor("spc", 0)
+    l.chars['\n'], _ = strokeFor("ret", 0)
+    l.chars['\t'], _ = strokeFor("tab", 0)
+
+    // Go through the keys in order, so that the first key wins when a
+    // character is present on several of them
+    qcodes := make([]string, 0, len(keys))
+    for qcode := range keys {
+        qcodes = append(qcodes, qcode)
+    }
+    slices.SortFunc(qcodes, func(a, b string) int {
+        ka, _ := LookupQCode(a)
+        kb, _ := LookupQCode(b)
+        return int(ka.Evdev) - int(kb.Evdev)
+    })
+
+    for _, qcode := range qcodes {
+        syms := strings.Fields(keys[qcode])
+        if len(syms) > len(levels) {
+            return nil, fmt.Errorf("%s: %s has %d levels", name, qcode, len(syms))
+        }
+
+        for i, sym := range syms {
+            var r rune
+
+            switch {
+            case sym == "NoSymbol":
+                continue
+            case sym == "space":
+                r = ' '
+            case deadKeys[sym] != 0:
+                r = deadKeys[sym]
+            case utf8.RuneCountInString(sym) == 1:
+                r, _ = utf8.DecodeRuneInString(sym)
+            default:
+                return nil, fmt.Errorf("%s: %s has unknown symbol %q", name, qcode, sym)
+            }
+
+            if _, ok := l.chars[r]; ok {
+                continue
+            }
+
+            stroke, ok := strokeFor(qcode, levels[i])
+            if !ok {
+                return nil, fmt.Errorf("%s: unknown key %s", name, qcode)
+            }
+            l.chars[r] = stroke
+        }
+    }
+
+    return l, nil
+}
+
+func mustLayout(name string, keys map[string]string) *Layout {
+    l, err := newLayout(name, keys)
+    if err != nil {
+        panic(err)
+    }
+    return l
+}
+
+func strokeFor(qcode string, mods Modifier) (Stroke, bool) {
+    key, ok := LookupQCode(qcode)
+    return Stroke{key, mods}, ok
+}
+
+func (l *Layout) Name() string {
+    return l.name
+}
+
+// Strokes returns the strokes typing r, which takes two of them when it
+// has to be composed with a dead key.
+func (l *Layout) Strokes(r rune) ([]Stroke, bool) {
+    if stroke, ok := l.chars[r]; ok && !isDead(r) {
+        return []Stroke{stroke}, true
+    }
+
+    // A dead key followed by space gives its spacing character
+    for dead, spacing := range deadSpacing {
+        if spacing == r {
+            if stroke, ok := l.chars[dead]; ok {
+                return []Stroke{stroke, l.chars[' ']}, true
+            }
+        }
+    }
+
+    if d, ok := decompositions[r]; ok {
+        dead, ok := l.chars[d.mark]
+        if !ok {
+            return nil, false
+        }
+
+        base, ok := l.Strokes(d.base)
+        if !ok || len(base) != 1 {
+            return nil, false
+        }
+
+        return []Stroke{dead, base[0]}, true
+    }
+
+    return nil, false
+}
+
+func isDead(r rune) bool {
+    _, ok := deadSpacing[r]
+    return ok
+}
+
+var layouts = map[string]*Layout{}
+
+func registerLayout(l *Layout) *Layout {
+    layouts[l.name] = l
+    return l
+}
+
+// LayoutByName returns one of the built-in layouts, named like their XKB
+// counterparts.
+func LayoutByName(name string) (*Layout, bool) {
+    l, ok := layouts[name]
+    return l, ok
+}
+
+// Layouts returns the names of the built-in layouts.
+func Layouts() []string {
+    names := make([]string, 0, len(layouts))
+    for name := range layouts {
+        names = append(names, name)
+    }
+    slices.Sort(names)
+    return names
+}
@@ -0,0 +1,206 @@
+package keymap
+
+// The built-in layouts follow the default variants of their XKB
+// counterparts, only the first four levels are described.
+
+var US = registerLayout(mustLayout("us", map[string]string{
+    "grave_accent":  "` ~",
+    "1":             "1 !",
+    "2":             "2 @",
+    "3":             "3 #",
+    "4":             "4 $",
+    "5":             "5 %",
+    "6":             "6 ^",
+    "7":             "7 &",
+    "8":             "8 *",
+    "9":             "9 (",
+    "0":             "0 )",
+    "minus":         "- _",
+    "equal":         "= +",
+    "q":             "q Q",
+    "w":             "w W",
+    "e":             "e E",
+    "r":             "r R",
+    "t":             "t T",
+    "y":             "y Y",
+    "u":             "u U",
+    "i":             "i I",
+    "o":             "o O",
+    "p":             "p P",
+    "bracket_left":  "[ {",
+    "bracket_right": "] }",
+    "a":             "a A",
+    "s":             "s S",
+    "d":             "d D",
+    "f":             "f F",
+    "g":             "g G",
+    "h":             "h H",
+    "j":             "j J",
+    "k":             "k K",
+    "l":             "l L",
+    "semicolon":     "; :",
+    "apostrophe":    "' \"",
+    "backslash":     "\\ |",
+    "z":             "z Z",
+    "x":             "x X",
+    "c":             "c C",
+    "v":             "v V",
+    "b":             "b B",
+    "n":             "n N",
+    "m":             "m M",
+    "comma":         ", <",
+    "dot":           ". >",
+    "slash":         "/ ?",
+}))
+
+var DE = registerLayout(mustLayout("de", map[string]string{
+    "grave_accent":  "dead_circumflex ° ′ ″",
+    "1":             "1 ! ¹ ¡",
+    "2":             "2 \" ² ⅛",
+    "3":             "3 § ³ £",
+    "4":             "4 $ ¼ ¤",
+    "5":             "5 % ½ ⅜",
+    "6":             "6 & ¬ ⅝",
+    "7":             "7 / { ⅞",
+    "8":             "8 ( [ ™",
+    "9":             "9 ) ] ±",
+    "0":             "0 = } NoSymbol",
+    "minus":         "ß ? \\ ¿",
+    "equal":         "dead_acute dead_grave dead_cedilla NoSymbol",
+    "q":             "q Q @ Ω",
+    "w":             "w W ł Ł",
+    "e":             "e E € NoSymbol",
+    "r":             "r R ¶ ®",
+    "t":             "t T ŧ Ŧ",
+    "y":             "z Z ← ¥",
+    "u":             "u U ↓ ↑",
+    "i":             "i I → ı",
+    "o":             "o O ø Ø",
+    "p":             "p P þ Þ",
+    "bracket_left":  "ü Ü dead_diaeresis dead_abovering",
+    "bracket_right": "+ * dead_tilde ¯",
+    "a":             "a A æ Æ",
+    "s":             "s S ſ ẞ",
+    "d":             "d D ð Ð",
+    "f":             "f F đ ª",
+    "g":             "g G ŋ Ŋ",
+    "h":             "h H ħ Ħ",
+    "j":             "j J NoSymbol NoSymbol",
+    "k":             "k K ĸ &",
+    "l":             "l L ł Ł",
+    "semicolon":     "ö Ö NoSymbol NoSymbol",
+    "apostrophe":    "ä Ä NoSymbol NoSymbol",
+    "backslash":     "# ' ’ NoSymbol",
+    "less":          "< > | NoSymbol",
+    "z":             "y Y » ›",
+    "x":             "x X « ‹",
+    "c":             "c C ¢ ©",
+    "v":             "v V „ ‚",
+    "b":             "b B “ ‘",
+    "n":             "n N ” ’",
+    "m":             "m M µ º",
+    "comma":         ", ; · ×",
+    "dot":           ". : … ÷",
+    "slash":         "- _ – —",
+}))
+
+var FR = registerLayout(mustLayout("fr", map[string]string{
+    "grave_accent":  "² NoSymbol",
+    "1":             "& 1 NoSymbol NoSymbol",
+    "2":             "é 2 ~ NoSymbol",
+    "3":             "\" 3 # NoSymbol",
+    "4":             "' 4 { NoSymbol",
+    "5":             "( 5 [ NoSymbol",
+    "6":             "- 6 | NoSymbol",
+    "7":             "è 7 ` NoSymbol",
+    "8":             "_ 8 \\ NoSymbol",
+    "9":             "ç 9 ^ NoSymbol",
+    "0":             "à 0 @ NoSymbol",
+    "minus":         ") ° ] NoSymbol",
+    "equal":         "= + } NoSymbol",
+    "q":             "a A",
+    "w":             "z Z",
+    "e":             "e E €",
+    "r":             "r R",
+    "t":             "t T",
+    "y":             "y Y",
+    "u":             "u U",
+    "i":             "i I",
+    "o":             "o O",
+    "p":             "p P",
+    "bracket_left":  "dead_circumflex dead_diaeresis",
+    "bracket_right": "$ £ ¤",
+    "a":             "q Q",
+    "s":             "s S",
+    "d":             "d D",
+    "f":             "f F",
+    "g":             "g G",
+    "h":             "h H",
+    "j":             "j J",
+    "k":             "k K",
+    "l":             "l L",
+    "semicolon":     "m M",
+    "apostrophe":    "ù %",
+    "backslash":     "* µ",
+    "less":          "< >",
+    "z":             "w W",
+    "x":             "x X",
+    "c":             "c C",
+    "v":             "v V",
+    "b":             "b B",
+    "n":             "n N",
+    "m":             ", ?",
+    "comma":         "; .",
+    "dot":           ": /",
+    "slash":         "! §",
+}))
+
+var RU = registerLayout(mustLayout("ru", map[string]string{
+    "grave_accent":  "ё Ё",
+    "1":             "1 !",
+    "2":             "2 \"",
+    "3":             "3 №",
+    "4":             "4 ;",
+    "5":             "5 %",
+    "6":             "6 :",
+    "7":             "7 ?",
+    "8":             "8 *",
+    "9":             "9 (",
+    "0":             "0 )",
+    "minus":         "- _",
+    "equal":         "= +",
+    "q":             "й Й",
+    "w":             "ц Ц",
+    "e":             "у У",
+    "r":             "к К",
+    "t":             "е Е",
+    "y":             "н Н",
+    "u":             "г Г",
+    "i":             "ш Ш",
+    "o":             "щ Щ",
+    "p":             "з З",
+    "bracket_left":  "х Х",
+    "bracket_right": "ъ Ъ",
+    "a":             "ф Ф",
+    "s":             "ы Ы",
+    "d":             "в В",
+    "f":             "а А",
+    "g":             "п П",
+    "h":             "р Р",
+    "j":             "о О",
+    "k":             "л Л",
+    "l":             "д Д",
+    "semicolon":     "ж Ж",
+    "apostrophe":    "э Э",
+    "backslash":     "\\ /",
+    "z":             "я Я",
+    "x":             "ч Ч",
+    "c":             "с С",
+    "v":             "м М",
+    "b":             "и И",
+    "n":             "т Т",
+    "m":             "ь Ь",
+    "comma":         "б Б",
+    "dot":           "ю Ю",
+    "slash":         ". ,",
+}))
@@ -0,0 +1,397 @@
+// Code generated by internal/gen from keymaps.csv; DO NOT EDIT.
+
+package keymap
+
+var keys = []Key{
+    {"esc", 1, 0x01},
+    {"1", 2, 0x02},
+    {"2", 3, 0x03},
+    {"3", 4, 0x04},
+    {"4", 5, 0x05},
+    {"5", 6, 0x06},
+    {"6", 7, 0x07},
+    {"7", 8, 0x08},
+    {"8", 9, 0x09},
+    {"9", 10, 0x0a},
+    {"0", 11, 0x0b},
+    {"minus", 12, 0x0c},
+    {"equal", 13, 0x0d},
+    {"backspace", 14, 0x0e},
+    {"tab", 15, 0x0f},
+    {"q", 16, 0x10},
+    {"w", 17, 0x11},
+    {"e", 18, 0x12},
+    {"r", 19, 0x13},
+    {"t", 20, 0x14},
+    {"y", 21, 0x15},
+    {"u", 22, 0x16},
+    {"i", 23, 0x17},
+    {"o", 24, 0x18},
+    {"p", 25, 0x19},
+    {"bracket_left", 26, 0x1a},
+    {"bracket_right", 27, 0x1b},
+    {"ret", 28, 0x1c},
+    {"ctrl", 29, 0x1d},
+    {"a", 30, 0x1e},
+    {"s", 31, 0x1f},
+    {"d", 32, 0x20},
+    {"f", 33, 0x21},
+    {"g", 34, 0x22},
+    {"h", 35, 0x23},
+    {"j", 36, 0x24},
+    {"k", 37, 0x25},
+    {"l", 38, 0x26},
+    {"semicolon", 39, 0x27},
+    {"apostrophe", 40, 0x28},
+    {"grave_accent", 41, 0x29},
+    {"shift", 42, 0x2a},
+    {"backslash", 43, 0x2b},
+    {"z", 44, 0x2c},
+    {"x", 45, 0x2d},
+    {"c", 46, 0x2e},
+    {"v", 47, 0x2f},
+    {"b", 48, 0x30},
+    {"n", 49, 0x31},
+    {"m", 50, 0x32},
+    {"comma", 51, 0x33},
+    {"dot", 52, 0x34},
+    {"slash", 53, 0x35},
+    {"shift_r", 54, 0x36},
+    {"kp_multiply", 55, 0x37},
+    {"alt", 56, 0x38},
+    {"spc", 57, 0x39},
+    {"caps_lock", 58, 0x3a},
+    {"f1", 59, 0x3b},
+    {"f2", 60, 0x3c},
+    {"f3", 61, 0x3d},
+    {"f4", 62, 0x3e},
+    {"f5", 63, 0x3f},
+    {"f6", 64, 0x40},
+    {"f7", 65, 0x41},
+    {"f8", 66, 0x42},
+    {"f9", 67, 0x43},
+    {"f10", 68, 0x44},
+    {"num_lock", 69, 0x45},
+    {"scroll_lock", 70, 0x46},
+    {"kp_7", 71, 0x47},
+    {"kp_8", 72, 0x48},
+    {"kp_9", 73, 0x49},
+    {"kp_subtract", 74, 0x4a},
+    {"kp_4", 75, 0x4b},
+    {"kp_5", 76, 0x4c},
+    {"kp_6", 77, 0x4d},
+    {"kp_add", 78, 0x4e},
+    {"kp_1", 79, 0x4f},
+    {"kp_2", 80, 0x50},
+    {"kp_3", 81, 0x51},
+    {"kp_0", 82, 0x52},
+    {"kp_decimal", 83, 0x53},
+    {"zenkakuhankaku", 85, 0x76},
+    {"less", 86, 0x56},
+    {"f11", 87, 0x57},
+    {"f12", 88, 0x58},
+    {"ro", 89, 0x73},
+    {"katakana", 90, 0x78},
+    {"hiragana", 91, 0x77},
+    {"henkan", 92, 0x79},
+    {"katakanahiragana", 93, 0x70},
+    {"muhenkan", 94, 0x7b},
+    {"kp_enter", 96, 0x9c},
+    {"ctrl_r", 97, 0x9d},
+    {"kp_divide", 98, 0xb5},
+    {"sysrq", 99, 0x54},
+    {"alt_r", 100, 0xb8},
+    {"home", 102, 0xc7},
+    {"up", 103, 0xc8},
+    {"pgup", 104, 0xc9},
+    {"left", 105, 0xcb},
+    {"right", 106, 0xcd},
+    {"end", 107, 0xcf},
+    {"down", 108, 0xd0},
+    {"pgdn", 109, 0xd1},
+    {"insert", 110, 0xd2},
+    {"delete", 111, 0xd3},
+    {"audiomute", 113, 0xa0},
+    {"volumedown", 114, 0xae},
+    {"volumeup", 115, 0xb0},
+    {"power", 116, 0xde},
+    {"kp_equals", 117, 0x59},
+    {"pause", 119, 0xc6},
+    {"kp_comma", 121, 0x7e},
+    {"lang1", 122, 0x72},
+    {"lang2", 123, 0x71},
+    {"yen", 124, 0x7d},
+    {"meta_l", 125, 0xdb},
+    {"meta_r", 126, 0xdc},
+    {"compose", 127, 0xdd},
+    {"stop", 128, 0xe8},
+    {"again", 129, 0x85},
+    {"undo", 131, 0x87},
+    {"copy", 133, 0xf8},
+    {"open", 134, 0x64},
+    {"paste", 135, 0x65},
+    {"find", 136, 0xc1},
+    {"cut", 137, 0xbc},
+    {"help", 138, 0xf5},
+    {"calculator", 140, 0xa1},
+    {"sleep", 142, 0xdf},
+    {"wake", 143, 0xe3},
+    {"mail", 155, 0xec},
+    {"ac_bookmarks", 156, 0xe6},
+    {"computer", 157, 0xeb},
+    {"ac_back", 158, 0xea},
+    {"ac_forward", 159, 0xe9},
+    {"audionext", 163, 0x99},
+    {"audioplay", 164, 0xa2},
+    {"audioprev", 165, 0x90},
+    {"audiostop", 166, 0xa4},
+    {"ac_home", 172, 0xb2},
+    {"ac_refresh", 173, 0xe7},
+    {"f13", 183, 0x5d},
+    {"f14", 184, 0x5e},
+    {"f15", 185, 0x5f},
+    {"f16", 186, 0x55},
+    {"f17", 187, 0x83},
+    {"f18", 188, 0xf7},
+    {"f19", 189, 0x84},
+    {"f20", 190, 0x5a},
+    {"f21", 191, 0x74},
+    {"f22", 192, 0xf9},
+    {"f23", 193, 0x6d},
+    {"f24", 194, 0x6f},
+    {"print", 210, 0xb7},
+    {"mediaselect", 226, 0xed},
+}
+
+// keysyms maps X11 keysym names to indices in keys
+var keysyms = map[string]int{
+    "Escape": 0,
+    "1": 1,
+    "exclam": 1,
+    "2": 2,
+    "at": 2,
+    "3": 3,
+    "numbersign": 3,
+    "4": 4,
+    "dollar": 4,
+    "5": 5,
+    "percent": 5,
+    "6": 6,
+    "asciicircum": 6,
+    "7": 7,
+    "ampersand": 7,
+    "8": 8,
+    "asterisk": 8,
+    "9": 9,
+    "parenleft": 9,
+    "0": 10,
+    "parenright": 10,
+    "minus": 11,
+    "underscore": 11,
+    "equal": 12,
+    "plus": 12,
+    "BackSpace": 13,
+    "Tab": 14,
+    "ISO_Left_Tab": 14,
+    "q": 15,
+    "Q": 15,
+    "w": 16,
+    "W": 16,
+    "e": 17,
+    "E": 17,
+    "r": 18,
+    "R": 18,
+    "t": 19,
+    "T": 19,
+    "y": 20,
+    "Y": 20,
+    "u": 21,
+    "U": 21,
+    "i": 22,
+    "I": 22,
+    "o": 23,
+    "O": 23,
+    "p": 24,
+    "P": 24,
+    "bracketleft": 25,
+    "braceleft": 25,
+    "bracketright": 26,
+    "braceright": 26,
+    "Return": 27,
+    "Control_L": 28,
+    "a": 29,
+    "A": 29,
+    "s": 30,
+    "S": 30,
+    "d": 31,
+    "D": 31,
+    "f": 32,
+    "F": 32,
+    "g": 33,
+    "G": 33,
+    "h": 34,
+    "H": 34,
+    "j": 35,
+    "J": 35,
+    "k": 36,
+    "K": 36,
+    "l": 37,
+    "L": 37,
+    "semicolon": 38,
+    "colon": 38,
+    "apostrophe": 39,
+    "quotedbl": 39,
+    "grave": 40,
+    "asciitilde": 40,
+    "Shift_L": 41,
+    "backslash": 42,
+    "bar": 42,
+    "z": 43,
+    "Z": 43,
+    "x": 44,
+    "X": 44,
+    "c": 45,
+    "C": 45,
+    "v": 46,
+    "V": 46,
+    "b": 47,
+    "B": 47,
+    "n": 48,
+    "N": 48,
+    "m": 49,
+    "M": 49,
+    "comma": 50,
+    "less": 50,
+    "period": 51,
+    "greater": 51,
+    "slash": 52,
+    "question": 52,
+    "Shift_R": 53,
+    "KP_Multiply": 54,
+    "Alt_L": 55,
+    "space": 56,
+    "Caps_Lock": 57,
+    "F1": 58,
+    "F2": 59,
+    "F3": 60,
+    "F4": 61,
+    "F5": 62,
+    "F6": 63,
+    "F7": 64,
+    "F8": 65,
+    "F9": 66,
+    "F10": 67,
+    "Num_Lock": 68,
+    "Scroll_Lock": 69,
+    "KP_7": 70,
+    "KP_Home": 70,
+    "KP_8": 71,
+    "KP_Up": 71,
+    "KP_9": 72,
+    "KP_Prior": 72,
+    "KP_Page_Up": 72,
+    "KP_Subtract": 73,
+    "KP_4": 74,
+    "KP_Left": 74,
+    "KP_5": 75,
+    "KP_Begin": 75,
+    "KP_6": 76,
+    "KP_Right": 76,
+    "KP_Add": 77,
+    "KP_1": 78,
+    "KP_End": 78,
+    "KP_2": 79,
+    "KP_Down": 79,
+    "KP_3": 80,
+    "KP_Next": 80,
+    "KP_Page_Down": 80,
+    "KP_0": 81,
+    "KP_Insert": 81,
+    "KP_Decimal": 82,
+    "KP_Delete": 82,
+    "Zenkaku_Hankaku": 83,
+    "F11": 85,
+    "F12": 86,
+    "Katakana": 88,
+    "Hiragana": 89,
+    "Henkan_Mode": 90,
+    "Henkan": 90,
+    "Hiragana_Katakana": 91,
+    "Muhenkan": 92,
+    "KP_Enter": 93,
+    "Control_R": 94,
+    "KP_Divide": 95,
+    "Print": 96,
+    "Sys_Req": 96,
+    "Alt_R": 97,
+    "ISO_Level3_Shift": 97,
+    "Home": 98,
+    "Up": 99,
+    "Prior": 100,
+    "Page_Up": 100,
+    "Left": 101,
+    "Right": 102,
+    "End": 103,
+    "Down": 104,
+    "Next": 105,
+    "Page_Down": 105,
+    "Insert": 106,
+    "Delete": 107,
+    "XF86AudioMute": 108,
+    "XF86AudioLowerVolume": 109,
+    "XF86AudioRaiseVolume": 110,
+    "XF86PowerOff": 111,
+    "KP_Equal": 112,
+    "Pause": 113,
+    "Break": 113,
+    "KP_Separator": 114,
+    "Hangul": 115,
+    "Hangul_Hanja": 116,
+    "yen": 117,
+    "Super_L": 118,
+    "Meta_L": 118,
+    "Super_R": 119,
+    "Meta_R": 119,
+    "Menu": 120,
+    "Multi_key": 120,
+    "Cancel": 121,
+    "XF86Stop": 121,
+    "Redo": 122,
+    "Undo": 123,
+    "XF86Copy": 124,
+    "XF86Open": 125,
+    "XF86Paste": 126,
+    "Find": 127,
+    "XF86Search": 127,
+    "XF86Cut": 128,
+    "Help": 129,
+    "XF86Calculator": 130,
+    "XF86Sleep": 131,
+    "XF86WakeUp": 132,
+    "XF86Mail": 133,
+    "XF86Favorites": 134,
+    "XF86MyComputer": 135,
+    "XF86Back": 136,
+    "XF86Forward": 137,
+    "XF86AudioNext": 138,
+    "XF86AudioPlay": 139,
+    "XF86AudioPause": 139,
+    "XF86AudioPrev": 140,
+    "XF86AudioStop": 141,
+    "XF86HomePage": 142,
+    "XF86Reload": 143,
+    "XF86Refresh": 143,
+    "F13": 144,
+    "F14": 145,
+    "F15": 146,
+    "F16": 147,
+    "F17": 148,
+    "F18": 149,
+    "F19": 150,
+    "F20": 151,
+    "F21": 152,
+    "F22": 153,
+    "F23": 154,
+    "F24": 155,
+    "XF86AudioMedia": 157,
+}
@@ -1,36 +1,149 @@
 package qemu
 
 import (
+    "context"
+    "math"
+    "sync/atomic"
+
     "github.com/godbus/dbus/v5"
 )
 
 type Mouse struct {
     conn  *dbus.Conn
     mouse dbus.BusObject
-    isAbs bool
+    isAbs atomic.Bool
+
+    // Fractions of a wheel step not sent yet
+    scrollX float64
+    scrollY float64
 }
 
+// MouseButton follows the QEMU InputButton enum.
+type MouseButton uint32
+
+const (
+    ButtonLeft       MouseButton = 0
+    ButtonMiddle     MouseButton = 1
+    ButtonRight      MouseButton = 2
+    ButtonWheelUp    MouseButton = 3
+    ButtonWheelDown  MouseButton = 4
+    ButtonSide       MouseButton = 5
+    ButtonExtra      MouseButton = 6
+    ButtonWheelLeft  MouseButton = 7
+    ButtonWheelRight MouseButton = 8
+)
+
 func newMouse(conn *dbus.Conn, mouse dbus.BusObject) (*Mouse, error) {
     isAbs, err := getProp(mouse, mouseIsAbs)
     if err != nil {
         return nil, err
     }
 
-    return &Mouse{conn, mouse, isAbs.(bool)}, nil
+    m := &Mouse{conn: conn, mouse: mouse}
+    m.isAbs.Store(isAbs.(bool))
+    return m, nil
 }
 
+// IsAbsolute tells whether the guest takes absolute positions, as it was
+// when the mouse was got or last reported by WatchAbsolute.
 func (m *Mouse) IsAbsolute() bool {
-    return m.isAbs
+    return m.isAbs.Load()
+}
+
+// WatchAbsolute sends whether the guest takes absolute positions, first as
+// it is and then every time it changes, e.g. when the guest loads a tablet
+// driver. The channel is closed once ctx is done or the VM disconnects.
+func (m *Mouse) WatchAbsolute(ctx context.Context) (<-chan bool, error) {
+    changes := make(chan bool, 16)
+
+    done, err := watchProperties(ctx, m.conn, m.mouse, mouseIntf, func(changed map[string]dbus.Variant, invalidated []string) {
+        if v, ok := changed["IsAbsolute"]; ok {
+            if abs, ok := v.Value().(bool); ok {
+                m.isAbs.Store(abs)
+                select {
+                case changes <- abs:
+                case <-ctx.Done():
+                }
+            }
+        }
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    // Subscribe first, so that no change is lost between the two
+    if isAbs, err := getProp(m.mouse, mouseIsAbs); err == nil {
+        m.isAbs.Store(isAbs.(bool))
+    }
+    initial := m.isAbs.Load()
+
+    modes := make(chan bool)
+
+    go func() {
+        defer close(modes)
+
+        abs := initial
+        for {
+            select {
+            case modes <- abs:
+            case <-ctx.Done():
+                return
+            }
+
+            select {
+            case abs = <-changes:
+            case <-done:
+                return
+            }
+        }
+    }()
+
+    return modes, nil
 }
 
 func (m *Mouse) SetAbsPosition(x, y uint32) {
     m.mouse.Call(mouseSetAbsPosition, 0, x, y)
 }
 
-func (m *Mouse) Press(button uint32) {
-    m.mouse.Call(mousePress, 0, button)
+func (m *Mouse) Press(button MouseButton) {
+    m.mouse.Call(mousePress, 0, uint32(button))
+}
+
+func (m *Mouse) Release(button MouseButton) {
+    m.mouse.Call(mouseRelease, 0, uint32(button))
+}
+
+func (m *Mouse) Click(button MouseButton) {
+    m.Press(button)
+    m.Release(button)
+}
+
+// Scroll turns smooth scrolling deltas into wheel clicks, keeping what is
+// left of a step for the next call. Positive dy scrolls up, positive dx
+// scrolls right.
+func (m *Mouse) Scroll(dx, dy float64) {
+    m.scrollX += dx
+    m.scrollY += dy
+
+    m.scrollX = m.scrollSteps(m.scrollX, ButtonWheelRight, ButtonWheelLeft)
+    m.scrollY = m.scrollSteps(m.scrollY, ButtonWheelUp, ButtonWheelDown)
+}
+
+func (m *Mouse) scrollSteps(acc float64, positive, negative MouseButton) float64 {
+    steps := math.Trunc(acc)
+
+    button := positive
+    if steps < 0 {
+        button = negative
+    }
+
+    for range int(math.Abs(steps)) {
+        m.Click(button)
+    }
+
+    return acc - steps
 }
 
-func (m *Mouse) Release(button uint32) {
-    m.mouse.Call(mouseRelease, 0, button)
+func (m *Mouse) RelMotion(dx, dy int32) {
+    m.mouse.Call(mouseRelMotion, 0, dx, dy)
 }
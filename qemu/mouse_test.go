@@ -0,0 +1,36 @@
+package qemu_test
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "qemu/qemutest"
+)
+
+func TestWatchAbsolute(t *testing.T) {
+    srv, console := connect(t, qemutest.VM{Consoles: []qemutest.Console{{Width: 640, Height: 480}}})
+    mouse, err := console.GetMouse()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    modes, err := mouse.WatchAbsolute(ctx)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if abs := <-modes; abs {
+        t.Error("relative mouse starts absolute")
+    }
+
+    srv.SetMouseAbsolute(0, true)
+    if abs := <-modes; !abs {
+        t.Error("mouse still relative after the switch")
+    }
+    if !mouse.IsAbsolute() {
+        t.Error("IsAbsolute did not follow the switch")
+    }
+}
@@ -0,0 +1,39 @@
+package qemu
+
+import (
+    "github.com/godbus/dbus/v5"
+)
+
+type MultiTouch struct {
+    conn     *dbus.Conn
+    touch    dbus.BusObject
+    maxSlots int32
+}
+
+type TouchEventKind uint32
+
+const (
+    TouchBegin  TouchEventKind = 0
+    TouchUpdate TouchEventKind = 1
+    TouchEnd    TouchEventKind = 2
+    TouchCancel TouchEventKind = 3
+)
+
+func newMultiTouch(conn *dbus.Conn, touch dbus.BusObject) (*MultiTouch, error) {
+    maxSlots, err := getProp(touch, multiTouchMaxSlots)
+    if err != nil {
+        return nil, err
+    }
+
+    return &MultiTouch{conn, touch, maxSlots.(int32)}, nil
+}
+
+// MaxSlots is the number of simultaneous touch points the device supports.
+func (t *MultiTouch) MaxSlots() int {
+    return int(t.maxSlots)
+}
+
+// SendEvent reports touch point slot at (x, y) in console pixels.
+func (t *MultiTouch) SendEvent(kind TouchEventKind, slot uint64, x, y float64) {
+    t.touch.Call(multiTouchSendEvent, 0, uint32(kind), slot, x, y)
+}
@@ -0,0 +1,130 @@
+package qemutest
+
+import (
+    "fmt"
+    "slices"
+
+    "github.com/godbus/dbus/v5"
+)
+
+const (
+    clipboardPath = displayPath + "/Clipboard"
+    clipboardIntf = displayIntf + ".Clipboard"
+)
+
+// ClipboardConsole is the Call.Console of the clipboard calls, which are
+// made on the VM rather than on a console.
+const ClipboardConsole = -1
+
+// guestSelection is a selection the guest grabbed.
+type guestSelection struct {
+    mime string
+    data []byte
+}
+
+// clipboardObj is the VM clipboard on the connection of one client.
+type clipboardObj struct {
+    s    *Server
+    conn *dbus.Conn
+}
+
+func (c *clipboardObj) Register() *dbus.Error {
+    c.s.mu.Lock()
+    c.s.clipboardPeer = c.conn
+    c.s.mu.Unlock()
+
+    c.s.record(ClipboardConsole, clipboardIntf+".Register")
+    return nil
+}
+
+func (c *clipboardObj) Unregister() *dbus.Error {
+    c.s.mu.Lock()
+    if c.s.clipboardPeer == c.conn {
+        c.s.clipboardPeer = nil
+    }
+    c.s.mu.Unlock()
+
+    c.s.record(ClipboardConsole, clipboardIntf+".Unregister")
+    return nil
+}
+
+func (c *clipboardObj) Grab(selection, serial uint32, mimes []string) *dbus.Error {
+    c.s.record(ClipboardConsole, clipboardIntf+".Grab", selection, serial, mimes)
+    return nil
+}
+
+func (c *clipboardObj) Release(selection uint32) *dbus.Error {
+    c.s.record(ClipboardConsole, clipboardIntf+".Release", selection)
+    return nil
+}
+
+// Request hands out what the guest grabbed with Server.GrabClipboard.
+func (c *clipboardObj) Request(selection uint32, mimes []string) (string, []byte, *dbus.Error) {
+    c.s.mu.Lock()
+    sel, ok := c.s.guestClipboard[selection]
+    c.s.mu.Unlock()
+
+    if !ok {
+        return "", nil, dbus.MakeFailedError(fmt.Errorf("selection %d is empty", selection))
+    }
+    if !slices.Contains(mimes, sel.mime) {
+        return "", nil, dbus.MakeFailedError(fmt.Errorf("selection %d is %s only", selection, sel.mime))
+    }
+    return sel.mime, sel.data, nil
+}
+
+// clipboardClient returns the clipboard of the client which registered
+// last.
+func (s *Server) clipboardClient() (dbus.BusObject, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if s.clipboardPeer == nil {
+        return nil, fmt.Errorf("no clipboard registered")
+    }
+    return s.clipboardPeer.Object("", clipboardPath), nil
+}
+
+// GrabClipboard makes the guest take selection with data of type mime, and
+// tells the registered client. The client gets data when it requests it.
+func (s *Server) GrabClipboard(selection, serial uint32, mime string, data []byte) error {
+    client, err := s.clipboardClient()
+    if err != nil {
+        return err
+    }
+
+    s.mu.Lock()
+    s.guestClipboard[selection] = guestSelection{mime, data}
+    s.mu.Unlock()
+
+    return client.Call(clipboardIntf+".Grab", 0, selection, serial, []string{mime}).Err
+}
+
+// ReleaseClipboard makes the guest drop selection, and tells the registered
+// client.
+func (s *Server) ReleaseClipboard(selection uint32) error {
+    client, err := s.clipboardClient()
+    if err != nil {
+        return err
+    }
+
+    s.mu.Lock()
+    delete(s.guestClipboard, selection)
+    s.mu.Unlock()
+
+    return client.Call(clipboardIntf+".Release", 0, selection).Err
+}
+
+// RequestClipboard asks the registered client for selection in one of
+// mimes, like a guest pasting what the client grabbed.
+func (s *Server) RequestClipboard(selection uint32, mimes []string) (string, []byte, error) {
+    client, err := s.clipboardClient()
+    if err != nil {
+        return "", nil, err
+    }
+
+    var mime string
+    var data []byte
+    err = client.Call(clipboardIntf+".Request", 0, selection, mimes).Store(&mime, &data)
+    return mime, data, err
+}
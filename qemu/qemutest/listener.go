@@ -0,0 +1,229 @@
+package qemutest
+
+import (
+    "fmt"
+    "os"
+    "sync"
+
+    "github.com/godbus/dbus/v5"
+)
+
+// Pixman formats QEMU uses for the most common surfaces
+const (
+    FormatA8R8G8B8 uint32 = 0x20028888
+    FormatX8R8G8B8 uint32 = 0x20020888
+)
+
+// Listener is a display listener a client registered with
+// Console.RegisterListener, seen from the QEMU side.
+type Listener struct {
+    conn     *dbus.Conn
+    listener dbus.BusObject
+    dir      string
+
+    mu        sync.Mutex
+    mapFile   *os.File
+    mapStride uint32
+    mapBpp    uint32
+}
+
+func newListener(conn *dbus.Conn, dir string) *Listener {
+    return &Listener{conn: conn, listener: conn.Object("", listenerPath), dir: dir}
+}
+
+// Interfaces returns the listener interfaces the client implements.
+func (l *Listener) Interfaces() ([]string, error) {
+    v, err := l.listener.GetProperty(listenerIntf + ".Interfaces")
+    if err != nil {
+        return nil, err
+    }
+
+    interfaces, ok := v.Value().([]string)
+    if !ok {
+        return nil, fmt.Errorf("Interfaces is %s, expected as", v.Signature())
+    }
+    return interfaces, nil
+}
+
+// Play calls the listener with every event in turn, stopping at the first
+// error.
+func (l *Listener) Play(events ...Event) error {
+    for _, e := range events {
+        err := e.play(l)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (l *Listener) call(method string, args ...interface{}) error {
+    return l.listener.Call(method, 0, args...).Err
+}
+
+func (l *Listener) Close() error {
+    l.mu.Lock()
+    if l.mapFile != nil {
+        l.mapFile.Close()
+        l.mapFile = nil
+    }
+    l.mu.Unlock()
+
+    return l.conn.Close()
+}
+
+// Event is something QEMU tells a display listener.
+type Event interface {
+    play(l *Listener) error
+}
+
+type Scanout struct {
+    Width, Height, Stride, Format uint32
+    Data                          []byte
+}
+
+func (e Scanout) play(l *Listener) error {
+    return l.call(listenerIntf+".Scanout", e.Width, e.Height, e.Stride, e.Format, e.Data)
+}
+
+// Update carries the pixels of the rectangle only, Stride applies to Data.
+type Update struct {
+    X, Y, Width, Height int32
+    Stride, Format      uint32
+    Data                []byte
+}
+
+func (e Update) play(l *Listener) error {
+    return l.call(listenerIntf+".Update", e.X, e.Y, e.Width, e.Height, e.Stride, e.Format, e.Data)
+}
+
+// ScanoutMap puts Data in a shared memory file and hands it to the client.
+type ScanoutMap struct {
+    Width, Height, Stride, Format uint32
+    Data                          []byte
+}
+
+func (e ScanoutMap) play(l *Listener) error {
+    f, err := os.CreateTemp(l.dir, "scanout")
+    if err != nil {
+        return err
+    }
+    os.Remove(f.Name())
+
+    data := e.Data
+    if size := int(e.Stride * e.Height); len(data) < size {
+        data = append(data, make([]byte, size-len(data))...)
+    }
+
+    if _, err := f.Write(data); err != nil {
+        f.Close()
+        return err
+    }
+
+    l.mu.Lock()
+    if l.mapFile != nil {
+        l.mapFile.Close()
+    }
+    l.mapFile = f
+    l.mapStride = e.Stride
+    l.mapBpp = e.Stride / max(e.Width, 1)
+    l.mu.Unlock()
+
+    return l.call(listenerUnixMapIntf+".ScanoutMap", dbus.UnixFD(f.Fd()), uint32(0), e.Width, e.Height, e.Stride, e.Format)
+}
+
+// UpdateMap writes Data, the rows of the rectangle without padding, to the
+// shared memory of the last ScanoutMap before notifying the client. Data
+// may be nil to only send the notification.
+type UpdateMap struct {
+    X, Y, Width, Height int32
+    Data                []byte
+}
+
+func (e UpdateMap) play(l *Listener) error {
+    l.mu.Lock()
+    f, stride, bpp := l.mapFile, l.mapStride, l.mapBpp
+    l.mu.Unlock()
+
+    if f == nil {
+        return fmt.Errorf("UpdateMap before ScanoutMap")
+    }
+
+    if e.Data != nil {
+        row := int(uint32(e.Width) * bpp)
+        for i := range int(e.Height) {
+            if (i+1)*row > len(e.Data) {
+                return fmt.Errorf("UpdateMap data is too short")
+            }
+
+            offset := int64(uint32(int(e.Y)+i)*stride + uint32(e.X)*bpp)
+            if _, err := f.WriteAt(e.Data[i*row:(i+1)*row], offset); err != nil {
+                return err
+            }
+        }
+    }
+
+    return l.call(listenerUnixMapIntf+".UpdateMap", e.X, e.Y, e.Width, e.Height)
+}
+
+// ScanoutDMABUF hands Data to the client as if it was a linear DMABUF,
+// which it is not: it is only a file, so it can be mapped but not synced.
+// Y0Top false means the rows of Data go from the bottom up.
+type ScanoutDMABUF struct {
+    Width, Height, Stride, Fourcc uint32
+    Modifier                      uint64
+    Y0Top                         bool
+    Data                          []byte
+}
+
+func (e ScanoutDMABUF) play(l *Listener) error {
+    f, err := os.CreateTemp(l.dir, "dmabuf")
+    if err != nil {
+        return err
+    }
+    os.Remove(f.Name())
+    defer f.Close()
+
+    data := e.Data
+    if size := int(e.Stride * e.Height); len(data) < size {
+        data = append(data, make([]byte, size-len(data))...)
+    }
+
+    if _, err := f.Write(data); err != nil {
+        return err
+    }
+
+    return l.call(listenerIntf+".ScanoutDMABUF", dbus.UnixFD(f.Fd()), e.Width, e.Height, e.Stride, e.Fourcc, e.Modifier, e.Y0Top)
+}
+
+type UpdateDMABUF struct {
+    X, Y, Width, Height int32
+}
+
+func (e UpdateDMABUF) play(l *Listener) error {
+    return l.call(listenerIntf+".UpdateDMABUF", e.X, e.Y, e.Width, e.Height)
+}
+
+// CursorDefine data is in the A8R8G8B8 format.
+type CursorDefine struct {
+    Width, Height, HotX, HotY int32
+    Data                      []byte
+}
+
+func (e CursorDefine) play(l *Listener) error {
+    return l.call(listenerIntf+".CursorDefine", e.Width, e.Height, e.HotX, e.HotY, e.Data)
+}
+
+type MouseSet struct {
+    X, Y, On int32
+}
+
+func (e MouseSet) play(l *Listener) error {
+    return l.call(listenerIntf+".MouseSet", e.X, e.Y, e.On)
+}
+
+type Disable struct{}
+
+func (e Disable) play(l *Listener) error {
+    return l.call(listenerIntf + ".Disable")
+}
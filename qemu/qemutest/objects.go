@@ -0,0 +1,306 @@
+package qemutest
+
+import (
+    "fmt"
+    "net"
+    "os"
+    "sync"
+
+    "github.com/godbus/dbus/v5"
+    "github.com/godbus/dbus/v5/prop"
+)
+
+const (
+    busName = "org.qemu"
+
+    busIntf = "org.freedesktop.DBus"
+    busPath = "/org/freedesktop/DBus"
+
+    displayIntf = "org.qemu.Display1"
+    displayPath = "/org/qemu/Display1"
+
+    vmPath = displayPath + "/VM"
+    vmIntf = displayIntf + ".VM"
+
+    consolePath = displayPath + "/Console_%d"
+    consoleIntf = displayIntf + ".Console"
+
+    mouseIntf      = displayIntf + ".Mouse"
+    keyboardIntf   = displayIntf + ".Keyboard"
+    multiTouchIntf = displayIntf + ".MultiTouch"
+
+    listenerPath = displayPath + "/Listener"
+    listenerIntf = displayIntf + ".Listener"
+
+    listenerUnixMapIntf = listenerIntf + ".Unix.Map"
+
+    propertiesIntf = "org.freedesktop.DBus.Properties"
+)
+
+// bus answers the calls godbus makes to the bus daemon.
+type bus struct {
+    name string
+}
+
+func (b *bus) Hello() (string, *dbus.Error) {
+    return b.name, nil
+}
+
+func (b *bus) AddMatch(rule string) *dbus.Error {
+    // Every signal goes to the only client anyway
+    return nil
+}
+
+func (b *bus) RemoveMatch(rule string) *dbus.Error {
+    return nil
+}
+
+func (b *bus) GetNameOwner(name string) (string, *dbus.Error) {
+    if name != busName {
+        return "", dbus.NewError("org.freedesktop.DBus.Error.NameHasNoOwner", []interface{}{name})
+    }
+    return ":qemutest", nil
+}
+
+func (b *bus) NameHasOwner(name string) (bool, *dbus.Error) {
+    return name == busName, nil
+}
+
+// vmObj serves the VM properties itself rather than through prop, which
+// updates slices in place while replies still refer to them.
+type vmObj struct {
+    conn       *dbus.Conn
+    name       string
+    uuid       string
+    interfaces []string
+
+    mu         sync.Mutex
+    consoleIDs []uint32
+}
+
+func (v *vmObj) GetAll(intf string) (map[string]dbus.Variant, *dbus.Error) {
+    if intf != vmIntf {
+        return nil, prop.ErrIfaceNotFound
+    }
+
+    v.mu.Lock()
+    defer v.mu.Unlock()
+
+    return map[string]dbus.Variant{
+        "Name":       dbus.MakeVariant(v.name),
+        "UUID":       dbus.MakeVariant(v.uuid),
+        "ConsoleIDs": dbus.MakeVariant(v.consoleIDs),
+        "Interfaces": dbus.MakeVariant(v.interfaces),
+    }, nil
+}
+
+func (v *vmObj) Get(intf, name string) (dbus.Variant, *dbus.Error) {
+    all, err := v.GetAll(intf)
+    if err != nil {
+        return dbus.Variant{}, err
+    }
+
+    val, ok := all[name]
+    if !ok {
+        return dbus.Variant{}, prop.ErrPropNotFound
+    }
+    return val, nil
+}
+
+func (v *vmObj) Set(intf, name string, value dbus.Variant) *dbus.Error {
+    return prop.ErrReadOnly
+}
+
+// setConsoleIDs takes ownership of ids.
+func (v *vmObj) setConsoleIDs(ids []uint32) {
+    v.mu.Lock()
+    v.consoleIDs = ids
+    v.mu.Unlock()
+
+    v.conn.Emit(vmPath, propertiesIntf+".PropertiesChanged", vmIntf, map[string]dbus.Variant{
+        "ConsoleIDs": dbus.MakeVariant(ids),
+    }, []string{})
+}
+
+type consoleObj struct {
+    s *Server
+    n int
+}
+
+func (c *consoleObj) RegisterListener(fd dbus.UnixFD) *dbus.Error {
+    f := os.NewFile(uintptr(fd), "listener")
+    nc, err := net.FileConn(f)
+    f.Close()
+    if err != nil {
+        return dbus.MakeFailedError(err)
+    }
+
+    // The client authenticates only after we reply
+    go func() {
+        conn, err := accept(nc.(*net.UnixConn), c.s.guid)
+        if err != nil {
+            return
+        }
+        c.s.addListener(c.n, newListener(conn, c.s.dir))
+    }()
+
+    return nil
+}
+
+func (c *consoleObj) SetUIInfo(width_mm, height_mm uint16, xoff, yoff int32, width, height uint32) *dbus.Error {
+    c.s.record(c.n, consoleIntf+".SetUIInfo", width_mm, height_mm, xoff, yoff, width, height)
+    return nil
+}
+
+type mouseObj struct {
+    s *Server
+    n int
+}
+
+func (m *mouseObj) Press(button uint32) *dbus.Error {
+    m.s.record(m.n, mouseIntf+".Press", button)
+    return nil
+}
+
+func (m *mouseObj) Release(button uint32) *dbus.Error {
+    m.s.record(m.n, mouseIntf+".Release", button)
+    return nil
+}
+
+func (m *mouseObj) SetAbsPosition(x, y uint32) *dbus.Error {
+    m.s.record(m.n, mouseIntf+".SetAbsPosition", x, y)
+    return nil
+}
+
+func (m *mouseObj) RelMotion(dx, dy int32) *dbus.Error {
+    m.s.record(m.n, mouseIntf+".RelMotion", dx, dy)
+    return nil
+}
+
+type keyboardObj struct {
+    s *Server
+    n int
+}
+
+func (k *keyboardObj) Press(keycode uint32) *dbus.Error {
+    k.s.record(k.n, keyboardIntf+".Press", keycode)
+    return nil
+}
+
+func (k *keyboardObj) Release(keycode uint32) *dbus.Error {
+    k.s.record(k.n, keyboardIntf+".Release", keycode)
+    return nil
+}
+
+type multiTouchObj struct {
+    s *Server
+    n int
+}
+
+func (t *multiTouchObj) SendEvent(kind uint32, num_slot uint64, x, y float64) *dbus.Error {
+    t.s.record(t.n, multiTouchIntf+".SendEvent", kind, num_slot, x, y)
+    return nil
+}
+
+func constProp(v interface{}) *prop.Prop {
+    return &prop.Prop{Value: v, Writable: false, Emit: prop.EmitConst}
+}
+
+func emitProp(v interface{}) *prop.Prop {
+    return &prop.Prop{Value: v, Writable: false, Emit: prop.EmitTrue}
+}
+
+// export puts the whole display on a fresh client connection.
+func (s *Server) export(conn *dbus.Conn) (*peer, error) {
+    err := conn.Export(&bus{":qemutest.client"}, busPath, busIntf)
+    if err != nil {
+        return nil, err
+    }
+
+    s.mu.Lock()
+    consoleIDs := append([]uint32(nil), s.consoleIDs...)
+    consoles := append([]Console(nil), s.vm.Consoles...)
+    s.mu.Unlock()
+
+    vm := &vmObj{
+        conn:       conn,
+        name:       s.vm.Name,
+        uuid:       s.vm.UUID,
+        interfaces: []string{vmIntf},
+        consoleIDs: consoleIDs,
+    }
+    err = conn.Export(vm, vmPath, propertiesIntf)
+    if err != nil {
+        return nil, err
+    }
+
+    if s.vm.Clipboard {
+        err = conn.Export(&clipboardObj{s, conn}, clipboardPath, clipboardIntf)
+        if err != nil {
+            return nil, err
+        }
+        vm.interfaces = append(vm.interfaces, clipboardIntf)
+    }
+
+    p := &peer{conn: conn, vm: vm}
+
+    for i, c := range consoles {
+        path := dbus.ObjectPath(fmt.Sprintf(consolePath, i))
+
+        interfaces := []string{consoleIntf, keyboardIntf, mouseIntf}
+
+        err = conn.Export(&consoleObj{s, i}, path, consoleIntf)
+        if err != nil {
+            return nil, err
+        }
+        err = conn.Export(&mouseObj{s, i}, path, mouseIntf)
+        if err != nil {
+            return nil, err
+        }
+        err = conn.Export(&keyboardObj{s, i}, path, keyboardIntf)
+        if err != nil {
+            return nil, err
+        }
+
+        s.mu.Lock()
+        modifiers := s.modifiers[i]
+        s.mu.Unlock()
+
+        props := map[string]map[string]*prop.Prop{
+            mouseIntf: {
+                "IsAbsolute": emitProp(c.MouseAbsolute),
+            },
+            keyboardIntf: {
+                "Modifiers": emitProp(modifiers),
+            },
+        }
+
+        if c.MultiTouchSlots != 0 {
+            err = conn.Export(&multiTouchObj{s, i}, path, multiTouchIntf)
+            if err != nil {
+                return nil, err
+            }
+            props[multiTouchIntf] = map[string]*prop.Prop{
+                "MaxSlots": constProp(c.MultiTouchSlots),
+            }
+            interfaces = append(interfaces, multiTouchIntf)
+        }
+
+        props[consoleIntf] = map[string]*prop.Prop{
+            "Label":      emitProp(c.Label),
+            "Type":       constProp(c.Type),
+            "Width":      emitProp(c.Width),
+            "Height":     emitProp(c.Height),
+            "Head":       constProp(uint32(0)),
+            "Interfaces": constProp(interfaces),
+        }
+
+        consoleProps, err := prop.Export(conn, path, props)
+        if err != nil {
+            return nil, err
+        }
+        p.consoleProps = append(p.consoleProps, consoleProps)
+    }
+
+    return p, nil
+}
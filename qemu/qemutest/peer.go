@@ -0,0 +1,190 @@
+package qemutest
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "fmt"
+    "net"
+    "os"
+    "strings"
+    "syscall"
+
+    "github.com/godbus/dbus/v5"
+)
+
+// godbus only implements the client side of the D-Bus authentication, while
+// QEMU is the server on every connection it accepts. So we terminate the
+// client handshake ourselves, authenticate our own connection against a
+// private socketpair and relay everything between the two afterwards.
+
+func newGUID() string {
+    b := make([]byte, 16)
+    rand.Read(b)
+    return hex.EncodeToString(b)
+}
+
+func readLine(c *net.UnixConn) (string, error) {
+    var line []byte
+    b := make([]byte, 1)
+
+    // One byte at a time, not to eat anything past BEGIN
+    for {
+        _, err := c.Read(b)
+        if err != nil {
+            return "", err
+        }
+
+        line = append(line, b[0])
+        if len(line) >= 2 && line[len(line)-2] == '\r' && line[len(line)-1] == '\n' {
+            return string(line[:len(line)-2]), nil
+        }
+    }
+}
+
+// serverHandshake accepts any client offering EXTERNAL authentication.
+func serverHandshake(c *net.UnixConn, guid string) error {
+    nul := make([]byte, 1)
+    if _, err := c.Read(nul); err != nil {
+        return err
+    }
+    if nul[0] != 0 {
+        return fmt.Errorf("expected NUL byte, got 0x%02x", nul[0])
+    }
+
+    for {
+        line, err := readLine(c)
+        if err != nil {
+            return err
+        }
+
+        var reply string
+        switch {
+        case strings.HasPrefix(line, "AUTH EXTERNAL"):
+            reply = "OK " + guid
+        case strings.HasPrefix(line, "AUTH"), line == "CANCEL", strings.HasPrefix(line, "ERROR"):
+            reply = "REJECTED EXTERNAL"
+        case line == "NEGOTIATE_UNIX_FD":
+            reply = "AGREE_UNIX_FD"
+        case line == "BEGIN":
+            return nil
+        default:
+            reply = "ERROR unknown command"
+        }
+
+        if _, err := c.Write([]byte(reply + "\r\n")); err != nil {
+            return err
+        }
+    }
+}
+
+// relay copies data along with the passed fds from src to dst.
+func relay(dst, src *net.UnixConn) {
+    defer dst.Close()
+    defer src.Close()
+
+    buf := make([]byte, 64*1024)
+    oob := make([]byte, syscall.CmsgSpace(253*4))
+
+    for {
+        n, oobn, _, _, err := src.ReadMsgUnix(buf, oob)
+        if n <= 0 {
+            return
+        }
+
+        var fds []int
+        if oobn > 0 {
+            msgs, _ := syscall.ParseSocketControlMessage(oob[:oobn])
+            for _, msg := range msgs {
+                rights, err := syscall.ParseUnixRights(&msg)
+                if err == nil {
+                    fds = append(fds, rights...)
+                }
+            }
+        }
+
+        var rights []byte
+        if len(fds) > 0 {
+            rights = syscall.UnixRights(fds...)
+        }
+
+        written, _, werr := dst.WriteMsgUnix(buf[:n], rights, nil)
+        if werr == nil && written < n {
+            _, werr = dst.Write(buf[written:n])
+        }
+
+        for _, fd := range fds {
+            syscall.Close(fd)
+        }
+
+        if werr != nil || err != nil {
+            return
+        }
+    }
+}
+
+// accept runs the server side of the handshake on c and returns an
+// authenticated connection speaking to the client.
+func accept(c *net.UnixConn, guid string) (*dbus.Conn, error) {
+    if err := serverHandshake(c, guid); err != nil {
+        c.Close()
+        return nil, err
+    }
+
+    fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+    if err != nil {
+        c.Close()
+        return nil, err
+    }
+
+    ours, err := fdToUnixConn(fds[0])
+    if err != nil {
+        syscall.Close(fds[1])
+        c.Close()
+        return nil, err
+    }
+
+    theirs, err := fdToUnixConn(fds[1])
+    if err != nil {
+        ours.Close()
+        c.Close()
+        return nil, err
+    }
+
+    handshake := make(chan error, 1)
+    go func() {
+        handshake <- serverHandshake(theirs, guid)
+    }()
+
+    conn, err := dbus.DialUnix(ours)
+    if err == nil {
+        err = conn.Auth([]dbus.Auth{dbus.AuthExternal(fmt.Sprint(os.Geteuid()))})
+    }
+    if err == nil {
+        err = <-handshake
+    }
+    if err != nil {
+        if conn != nil {
+            conn.Close()
+        }
+        ours.Close()
+        theirs.Close()
+        c.Close()
+        return nil, err
+    }
+
+    go relay(c, theirs)
+    go relay(theirs, c)
+
+    return conn, nil
+}
+
+func fdToUnixConn(fd int) (*net.UnixConn, error) {
+    f := os.NewFile(uintptr(fd), "qemutest")
+    defer f.Close()
+
+    c, err := net.FileConn(f)
+    if err != nil {
+        return nil, err
+    }
+    return c.(*net.UnixConn), nil
+}
@@ -0,0 +1,359 @@
+package qemutest
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "net"
+    "os"
+    "path/filepath"
+    "sync"
+    "syscall"
+    "time"
+)
+
+// QMPServer is a fake QMP monitor. It tracks the run state changed by the
+// life cycle commands and emits their events, and add_client of
+// "@dbus-display" connects the passed fd to a display Server.
+type QMPServer struct {
+    display *Server
+
+    dir      string
+    listener *net.UnixListener
+
+    mu       sync.Mutex
+    running  bool
+    commands []string
+    conns    []*qmpConn
+}
+
+type qmpConn struct {
+    c   *net.UnixConn
+    wmu sync.Mutex
+
+    // fds received but not claimed by getfd yet, and the ones that were
+    fds   []int
+    named map[string]int
+}
+
+// NewQMPServer starts a QMP server for a running VM. display may be nil if
+// add_client is not needed.
+func NewQMPServer(display *Server) (*QMPServer, error) {
+    dir, err := os.MkdirTemp("", "qemutest")
+    if err != nil {
+        return nil, err
+    }
+
+    listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: filepath.Join(dir, "qmp"), Net: "unix"})
+    if err != nil {
+        os.RemoveAll(dir)
+        return nil, err
+    }
+
+    q := &QMPServer{
+        display:  display,
+        dir:      dir,
+        listener: listener,
+        running:  true,
+    }
+    go q.serve()
+
+    return q, nil
+}
+
+// Path returns the path of the QMP socket.
+func (q *QMPServer) Path() string {
+    return q.listener.Addr().String()
+}
+
+func (q *QMPServer) Close() error {
+    err := q.listener.Close()
+
+    q.mu.Lock()
+    conns := q.conns
+    q.conns = nil
+    q.mu.Unlock()
+
+    for _, c := range conns {
+        c.c.Close()
+    }
+    os.RemoveAll(q.dir)
+
+    return err
+}
+
+// Commands returns the names of the commands executed so far.
+func (q *QMPServer) Commands() []string {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+
+    return append([]string(nil), q.commands...)
+}
+
+// Running reports whether the VM is running or paused.
+func (q *QMPServer) Running() bool {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+
+    return q.running
+}
+
+// Event sends an event to all the connected clients.
+func (q *QMPServer) Event(name string, data interface{}) {
+    q.mu.Lock()
+    conns := append([]*qmpConn(nil), q.conns...)
+    q.mu.Unlock()
+
+    now := time.Now()
+    ev := map[string]interface{}{
+        "event": name,
+        "timestamp": map[string]int64{
+            "seconds":      now.Unix(),
+            "microseconds": int64(now.Nanosecond() / 1000),
+        },
+    }
+    if data != nil {
+        ev["data"] = data
+    }
+
+    for _, c := range conns {
+        c.send(ev)
+    }
+}
+
+func (q *QMPServer) serve() {
+    for {
+        c, err := q.listener.AcceptUnix()
+        if err != nil {
+            return
+        }
+
+        go q.handle(c)
+    }
+}
+
+func (q *QMPServer) handle(c *net.UnixConn) {
+    conn := &qmpConn{c: c, named: map[string]int{}}
+    defer q.drop(conn)
+
+    conn.send(map[string]interface{}{
+        "QMP": map[string]interface{}{
+            "version": map[string]interface{}{
+                "qemu":    map[string]int{"major": 9, "minor": 2, "micro": 0},
+                "package": "",
+            },
+            "capabilities": []string{},
+        },
+    })
+
+    dec := json.NewDecoder(conn)
+    negotiated := false
+
+    for {
+        var req struct {
+            Execute   string          `json:"execute"`
+            Arguments json.RawMessage `json:"arguments"`
+            ID        json.RawMessage `json:"id"`
+        }
+        if err := dec.Decode(&req); err != nil {
+            return
+        }
+
+        q.mu.Lock()
+        q.commands = append(q.commands, req.Execute)
+        q.mu.Unlock()
+
+        var (
+            ret interface{}
+            err error
+        )
+        if !negotiated && req.Execute != "qmp_capabilities" {
+            err = fmt.Errorf("Expecting capabilities negotiation with 'qmp_capabilities'")
+        } else {
+            ret, err = q.execute(conn, req.Execute, req.Arguments)
+        }
+
+        resp := map[string]interface{}{}
+        if err != nil {
+            class := "GenericError"
+            if err == errCommandNotFound {
+                class = "CommandNotFound"
+                err = fmt.Errorf("The command %s has not been found", req.Execute)
+            }
+            resp["error"] = map[string]string{"class": class, "desc": err.Error()}
+        } else {
+            if ret == nil {
+                ret = struct{}{}
+            }
+            resp["return"] = ret
+        }
+        if req.ID != nil {
+            resp["id"] = req.ID
+        }
+        conn.send(resp)
+
+        switch {
+        case err != nil:
+        case req.Execute == "qmp_capabilities":
+            if !negotiated {
+                negotiated = true
+                q.mu.Lock()
+                q.conns = append(q.conns, conn)
+                q.mu.Unlock()
+            }
+        case req.Execute == "quit":
+            q.Event("SHUTDOWN", map[string]interface{}{"guest": false, "reason": "host-qmp-quit"})
+            return
+        }
+    }
+}
+
+func (q *QMPServer) drop(conn *qmpConn) {
+    q.mu.Lock()
+    for i, c := range q.conns {
+        if c == conn {
+            q.conns = append(q.conns[:i], q.conns[i+1:]...)
+            break
+        }
+    }
+    q.mu.Unlock()
+
+    conn.close()
+}
+
+var errCommandNotFound = fmt.Errorf("command not found")
+
+func (q *QMPServer) execute(conn *qmpConn, command string, arguments json.RawMessage) (interface{}, error) {
+    var args struct {
+        FDName   string `json:"fdname"`
+        Protocol string `json:"protocol"`
+    }
+    if len(arguments) > 0 {
+        if err := json.Unmarshal(arguments, &args); err != nil {
+            return nil, err
+        }
+    }
+
+    switch command {
+    case "qmp_capabilities", "quit":
+        return nil, nil
+    case "query-status":
+        q.mu.Lock()
+        defer q.mu.Unlock()
+        status := "running"
+        if !q.running {
+            status = "paused"
+        }
+        return map[string]interface{}{"running": q.running, "status": status}, nil
+    case "stop":
+        q.setRunning(false, "STOP")
+        return nil, nil
+    case "cont":
+        q.setRunning(true, "RESUME")
+        return nil, nil
+    case "system_reset":
+        q.Event("RESET", map[string]interface{}{"guest": false, "reason": "host-qmp-system-reset"})
+        return nil, nil
+    case "system_powerdown":
+        q.Event("POWERDOWN", nil)
+        return nil, nil
+    case "getfd":
+        return nil, conn.getfd(args.FDName)
+    case "closefd":
+        fd, ok := conn.named[args.FDName]
+        if !ok {
+            return nil, fmt.Errorf("File descriptor named '%s' not found", args.FDName)
+        }
+        delete(conn.named, args.FDName)
+        syscall.Close(fd)
+        return nil, nil
+    case "add_client":
+        return nil, q.addClient(conn, args.Protocol, args.FDName)
+    default:
+        return nil, errCommandNotFound
+    }
+}
+
+func (q *QMPServer) setRunning(running bool, event string) {
+    q.mu.Lock()
+    changed := q.running != running
+    q.running = running
+    q.mu.Unlock()
+
+    if changed {
+        q.Event(event, nil)
+    }
+}
+
+func (q *QMPServer) addClient(conn *qmpConn, protocol, fdname string) error {
+    fd, ok := conn.named[fdname]
+    if !ok {
+        return fmt.Errorf("File descriptor named '%s' not found", fdname)
+    }
+
+    if protocol != "@dbus-display" || q.display == nil {
+        return fmt.Errorf("protocol '%s' is invalid", protocol)
+    }
+
+    delete(conn.named, fdname)
+    c, err := fdToUnixConn(fd)
+    if err != nil {
+        return err
+    }
+    go q.display.handle(c)
+
+    return nil
+}
+
+// Read collects the fds passed along with the commands for getfd.
+func (c *qmpConn) Read(b []byte) (int, error) {
+    oob := make([]byte, syscall.CmsgSpace(16*4))
+    n, oobn, _, _, err := c.c.ReadMsgUnix(b, oob)
+
+    if oobn > 0 {
+        msgs, _ := syscall.ParseSocketControlMessage(oob[:oobn])
+        for _, msg := range msgs {
+            if fds, err := syscall.ParseUnixRights(&msg); err == nil {
+                c.fds = append(c.fds, fds...)
+            }
+        }
+    }
+
+    if n < 0 {
+        n = 0
+    }
+    return n, err
+}
+
+func (c *qmpConn) getfd(name string) error {
+    if len(c.fds) == 0 {
+        return fmt.Errorf("No file descriptor supplied via SCM_RIGHTS")
+    }
+
+    if old, ok := c.named[name]; ok {
+        syscall.Close(old)
+    }
+    c.named[name] = c.fds[0]
+    c.fds = c.fds[1:]
+
+    return nil
+}
+
+func (c *qmpConn) send(v interface{}) {
+    var buf bytes.Buffer
+    json.NewEncoder(&buf).Encode(v)
+
+    c.wmu.Lock()
+    defer c.wmu.Unlock()
+    c.c.Write(buf.Bytes())
+}
+
+func (c *qmpConn) close() {
+    c.c.Close()
+    for _, fd := range c.fds {
+        syscall.Close(fd)
+    }
+    for _, fd := range c.named {
+        syscall.Close(fd)
+    }
+}
@@ -0,0 +1,358 @@
+// Package qemutest serves a fake QEMU D-Bus display, so that code built on
+// package qemu can be tested without a running VM.
+//
+// The server speaks directly to its clients, without a bus daemon in
+// between, and answers the few bus methods the clients need itself:
+//
+//	srv, err := qemutest.NewServer(qemutest.VM{})
+//	...
+//	vm, err := qemu.Connect(ctx, qemu.WithAddress(srv.Address()))
+//
+// ClientFD gives peer-to-peer connections for qemu.WithFD instead, and a
+// QMPServer hands them out through add_client like QEMU does.
+//
+// Every input and clipboard method call it gets is recorded and available
+// from Calls. Registered display listeners can be driven with Listener.Play,
+// and the guest side of the clipboard with GrabClipboard, ReleaseClipboard
+// and RequestClipboard.
+package qemutest
+
+import (
+    "context"
+    "fmt"
+    "net"
+    "os"
+    "path/filepath"
+    "sync"
+    "syscall"
+
+    "github.com/godbus/dbus/v5"
+    "github.com/godbus/dbus/v5/prop"
+)
+
+// VM describes the fake machine.
+type VM struct {
+    Name     string
+    UUID     string
+    Consoles []Console
+    // Clipboard enables the Clipboard interface
+    Clipboard bool
+}
+
+// Console describes one of its displays.
+type Console struct {
+    Label         string
+    Type          string
+    Width         uint32
+    Height        uint32
+    MouseAbsolute bool
+    // MultiTouchSlots enables the MultiTouch interface if non-zero
+    MultiTouchSlots int32
+}
+
+// Call is an input method call made by a client.
+type Call struct {
+    // Console is the index of the console in VM.Consoles, or
+    // ClipboardConsole
+    Console int
+    // Method is the full D-Bus name, e.g. org.qemu.Display1.Keyboard.Press
+    Method string
+    Args   []interface{}
+}
+
+type Server struct {
+    vm   VM
+    guid string
+
+    dir      string
+    listener *net.UnixListener
+
+    mu         sync.Mutex
+    changed    chan struct{}
+    peers      []*peer
+    calls      []Call
+    modifiers  []uint32
+    listeners  [][]*Listener
+    consoleIDs []uint32
+
+    // The client whose clipboard gets the guest calls, and what the guest
+    // grabbed
+    clipboardPeer  *dbus.Conn
+    guestClipboard map[uint32]guestSelection
+}
+
+// peer is a client connection along with what we exported on it.
+type peer struct {
+    conn         *dbus.Conn
+    vm           *vmObj
+    consoleProps []*prop.Properties
+}
+
+func NewServer(vm VM) (*Server, error) {
+    if vm.Name == "" {
+        vm.Name = "qemutest"
+    }
+    if vm.UUID == "" {
+        vm.UUID = "00000000-0000-0000-0000-000000000000"
+    }
+    if len(vm.Consoles) == 0 {
+        vm.Consoles = []Console{{Label: "qemutest-vga", Type: "Graphic", Width: 640, Height: 480, MouseAbsolute: true}}
+    }
+
+    dir, err := os.MkdirTemp("", "qemutest")
+    if err != nil {
+        return nil, err
+    }
+
+    listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: filepath.Join(dir, "bus"), Net: "unix"})
+    if err != nil {
+        os.RemoveAll(dir)
+        return nil, err
+    }
+
+    vm.Consoles = append([]Console(nil), vm.Consoles...)
+
+    consoleIDs := make([]uint32, len(vm.Consoles))
+    for i := range consoleIDs {
+        consoleIDs[i] = uint32(i)
+    }
+
+    s := &Server{
+        vm:         vm,
+        guid:       newGUID(),
+        dir:        dir,
+        listener:   listener,
+        changed:    make(chan struct{}),
+        modifiers:  make([]uint32, len(vm.Consoles)),
+        listeners:  make([][]*Listener, len(vm.Consoles)),
+        consoleIDs: consoleIDs,
+
+        guestClipboard: map[uint32]guestSelection{},
+    }
+
+    go s.serve()
+
+    return s, nil
+}
+
+// Address is the D-Bus address to connect to.
+func (s *Server) Address() string {
+    return "unix:path=" + s.listener.Addr().String()
+}
+
+func (s *Server) Close() error {
+    err := s.listener.Close()
+    s.Disconnect()
+    os.RemoveAll(s.dir)
+
+    return err
+}
+
+// Disconnect drops all the clients and their display listeners, as if QEMU
+// was restarted. New clients are still accepted.
+func (s *Server) Disconnect() {
+    s.mu.Lock()
+    peers := s.peers
+    s.peers = nil
+    s.clipboardPeer = nil
+    var listeners []*Listener
+    for i, l := range s.listeners {
+        listeners = append(listeners, l...)
+        s.listeners[i] = nil
+    }
+    s.notify()
+    s.mu.Unlock()
+
+    for _, p := range peers {
+        p.conn.Close()
+    }
+    for _, l := range listeners {
+        l.Close()
+    }
+}
+
+func (s *Server) serve() {
+    for {
+        c, err := s.listener.AcceptUnix()
+        if err != nil {
+            return
+        }
+
+        go s.handle(c)
+    }
+}
+
+func (s *Server) handle(c *net.UnixConn) {
+    conn, err := accept(c, s.guid)
+    if err != nil {
+        return
+    }
+
+    p, err := s.export(conn)
+    if err != nil {
+        conn.Close()
+        return
+    }
+
+    s.mu.Lock()
+    s.peers = append(s.peers, p)
+    s.mu.Unlock()
+}
+
+// notify wakes up everyone waiting for a change, s.mu must be held.
+func (s *Server) notify() {
+    close(s.changed)
+    s.changed = make(chan struct{})
+}
+
+func (s *Server) record(console int, method string, args ...interface{}) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.calls = append(s.calls, Call{console, method, args})
+    s.notify()
+}
+
+// Calls returns the input calls received so far, oldest first.
+func (s *Server) Calls() []Call {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    return append([]Call(nil), s.calls...)
+}
+
+// ResetCalls forgets the recorded calls.
+func (s *Server) ResetCalls() {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.calls = nil
+}
+
+// SetModifiers changes the lock state of the console keyboard and notifies
+// the clients about it.
+func (s *Server) SetModifiers(console int, mods uint32) {
+    s.mu.Lock()
+    s.modifiers[console] = mods
+    peers := append([]*peer(nil), s.peers...)
+    s.mu.Unlock()
+
+    for _, p := range peers {
+        p.consoleProps[console].SetMust(keyboardIntf, "Modifiers", mods)
+    }
+}
+
+// SetMouseAbsolute switches the console mouse between absolute and
+// relative, as a guest loading or unloading a tablet driver does, and
+// notifies the clients.
+func (s *Server) SetMouseAbsolute(console int, absolute bool) {
+    s.mu.Lock()
+    s.vm.Consoles[console].MouseAbsolute = absolute
+    peers := append([]*peer(nil), s.peers...)
+    s.mu.Unlock()
+
+    for _, p := range peers {
+        p.consoleProps[console].SetMust(mouseIntf, "IsAbsolute", absolute)
+    }
+}
+
+// SetConsoleIDs plugs the consoles in ids, which index VM.Consoles, and
+// unplugs the others.
+func (s *Server) SetConsoleIDs(ids []uint32) error {
+    for _, id := range ids {
+        if int(id) >= len(s.vm.Consoles) {
+            return fmt.Errorf("console %d does not exist", id)
+        }
+    }
+
+    s.mu.Lock()
+    s.consoleIDs = append([]uint32(nil), ids...)
+    peers := append([]*peer(nil), s.peers...)
+    s.mu.Unlock()
+
+    for _, p := range peers {
+        p.vm.setConsoleIDs(append([]uint32(nil), ids...))
+    }
+
+    return nil
+}
+
+// ResizeConsole changes the size of the console and notifies the clients.
+func (s *Server) ResizeConsole(console int, width, height uint32) {
+    s.mu.Lock()
+    s.vm.Consoles[console].Width = width
+    s.vm.Consoles[console].Height = height
+    peers := append([]*peer(nil), s.peers...)
+    s.mu.Unlock()
+
+    for _, p := range peers {
+        p.consoleProps[console].SetMust(consoleIntf, "Width", width)
+        p.consoleProps[console].SetMust(consoleIntf, "Height", height)
+    }
+}
+
+// SetConsoleLabel changes the label of the console and notifies the
+// clients.
+func (s *Server) SetConsoleLabel(console int, label string) {
+    s.mu.Lock()
+    s.vm.Consoles[console].Label = label
+    peers := append([]*peer(nil), s.peers...)
+    s.mu.Unlock()
+
+    for _, p := range peers {
+        p.consoleProps[console].SetMust(consoleIntf, "Label", label)
+    }
+}
+
+// WaitListener waits until a client registers a display listener on the
+// console and returns the most recent one.
+func (s *Server) WaitListener(ctx context.Context, console int) (*Listener, error) {
+    if console < 0 || console >= len(s.vm.Consoles) {
+        return nil, fmt.Errorf("console %d does not exist", console)
+    }
+
+    for {
+        s.mu.Lock()
+        listeners := s.listeners[console]
+        changed := s.changed
+        s.mu.Unlock()
+
+        if len(listeners) > 0 {
+            return listeners[len(listeners)-1], nil
+        }
+
+        select {
+        case <-changed:
+        case <-ctx.Done():
+            return nil, ctx.Err()
+        }
+    }
+}
+
+func (s *Server) addListener(console int, l *Listener) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.listeners[console] = append(s.listeners[console], l)
+    s.notify()
+}
+
+// ClientFD returns the client end of a new peer-to-peer connection, like
+// the one QEMU started with -display dbus,p2p=yes accepts from QMP
+// add_client. The caller owns the fd.
+func (s *Server) ClientFD() (int, error) {
+    fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+    if err != nil {
+        return -1, err
+    }
+
+    c, err := fdToUnixConn(fds[1])
+    if err != nil {
+        syscall.Close(fds[0])
+        return -1, err
+    }
+
+    go s.handle(c)
+
+    return fds[0], nil
+}
@@ -0,0 +1,90 @@
+package qmp
+
+import (
+    "context"
+    "fmt"
+    "sync/atomic"
+    "syscall"
+)
+
+// Status is the reply to query-status.
+type Status struct {
+    Running bool   `json:"running"`
+    Status  string `json:"status"`
+}
+
+func (c *Client) QueryStatus(ctx context.Context) (Status, error) {
+    var st Status
+    err := c.Execute(ctx, "query-status", nil, &st)
+    return st, err
+}
+
+// Stop pauses the VM.
+func (c *Client) Stop(ctx context.Context) error {
+    return c.Execute(ctx, "stop", nil, nil)
+}
+
+// Cont resumes a paused VM.
+func (c *Client) Cont(ctx context.Context) error {
+    return c.Execute(ctx, "cont", nil, nil)
+}
+
+// SystemReset resets the VM as if the reset button was pressed.
+func (c *Client) SystemReset(ctx context.Context) error {
+    return c.Execute(ctx, "system_reset", nil, nil)
+}
+
+// SystemPowerdown asks the guest to shut down, like pressing the power
+// button. The guest may ignore it.
+func (c *Client) SystemPowerdown(ctx context.Context) error {
+    return c.Execute(ctx, "system_powerdown", nil, nil)
+}
+
+// Quit terminates QEMU right away, the guest is not told.
+func (c *Client) Quit(ctx context.Context) error {
+    return c.Execute(ctx, "quit", nil, nil)
+}
+
+// GetFD passes fd to QEMU under name. The caller keeps its own copy of fd.
+func (c *Client) GetFD(ctx context.Context, name string, fd int) error {
+    args := struct {
+        FDName string `json:"fdname"`
+    }{name}
+
+    return c.execute(ctx, "getfd", args, nil, []int{fd})
+}
+
+// AddClient hands the fd previously passed by GetFD to the server of
+// protocol, such as "vnc", "spice" or "@dbus-display".
+func (c *Client) AddClient(ctx context.Context, protocol, fdname string) error {
+    args := struct {
+        Protocol string `json:"protocol"`
+        FDName   string `json:"fdname"`
+    }{protocol, fdname}
+
+    return c.Execute(ctx, "add_client", args, nil)
+}
+
+var fdSerial atomic.Uint64
+
+// AddDisplayClient connects a new peer-to-peer client to the D-Bus display
+// of QEMU started with -display dbus,p2p=yes and returns its fd, ready for
+// qemu.WithFD.
+func (c *Client) AddDisplayClient(ctx context.Context) (int, error) {
+    fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+    if err != nil {
+        return -1, err
+    }
+    defer syscall.Close(fds[1])
+
+    name := fmt.Sprintf("godisplay%d", fdSerial.Add(1))
+    if err = c.GetFD(ctx, name, fds[1]); err == nil {
+        err = c.AddClient(ctx, "@dbus-display", name)
+    }
+    if err != nil {
+        syscall.Close(fds[0])
+        return -1, err
+    }
+
+    return fds[0], nil
+}
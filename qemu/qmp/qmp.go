@@ -0,0 +1,266 @@
+// Package qmp is a small client for the QEMU Machine Protocol, enough to
+// control the VM life cycle next to the D-Bus display.
+//
+// QEMU has to be started with a QMP socket, e.g.
+// -qmp unix:/run/vm.qmp,server=on,wait=off
+package qmp
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net"
+    "strconv"
+    "sync"
+    "syscall"
+    "time"
+)
+
+// ErrClosed is returned for commands which did not get a reply before the
+// connection went away.
+var ErrClosed = errors.New("qmp: connection closed")
+
+// Error is an error reply to a command.
+type Error struct {
+    Class string `json:"class"`
+    Desc  string `json:"desc"`
+}
+
+func (e *Error) Error() string {
+    return fmt.Sprintf("qmp: %s: %s", e.Class, e.Desc)
+}
+
+type Version struct {
+    Qemu struct {
+        Major int `json:"major"`
+        Minor int `json:"minor"`
+        Micro int `json:"micro"`
+    } `json:"qemu"`
+    Package string `json:"package"`
+}
+
+func (v Version) String() string {
+    return fmt.Sprintf("%d.%d.%d%s", v.Qemu.Major, v.Qemu.Minor, v.Qemu.Micro, v.Package)
+}
+
+// Event is an asynchronous notification from QEMU, see the QAPI schema for
+// the event names and their data.
+type Event struct {
+    Name      string
+    Data      json.RawMessage
+    Timestamp time.Time
+}
+
+type message struct {
+    QMP *struct {
+        Version Version `json:"version"`
+    } `json:"QMP"`
+
+    Event     string          `json:"event"`
+    Data      json.RawMessage `json:"data"`
+    Timestamp struct {
+        Seconds      int64 `json:"seconds"`
+        Microseconds int64 `json:"microseconds"`
+    } `json:"timestamp"`
+
+    ID     string          `json:"id"`
+    Return json.RawMessage `json:"return"`
+    Error  *Error          `json:"error"`
+}
+
+type request struct {
+    Execute   string      `json:"execute"`
+    Arguments interface{} `json:"arguments,omitempty"`
+    ID        string      `json:"id"`
+}
+
+type Client struct {
+    conn    *net.UnixConn
+    version Version
+
+    wmu sync.Mutex
+
+    mu      sync.Mutex
+    nextID  uint64
+    pending map[string]chan *message
+    err     error
+
+    events chan Event
+    done   chan struct{}
+}
+
+// Dial connects to the QMP socket at path and negotiates capabilities.
+func Dial(ctx context.Context, path string) (*Client, error) {
+    var d net.Dialer
+    c, err := d.DialContext(ctx, "unix", path)
+    if err != nil {
+        return nil, err
+    }
+
+    return NewClient(ctx, c.(*net.UnixConn))
+}
+
+// NewClient runs the QMP handshake on conn. The client owns conn from now
+// on, even if the handshake fails.
+func NewClient(ctx context.Context, conn *net.UnixConn) (*Client, error) {
+    c := &Client{
+        conn:    conn,
+        pending: map[string]chan *message{},
+        events:  make(chan Event, 64),
+        done:    make(chan struct{}),
+    }
+
+    dec := json.NewDecoder(conn)
+
+    if deadline, ok := ctx.Deadline(); ok {
+        conn.SetReadDeadline(deadline)
+    }
+    var greeting message
+    err := dec.Decode(&greeting)
+    conn.SetReadDeadline(time.Time{})
+    if err != nil {
+        conn.Close()
+        return nil, err
+    }
+    if greeting.QMP == nil {
+        conn.Close()
+        return nil, fmt.Errorf("qmp: no greeting from the server")
+    }
+    c.version = greeting.QMP.Version
+
+    go c.read(dec)
+
+    if err := c.Execute(ctx, "qmp_capabilities", nil, nil); err != nil {
+        c.Close()
+        return nil, err
+    }
+
+    return c, nil
+}
+
+// Version returns the QEMU version announced in the greeting.
+func (c *Client) Version() Version {
+    return c.version
+}
+
+// Events returns the events sent by QEMU. The channel is closed with the
+// connection, events are dropped if it is not drained.
+func (c *Client) Events() <-chan Event {
+    return c.events
+}
+
+// Done is closed when the connection is gone.
+func (c *Client) Done() <-chan struct{} {
+    return c.done
+}
+
+func (c *Client) Close() error {
+    err := c.conn.Close()
+    <-c.done
+    return err
+}
+
+func (c *Client) read(dec *json.Decoder) {
+    var err error
+    for {
+        var msg message
+        if err = dec.Decode(&msg); err != nil {
+            break
+        }
+
+        if msg.Event != "" {
+            ev := Event{
+                Name:      msg.Event,
+                Data:      msg.Data,
+                Timestamp: time.Unix(msg.Timestamp.Seconds, msg.Timestamp.Microseconds*1000),
+            }
+            select {
+            case c.events <- ev:
+            default:
+            }
+            continue
+        }
+
+        c.mu.Lock()
+        ch, ok := c.pending[msg.ID]
+        delete(c.pending, msg.ID)
+        c.mu.Unlock()
+
+        if ok {
+            ch <- &msg
+        }
+    }
+
+    c.mu.Lock()
+    c.err = err
+    for id, ch := range c.pending {
+        close(ch)
+        delete(c.pending, id)
+    }
+    c.mu.Unlock()
+
+    close(c.events)
+    close(c.done)
+}
+
+// Execute runs command with args, which is marshalled to JSON and may be
+// nil, and unmarshals the reply into result unless it is nil.
+func (c *Client) Execute(ctx context.Context, command string, args, result interface{}) error {
+    return c.execute(ctx, command, args, result, nil)
+}
+
+func (c *Client) execute(ctx context.Context, command string, args, result interface{}, fds []int) error {
+    c.mu.Lock()
+    if c.err != nil {
+        c.mu.Unlock()
+        return ErrClosed
+    }
+    c.nextID++
+    id := strconv.FormatUint(c.nextID, 10)
+    ch := make(chan *message, 1)
+    c.pending[id] = ch
+    c.mu.Unlock()
+
+    b, err := json.Marshal(request{command, args, id})
+    if err != nil {
+        c.forget(id)
+        return err
+    }
+
+    var oob []byte
+    if len(fds) > 0 {
+        oob = syscall.UnixRights(fds...)
+    }
+
+    c.wmu.Lock()
+    _, _, err = c.conn.WriteMsgUnix(b, oob, nil)
+    c.wmu.Unlock()
+    if err != nil {
+        c.forget(id)
+        return err
+    }
+
+    select {
+    case msg, ok := <-ch:
+        if !ok {
+            return ErrClosed
+        }
+        if msg.Error != nil {
+            return msg.Error
+        }
+        if result != nil {
+            return json.Unmarshal(msg.Return, result)
+        }
+        return nil
+    case <-ctx.Done():
+        c.forget(id)
+        return ctx.Err()
+    }
+}
+
+func (c *Client) forget(id string) {
+    c.mu.Lock()
+    delete(c.pending, id)
+    c.mu.Unlock()
+}
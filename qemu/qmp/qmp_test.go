@@ -0,0 +1,243 @@
+package qmp_test
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net"
+    "os"
+    "syscall"
+    "testing"
+    "time"
+
+    "qemu"
+    "qemu/qemutest"
+    "qemu/qmp"
+)
+
+// dial connects to a new fake QMP monitor, display may be nil.
+func dial(t *testing.T, ctx context.Context, display *qemutest.Server) (*qemutest.QMPServer, *qmp.Client) {
+    t.Helper()
+
+    srv, err := qemutest.NewQMPServer(display)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { srv.Close() })
+
+    client, err := qmp.Dial(ctx, srv.Path())
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { client.Close() })
+
+    return srv, client
+}
+
+func TestCommands(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    srv, client := dial(t, ctx, nil)
+
+    if v := client.Version(); v.Qemu.Major != 9 || v.Qemu.Minor != 2 {
+        t.Errorf("version is %v", v)
+    }
+
+    if err := client.Stop(ctx); err != nil {
+        t.Fatal(err)
+    }
+    st, err := client.QueryStatus(ctx)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if st.Running || st.Status != "paused" {
+        t.Errorf("status after stop is %+v", st)
+    }
+    if srv.Running() {
+        t.Error("the VM still runs after stop")
+    }
+
+    if err := client.Cont(ctx); err != nil {
+        t.Fatal(err)
+    }
+    if !srv.Running() {
+        t.Error("the VM is still paused after cont")
+    }
+}
+
+func TestEvents(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    srv, client := dial(t, ctx, nil)
+
+    if err := client.Stop(ctx); err != nil {
+        t.Fatal(err)
+    }
+    srv.Event("CUSTOM", map[string]string{"key": "value"})
+
+    if ev := <-client.Events(); ev.Name != "STOP" || ev.Timestamp.IsZero() {
+        t.Errorf("first event is %+v, want STOP", ev)
+    }
+
+    ev := <-client.Events()
+    var data map[string]string
+    if err := json.Unmarshal(ev.Data, &data); err != nil {
+        t.Fatal(err)
+    }
+    if ev.Name != "CUSTOM" || data["key"] != "value" {
+        t.Errorf("second event is %s %s", ev.Name, ev.Data)
+    }
+
+    // quit closes the connection after its SHUTDOWN
+    if err := client.Quit(ctx); err != nil {
+        t.Fatal(err)
+    }
+    var names []string
+    for ev := range client.Events() {
+        names = append(names, ev.Name)
+    }
+    if len(names) != 1 || names[0] != "SHUTDOWN" {
+        t.Errorf("events after quit are %v, want SHUTDOWN", names)
+    }
+
+    select {
+    case <-client.Done():
+    case <-ctx.Done():
+        t.Fatal("the client is not done once the connection is gone")
+    }
+    if err := client.Stop(ctx); err != qmp.ErrClosed {
+        t.Errorf("command after the connection is gone returned %v", err)
+    }
+}
+
+func TestErrorReply(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    _, client := dial(t, ctx, nil)
+
+    err := client.Execute(ctx, "no-such-command", nil, nil)
+    var qerr *qmp.Error
+    if !errors.As(err, &qerr) || qerr.Class != "CommandNotFound" {
+        t.Fatalf("unknown command returned %v", err)
+    }
+
+    // The connection is still usable
+    if _, err := client.QueryStatus(ctx); err != nil {
+        t.Errorf("command after an error reply: %v", err)
+    }
+}
+
+// TestReplyOrder answers two commands in the reverse order, each must get
+// the reply with its id.
+func TestReplyOrder(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    conns := make([]*net.UnixConn, 2)
+    for i, fd := range fds {
+        f := os.NewFile(uintptr(fd), "qmp")
+        c, err := net.FileConn(f)
+        f.Close()
+        if err != nil {
+            t.Fatal(err)
+        }
+        conns[i] = c.(*net.UnixConn)
+    }
+    server := conns[1]
+    defer server.Close()
+
+    type request struct {
+        Execute string          `json:"execute"`
+        ID      json.RawMessage `json:"id"`
+    }
+
+    go func() {
+        enc, dec := json.NewEncoder(server), json.NewDecoder(server)
+        enc.Encode(map[string]interface{}{"QMP": map[string]interface{}{"version": map[string]interface{}{}}})
+
+        var req request
+        if dec.Decode(&req) != nil {
+            return
+        }
+        enc.Encode(map[string]interface{}{"return": struct{}{}, "id": req.ID})
+
+        var first, second request
+        if dec.Decode(&first) != nil || dec.Decode(&second) != nil {
+            return
+        }
+        for _, req := range []request{second, first} {
+            enc.Encode(map[string]interface{}{"return": req.Execute, "id": req.ID})
+        }
+    }()
+
+    client, err := qmp.NewClient(ctx, conns[0])
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer client.Close()
+
+    results := make(chan [2]string, 2)
+    for _, command := range []string{"first", "second"} {
+        go func() {
+            var ret string
+            if err := client.Execute(ctx, command, nil, &ret); err != nil {
+                ret = err.Error()
+            }
+            results <- [2]string{command, ret}
+        }()
+    }
+
+    for range 2 {
+        r := <-results
+        if r[0] != r[1] {
+            t.Errorf("%s got the reply %q", r[0], r[1])
+        }
+    }
+}
+
+func TestAddDisplayClient(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    display, err := qemutest.NewServer(qemutest.VM{Name: "p2p"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer display.Close()
+
+    srv, client := dial(t, ctx, display)
+
+    fd, err := client.AddDisplayClient(ctx)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    vm, err := qemu.Connect(ctx, qemu.WithFD(fd))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer vm.Close()
+
+    if vm.Name() != "p2p" {
+        t.Errorf("connected to %q, want p2p", vm.Name())
+    }
+
+    commands := srv.Commands()
+    if n := len(commands); n < 2 || commands[n-2] != "getfd" || commands[n-1] != "add_client" {
+        t.Errorf("commands are %v, want getfd and add_client last", commands)
+    }
+
+    // add_client of an fd which was not passed
+    err = client.AddClient(ctx, "@dbus-display", "nonexistent")
+    var qerr *qmp.Error
+    if !errors.As(err, &qerr) {
+        t.Errorf("add_client of an unknown fd returned %v", err)
+    }
+}
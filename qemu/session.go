@@ -0,0 +1,210 @@
+package qemu
+
+import (
+    "context"
+    "sync"
+    "time"
+)
+
+const (
+    sessionMinBackoff = 250 * time.Millisecond
+    sessionMaxBackoff = 10 * time.Second
+)
+
+type SessionEventKind int
+
+const (
+    // SessionConnected is sent once a VM is connected and set up
+    SessionConnected SessionEventKind = iota
+    // SessionDisconnected is sent when the connected VM went away
+    SessionDisconnected
+    // SessionFailed is sent when connecting or setting up failed, another
+    // attempt is made after Retry
+    SessionFailed
+)
+
+type SessionEvent struct {
+    Kind  SessionEventKind
+    VM    *VM
+    Err   error
+    Retry time.Duration
+}
+
+// SetupFunc prepares a freshly connected VM, e.g. registers listeners.
+// ctx is canceled when the VM disconnects.
+type SetupFunc func(ctx context.Context, vm *VM) error
+
+// Session keeps a VM connected across QEMU restarts and bus drops. Every
+// time the connection is lost it dials again with exponential backoff, and
+// reruns the setup functions on the new VM.
+type Session struct {
+    dial func(ctx context.Context) (*VM, error)
+
+    mu     sync.Mutex
+    vm     *VM
+    vmCtx  context.Context
+    setups []SetupFunc
+
+    events chan SessionEvent
+    done   chan struct{}
+}
+
+// NewSession connects with opts until ctx is done. WithFD and WithConn
+// can only be used once, use NewSessionFunc to get a new fd every time.
+func NewSession(ctx context.Context, opts ...Option) *Session {
+    return NewSessionFunc(ctx, func(ctx context.Context) (*VM, error) {
+        return Connect(ctx, opts...)
+    })
+}
+
+// NewSessionFunc connects with dial until ctx is done.
+func NewSessionFunc(ctx context.Context, dial func(ctx context.Context) (*VM, error)) *Session {
+    s := &Session{
+        dial:   dial,
+        events: make(chan SessionEvent, 16),
+        done:   make(chan struct{}),
+    }
+    go s.run(ctx)
+
+    return s
+}
+
+// Events returns the connection state changes. It must be drained, the
+// session waits for the events to be taken. It is closed after the session
+// ends.
+func (s *Session) Events() <-chan SessionEvent {
+    return s.events
+}
+
+// Done is closed once the session ended and the last VM was closed.
+func (s *Session) Done() <-chan struct{} {
+    return s.done
+}
+
+// VM returns the connected VM, or nil.
+func (s *Session) VM() *VM {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    return s.vm
+}
+
+// OnConnect adds a setup function, which is run right away if a VM is
+// connected already.
+func (s *Session) OnConnect(fn SetupFunc) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.setups = append(s.setups, fn)
+    if s.vm != nil {
+        return fn(s.vmCtx, s.vm)
+    }
+
+    return nil
+}
+
+// RegisterListener registers listener on console n of every VM connected.
+func (s *Session) RegisterListener(n int, listener DisplayListener) error {
+    return s.OnConnect(func(ctx context.Context, vm *VM) error {
+        console, err := vm.GetConsole(n)
+        if err != nil {
+            return err
+        }
+
+        if err = console.RegisterListener(listener); err != nil {
+            return err
+        }
+
+        go func() {
+            <-ctx.Done()
+            console.UnregisterListener(listener)
+        }()
+
+        return nil
+    })
+}
+
+func (s *Session) send(ctx context.Context, ev SessionEvent) {
+    select {
+    case s.events <- ev:
+    case <-ctx.Done():
+    }
+}
+
+func (s *Session) run(ctx context.Context) {
+    defer close(s.done)
+    defer close(s.events)
+
+    backoff := sessionMinBackoff
+
+    for ctx.Err() == nil {
+        vm, vmCtx, err := s.connect(ctx)
+        if err != nil {
+            s.send(ctx, SessionEvent{Kind: SessionFailed, Err: err, Retry: backoff})
+
+            select {
+            case <-time.After(backoff):
+            case <-ctx.Done():
+            }
+
+            backoff *= 2
+            if backoff > sessionMaxBackoff {
+                backoff = sessionMaxBackoff
+            }
+            continue
+        }
+        backoff = sessionMinBackoff
+
+        s.send(ctx, SessionEvent{Kind: SessionConnected, VM: vm})
+
+        select {
+        case <-vm.Disconnected():
+        case <-ctx.Done():
+        }
+
+        s.mu.Lock()
+        s.vm = nil
+        s.mu.Unlock()
+
+        <-vmCtx.Done()
+        vm.Close()
+
+        if ctx.Err() == nil {
+            s.send(ctx, SessionEvent{Kind: SessionDisconnected, VM: vm})
+        }
+    }
+}
+
+// connect dials and runs the setup functions. The returned context is
+// canceled once the VM is disconnected or the session ends.
+func (s *Session) connect(ctx context.Context) (*VM, context.Context, error) {
+    vm, err := s.dial(ctx)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    vmCtx, cancel := context.WithCancel(ctx)
+    go func() {
+        select {
+        case <-vm.Disconnected():
+        case <-vmCtx.Done():
+        }
+        cancel()
+    }()
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for _, fn := range s.setups {
+        if err = fn(vmCtx, vm); err != nil {
+            cancel()
+            vm.Close()
+            return nil, nil, err
+        }
+    }
+
+    s.vm = vm
+    s.vmCtx = vmCtx
+
+    return vm, vmCtx, nil
+}
@@ -0,0 +1,114 @@
+package qemu_test
+
+import (
+    "context"
+    "sync"
+    "testing"
+    "time"
+
+    "qemu"
+    "qemu/qemutest"
+)
+
+// nextEvent waits for the next session event, skipping failed attempts if
+// skipFailed is set.
+func nextEvent(t *testing.T, ctx context.Context, s *qemu.Session, skipFailed bool) qemu.SessionEvent {
+    t.Helper()
+
+    for {
+        select {
+        case ev := <-s.Events():
+            if ev.Kind == qemu.SessionFailed && skipFailed {
+                continue
+            }
+            return ev
+        case <-ctx.Done():
+            t.Fatal("no session event:", ctx.Err())
+        }
+    }
+}
+
+func TestSessionReconnect(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    newServer := func(name string) *qemutest.Server {
+        srv, err := qemutest.NewServer(qemutest.VM{Name: name})
+        if err != nil {
+            t.Fatal(err)
+        }
+        t.Cleanup(func() { srv.Close() })
+        return srv
+    }
+
+    // A restarted QEMU is a new server, at a new address
+    var mu sync.Mutex
+    srv := newServer("first")
+    address := srv.Address()
+
+    sessionCtx, stop := context.WithCancel(ctx)
+    defer stop()
+    session := qemu.NewSessionFunc(sessionCtx, func(ctx context.Context) (*qemu.VM, error) {
+        mu.Lock()
+        defer mu.Unlock()
+        return qemu.Connect(ctx, qemu.WithAddress(address))
+    })
+    if err := session.RegisterListener(0, &recorder{}); err != nil {
+        t.Fatal(err)
+    }
+
+    ev := nextEvent(t, ctx, session, false)
+    if ev.Kind != qemu.SessionConnected || ev.VM.Name() != "first" {
+        t.Fatalf("got event %+v, want connected to first", ev)
+    }
+    if _, err := srv.WaitListener(ctx, 0); err != nil {
+        t.Fatal(err)
+    }
+
+    // QEMU stops
+    srv.Close()
+    if ev = nextEvent(t, ctx, session, false); ev.Kind != qemu.SessionDisconnected {
+        t.Fatalf("got event %+v, want disconnected", ev)
+    }
+    if ev = nextEvent(t, ctx, session, false); ev.Kind != qemu.SessionFailed || ev.Retry <= 0 {
+        t.Fatalf("got event %+v, want a failed attempt", ev)
+    }
+    if session.VM() != nil {
+        t.Error("session has a VM while QEMU is gone")
+    }
+
+    // And starts again
+    srv = newServer("second")
+    mu.Lock()
+    address = srv.Address()
+    mu.Unlock()
+
+    ev = nextEvent(t, ctx, session, true)
+    if ev.Kind != qemu.SessionConnected || ev.VM.Name() != "second" {
+        t.Fatalf("got event %+v, want connected to second", ev)
+    }
+    if _, err := srv.WaitListener(ctx, 0); err != nil {
+        t.Fatal("listener not registered again:", err)
+    }
+
+    // The bus drops, the same QEMU is connected to again
+    srv.Disconnect()
+    if ev = nextEvent(t, ctx, session, false); ev.Kind != qemu.SessionDisconnected {
+        t.Fatalf("got event %+v, want disconnected", ev)
+    }
+    if ev = nextEvent(t, ctx, session, true); ev.Kind != qemu.SessionConnected {
+        t.Fatalf("got event %+v, want connected", ev)
+    }
+    if _, err := srv.WaitListener(ctx, 0); err != nil {
+        t.Fatal("listener not registered again:", err)
+    }
+
+    // The events end with the session
+    stop()
+    for ev := range session.Events() {
+        if ev.Kind == qemu.SessionConnected {
+            t.Errorf("got event %+v after the session ended", ev)
+        }
+    }
+    <-session.Done()
+}
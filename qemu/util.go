@@ -1,6 +1,7 @@
 package qemu
 
 import (
+    "context"
     "net"
     "os"
     "syscall"
@@ -32,3 +33,109 @@ func socketpair() (dbus.UnixFD, dbus.UnixFD, error) {
 
     return dbus.UnixFD(fds[0]), dbus.UnixFD(fds[1]), nil
 }
+
+// dialPeer creates a private peer-to-peer connection and hands the other end
+// of it to QEMU via method. export is called before QEMU gets the fd, so that
+// all objects are in place by the time it starts talking to us.
+func dialPeer(obj dbus.BusObject, method string, export func(conn *dbus.Conn) error) (*dbus.Conn, *net.UnixConn, error) {
+    usFd, themFd, err := socketpair()
+    if err != nil {
+        return nil, nil, err
+    }
+    defer syscall.Close(int(themFd))
+
+    us, err := fdToUnixConn(usFd, "us")
+    if err != nil {
+        return nil, nil, err
+    }
+
+    conn, err := dbus.DialUnix(us)
+    if err != nil {
+        us.Close()
+        return nil, nil, err
+    }
+
+    err = export(conn)
+    if err != nil {
+        conn.Close()
+        us.Close()
+        return nil, nil, err
+    }
+
+    ret := obj.Call(method, 0, themFd)
+
+    if err = conn.Auth(nil); err != nil {
+        conn.Close()
+        us.Close()
+        return nil, nil, err
+    }
+
+    // FIXME: hangs here. Is this expected?
+    // <- ret.Done
+
+    return conn, us, ret.Err
+}
+
+// watchProperties calls fn with the properties of intf that changed on obj,
+// until ctx is done or the connection is closed. done is closed afterwards.
+func watchProperties(ctx context.Context, conn *dbus.Conn, obj dbus.BusObject, intf string, fn func(changed map[string]dbus.Variant, invalidated []string)) (done <-chan struct{}, err error) {
+    match := []dbus.MatchOption{
+        dbus.WithMatchObjectPath(obj.Path()),
+        dbus.WithMatchInterface(propertiesIntf),
+        dbus.WithMatchMember(propertiesChanged),
+        dbus.WithMatchArg(0, intf),
+    }
+
+    // Peer-to-peer connections get every signal without asking
+    peer := isPeer(conn)
+    if !peer {
+        err = conn.AddMatchSignalContext(ctx, match...)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    signals := make(chan *dbus.Signal, 16)
+    conn.Signal(signals)
+
+    finished := make(chan struct{})
+
+    go func() {
+        defer close(finished)
+        defer conn.RemoveSignal(signals)
+        if !peer {
+            defer conn.RemoveMatchSignal(match...)
+        }
+
+        for {
+            select {
+            case <-ctx.Done():
+                return
+            case sig, ok := <-signals:
+                if !ok {
+                    return
+                }
+
+                if sig.Path != obj.Path() || sig.Name != propertiesIntf+"."+propertiesChanged || len(sig.Body) != 3 {
+                    continue
+                }
+
+                if changedIntf, _ := sig.Body[0].(string); changedIntf != intf {
+                    continue
+                }
+
+                changed, _ := sig.Body[1].(map[string]dbus.Variant)
+                invalidated, _ := sig.Body[2].([]string)
+                fn(changed, invalidated)
+            }
+        }
+    }()
+
+    return finished, nil
+}
+
+// isPeer tells whether conn goes directly to QEMU rather than through a bus,
+// in which case it never got a unique name.
+func isPeer(conn *dbus.Conn) bool {
+    return len(conn.Names()) == 0
+}
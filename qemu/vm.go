@@ -1,34 +1,182 @@
 package qemu
 
 import (
+    "context"
     "fmt"
-    "os"
+    "slices"
+    "sync"
+    "syscall"
 
     "github.com/godbus/dbus/v5"
 )
 
 type VM struct {
     conn       *dbus.Conn
+    ownConn    bool
     vm         dbus.BusObject
     name       string
     uuid       string
-    consoleIDs []uint32
     interfaces []string
+
+    mu         sync.Mutex
+    consoleIDs []uint32
+
+    disconnected chan struct{}
+    stopWatch    context.CancelFunc
 }
 
-func NewVM(path ...string) (*VM, error) {
-    if len(path) == 1 {
-        os.Setenv("DBUS_SESSION_BUS_ADDRESS", path[0])
-    } else if len(path) != 0 {
-        return nil, fmt.Errorf("wrong number of arguments: %d, expected 0 or 1", len(path))
+type connectOptions struct {
+    address string
+    conn    *dbus.Conn
+    fd      int
+}
+
+type Option func(o *connectOptions) error
+
+// WithAddress connects to the bus at address instead of the session bus.
+func WithAddress(address string) Option {
+    return func(o *connectOptions) error {
+        o.address = address
+        return nil
     }
+}
 
-    conn, err := dbus.SessionBus()
+// WithConn uses an already established connection, which is left open by
+// VM.Close.
+func WithConn(conn *dbus.Conn) Option {
+    return func(o *connectOptions) error {
+        o.conn = conn
+        return nil
+    }
+}
 
+// WithFD speaks directly to QEMU over fd, the client end of a socket the
+// other end of which was handed to QEMU started with -display dbus,p2p=yes
+// by the QMP add_client command. The VM takes ownership of fd.
+func WithFD(fd int) Option {
+    return func(o *connectOptions) error {
+        o.fd = fd
+        return nil
+    }
+}
+
+// Connect opens a private connection to QEMU. Without options it connects
+// to the session bus, at most one of WithAddress, WithConn and WithFD may
+// be given. ctx only bounds connecting, the VM stays connected after it is
+// done.
+func Connect(ctx context.Context, opts ...Option) (*VM, error) {
+    o := connectOptions{fd: -1}
+    for _, opt := range opts {
+        if err := opt(&o); err != nil {
+            return nil, err
+        }
+    }
+
+    n := 0
+    for _, set := range []bool{o.address != "", o.conn != nil, o.fd >= 0} {
+        if set {
+            n++
+        }
+    }
+    if n > 1 {
+        if o.fd >= 0 {
+            // WithFD handed it over, nobody else will close it
+            syscall.Close(o.fd)
+        }
+        return nil, fmt.Errorf("only one of WithAddress, WithConn and WithFD may be used")
+    }
+
+    if o.conn != nil {
+        return newVM(o.conn, false)
+    }
+
+    type result struct {
+        vm  *VM
+        err error
+    }
+    done := make(chan result, 1)
+
+    go func() {
+        vm, err := dial(o)
+        done <- result{vm, err}
+    }()
+
+    select {
+    case r := <-done:
+        return r.vm, r.err
+    case <-ctx.Done():
+        // Nobody will use it once it connects
+        go func() {
+            if r := <-done; r.vm != nil {
+                r.vm.Close()
+            }
+        }()
+        return nil, ctx.Err()
+    }
+}
+
+// dial opens the connection described by o and sets the VM up on it.
+func dial(o connectOptions) (*VM, error) {
+    var (
+        conn *dbus.Conn
+        err  error
+    )
+
+    switch {
+    case o.fd >= 0:
+        conn, err = dialFD(o.fd)
+    case o.address != "":
+        conn, err = dbus.Connect(o.address)
+    default:
+        conn, err = dbus.ConnectSessionBus()
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    vm, err := newVM(conn, true)
+    if err != nil {
+        conn.Close()
+        return nil, err
+    }
+
+    return vm, nil
+}
+
+func dialFD(fd int) (*dbus.Conn, error) {
+    us, err := fdToUnixConn(dbus.UnixFD(fd), "p2p")
+    if err != nil {
+        return nil, err
+    }
+
+    conn, err := dbus.DialUnix(us)
     if err != nil {
+        us.Close()
         return nil, err
     }
 
+    // There is no bus, so no Hello either
+    if err = conn.Auth(nil); err != nil {
+        conn.Close()
+        return nil, err
+    }
+
+    return conn, nil
+}
+
+// NewVM connects to the session bus, or to the bus at path if given. It is
+// a shorthand for Connect.
+func NewVM(path ...string) (*VM, error) {
+    if len(path) == 1 {
+        return Connect(context.Background(), WithAddress(path[0]))
+    } else if len(path) != 0 {
+        return nil, fmt.Errorf("wrong number of arguments: %d, expected 0 or 1", len(path))
+    }
+
+    return Connect(context.Background())
+}
+
+func newVM(conn *dbus.Conn, ownConn bool) (*VM, error) {
     conn.EnableUnixFDs()
     if !conn.SupportsUnixFDs() {
         return nil, fmt.Errorf("fds are not supported")
@@ -56,7 +204,86 @@ func NewVM(path ...string) (*VM, error) {
         return nil, err
     }
 
-    return &VM{conn, vm, name.(string), uuid.(string), cons.([]uint32), intf.([]string)}, nil
+    v := &VM{
+        conn:         conn,
+        ownConn:      ownConn,
+        vm:           vm,
+        name:         name.(string),
+        uuid:         uuid.(string),
+        consoleIDs:   cons.([]uint32),
+        interfaces:   intf.([]string),
+        disconnected: make(chan struct{}),
+    }
+
+    if err = v.watchDisconnect(); err != nil {
+        return nil, err
+    }
+
+    return v, nil
+}
+
+// watchDisconnect closes vm.disconnected once the connection is gone or
+// QEMU drops its name from the bus.
+func (vm *VM) watchDisconnect() error {
+    ctx, cancel := context.WithCancel(vm.conn.Context())
+    vm.stopWatch = cancel
+
+    match := []dbus.MatchOption{
+        dbus.WithMatchInterface(busIntf),
+        dbus.WithMatchMember(nameOwnerChanged),
+        dbus.WithMatchArg(0, qemuIntf),
+    }
+
+    peer := isPeer(vm.conn)
+    if !peer {
+        if err := vm.conn.AddMatchSignal(match...); err != nil {
+            cancel()
+            return err
+        }
+    }
+
+    signals := make(chan *dbus.Signal, 4)
+    vm.conn.Signal(signals)
+
+    go func() {
+        defer close(vm.disconnected)
+        defer vm.conn.RemoveSignal(signals)
+        if !peer {
+            defer vm.conn.RemoveMatchSignal(match...)
+        }
+
+        for {
+            select {
+            case <-ctx.Done():
+                return
+            case sig, ok := <-signals:
+                if !ok {
+                    return
+                }
+
+                if sig.Name != busIntf+"."+nameOwnerChanged || len(sig.Body) != 3 {
+                    continue
+                }
+
+                if name, _ := sig.Body[0].(string); name != qemuIntf {
+                    continue
+                }
+
+                if owner, _ := sig.Body[2].(string); owner == "" {
+                    return
+                }
+            }
+        }
+    }()
+
+    return nil
+}
+
+// Disconnected is closed when the VM goes away: the connection is closed,
+// by either side, or QEMU leaves the bus. Objects got from the VM are
+// useless afterwards and a new VM has to be connected.
+func (vm *VM) Disconnected() <-chan struct{} {
+    return vm.disconnected
 }
 
 func (vm *VM) Name() string {
@@ -68,17 +295,139 @@ func (vm *VM) UUID() string {
 }
 
 func (vm *VM) NumConsoles() int {
+    vm.mu.Lock()
+    defer vm.mu.Unlock()
+
     return len(vm.consoleIDs)
 }
 
+// ConsoleIDs returns the IDs of the consoles, they are not contiguous once
+// consoles were unplugged.
+func (vm *VM) ConsoleIDs() []uint32 {
+    vm.mu.Lock()
+    defer vm.mu.Unlock()
+
+    return slices.Clone(vm.consoleIDs)
+}
+
+// GetConsole returns the n-th console in the ConsoleIDs order.
 func (vm *VM) GetConsole(n int) (*Console, error) {
-    if n >= len(vm.consoleIDs) {
-        return nil, fmt.Errorf("console %d does not exist, max is %d", n, len(vm.consoleIDs)-1)
+    ids := vm.ConsoleIDs()
+    if n < 0 || n >= len(ids) {
+        return nil, fmt.Errorf("console %d does not exist, max is %d", n, len(ids)-1)
+    }
+
+    return newConsole(vm.conn, ids[n])
+}
+
+// GetConsoleByID returns the console with the given ID.
+func (vm *VM) GetConsoleByID(id uint32) (*Console, error) {
+    if !slices.Contains(vm.ConsoleIDs(), id) {
+        return nil, fmt.Errorf("there is no console with ID %d", id)
+    }
+
+    return newConsole(vm.conn, id)
+}
+
+// ConsolesEvent tells which consoles were added or removed, and which are
+// there now.
+type ConsolesEvent struct {
+    IDs     []uint32
+    Added   []uint32
+    Removed []uint32
+}
+
+func diffIDs(old, new []uint32) (added, removed []uint32) {
+    for _, id := range new {
+        if !slices.Contains(old, id) {
+            added = append(added, id)
+        }
+    }
+    for _, id := range old {
+        if !slices.Contains(new, id) {
+            removed = append(removed, id)
+        }
+    }
+
+    return added, removed
+}
+
+// WatchConsoles sends the current consoles, all of them as added, and then
+// every hotplug change, until ctx is done. The channel is closed afterwards.
+// ConsoleIDs, NumConsoles and GetConsole follow the changes while watching.
+func (vm *VM) WatchConsoles(ctx context.Context) (<-chan ConsolesEvent, error) {
+    changes := make(chan []uint32, 16)
+
+    done, err := watchProperties(ctx, vm.conn, vm.vm, vmIntf, func(changed map[string]dbus.Variant, invalidated []string) {
+        var ids []uint32
+        if v, ok := changed["ConsoleIDs"]; ok {
+            ids, ok = v.Value().([]uint32)
+            if !ok {
+                return
+            }
+        } else if slices.Contains(invalidated, "ConsoleIDs") {
+            v, err := getProp(vm.vm, vmConsoleIDs)
+            if err != nil {
+                return
+            }
+            ids = v.([]uint32)
+        } else {
+            return
+        }
+
+        select {
+        case changes <- ids:
+        case <-ctx.Done():
+        }
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    // Subscribe first, so that no change is lost between the two
+    cons, err := getProp(vm.vm, vmConsoleIDs)
+    if err != nil {
+        return nil, err
     }
+    initial := cons.([]uint32)
 
-    return newConsole(vm.conn, vm.consoleIDs[n])
+    events := make(chan ConsolesEvent)
+
+    go func() {
+        defer close(events)
+
+        var current []uint32
+        ids := initial
+        for first := true; ; first = false {
+            added, removed := diffIDs(current, ids)
+            current = ids
+
+            vm.mu.Lock()
+            vm.consoleIDs = ids
+            vm.mu.Unlock()
+
+            if first || len(added) > 0 || len(removed) > 0 {
+                select {
+                case events <- ConsolesEvent{slices.Clone(ids), added, removed}:
+                case <-ctx.Done():
+                    return
+                }
+            }
+
+            select {
+            case ids = <-changes:
+            case <-done:
+                return
+            }
+        }
+    }()
+
+    return events, nil
 }
 
 func (vm *VM) Close() {
-    vm.conn.Close()
+    vm.stopWatch()
+    if vm.ownConn {
+        vm.conn.Close()
+    }
 }
@@ -0,0 +1,67 @@
+package qemu_test
+
+import (
+    "context"
+    "syscall"
+    "testing"
+    "time"
+
+    "qemu"
+    "qemu/qemutest"
+)
+
+func TestConnectOutlivesContext(t *testing.T) {
+    srv, err := qemutest.NewServer(qemutest.VM{Name: "outlive"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer srv.Close()
+
+    ctx, cancel := context.WithCancel(context.Background())
+    vm, err := qemu.Connect(ctx, qemu.WithAddress(srv.Address()))
+    cancel()
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer vm.Close()
+
+    select {
+    case <-vm.Disconnected():
+        t.Fatal("disconnected when the context was canceled")
+    case <-time.After(100 * time.Millisecond):
+    }
+
+    if _, err := vm.GetConsole(0); err != nil {
+        t.Errorf("console lookup after cancel: %v", err)
+    }
+}
+
+func TestConnectCanceled(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+
+    // Nobody listens there, but the context is checked first or meanwhile
+    _, err := qemu.Connect(ctx, qemu.WithAddress("unix:path=/nonexistent/qemutest"))
+    if err == nil {
+        t.Error("connected with a canceled context")
+    }
+}
+
+func TestConnectClosesFD(t *testing.T) {
+    fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer syscall.Close(fds[1])
+
+    _, err = qemu.Connect(context.Background(), qemu.WithFD(fds[0]), qemu.WithAddress("unix:path=/nonexistent/qemutest"))
+    if err == nil {
+        t.Fatal("connected with both WithFD and WithAddress")
+    }
+
+    // The other end sees the fd handed over closed
+    n, err := syscall.Read(fds[1], make([]byte, 1))
+    if n != 0 || err != nil {
+        t.Errorf("fd given with WithFD left open: read returned %d, %v", n, err)
+    }
+}
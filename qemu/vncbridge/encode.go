@@ -0,0 +1,344 @@
+package vncbridge
+
+import (
+    "bytes"
+    "compress/zlib"
+    "encoding/binary"
+    "image"
+)
+
+const (
+    encodingRaw         int32 = 0
+    encodingCopyRect    int32 = 1
+    encodingTight       int32 = 7
+    encodingZRLE        int32 = 16
+    encodingCursor      int32 = -239
+    encodingDesktopSize int32 = -223
+)
+
+const zrleTile = 64
+
+// Tight rectangles are split to fit the limits of the TightVNC server, and
+// data shorter than tightMinToCompress is sent uncompressed.
+const (
+    tightMaxWidth      = 2048
+    tightMaxSize       = 65536
+    tightMinToCompress = 12
+)
+
+// Tight compression control byte and basic compression filters. JPEG is
+// never used, it is lossy.
+const (
+    tightFill           = 0x80
+    tightExplicitFilter = 0x40
+    tightFilterPalette  = 1
+    tightMaxColours     = 256
+)
+
+// The zlib streams Tight data goes through, one for each kind of data
+const (
+    tightStreamFull    = 0
+    tightStreamMono    = 1
+    tightStreamIndexed = 2
+)
+
+// encoder turns parts of the frame into rectangles of one encoding. ZRLE
+// and Tight keep their zlib streams for the whole connection, as the RFB
+// spec wants.
+type encoder struct {
+    buf  bytes.Buffer
+    zbuf bytes.Buffer
+    z    *zlib.Writer
+    tz   [4]*zlib.Writer
+}
+
+func rectHeader(buf *bytes.Buffer, r image.Rectangle, encoding int32) {
+    var b [12]byte
+    binary.BigEndian.PutUint16(b[0:], uint16(r.Min.X))
+    binary.BigEndian.PutUint16(b[2:], uint16(r.Min.Y))
+    binary.BigEndian.PutUint16(b[4:], uint16(r.Dx()))
+    binary.BigEndian.PutUint16(b[6:], uint16(r.Dy()))
+    binary.BigEndian.PutUint32(b[8:], uint32(encoding))
+    buf.Write(b[:])
+}
+
+// pixels converts r of img, row by row.
+func pixels(img *image.RGBA, r image.Rectangle, pf PixelFormat) []uint32 {
+    px := make([]uint32, 0, r.Dx()*r.Dy())
+    for y := r.Min.Y; y < r.Max.Y; y++ {
+        row := img.Pix[img.PixOffset(r.Min.X, y):]
+        for x := 0; x < r.Dx(); x++ {
+            px = append(px, pf.pixel(row[x*4], row[x*4+1], row[x*4+2]))
+        }
+    }
+    return px
+}
+
+func (e *encoder) raw(img *image.RGBA, r image.Rectangle, pf PixelFormat) {
+    rectHeader(&e.buf, r, encodingRaw)
+
+    bpp := pf.bytes()
+    b := make([]byte, bpp)
+    for _, v := range pixels(img, r, pf) {
+        pf.put(b, v)
+        e.buf.Write(b)
+    }
+}
+
+func (e *encoder) zrle(img *image.RGBA, r image.Rectangle, pf PixelFormat) error {
+    if e.z == nil {
+        e.z = zlib.NewWriter(&e.zbuf)
+    }
+
+    var tile bytes.Buffer
+    for y := r.Min.Y; y < r.Max.Y; y += zrleTile {
+        for x := r.Min.X; x < r.Max.X; x += zrleTile {
+            t := image.Rect(x, y, x+zrleTile, y+zrleTile).Intersect(r)
+            zrleTileData(&tile, pixels(img, t, pf), t.Dx(), pf)
+        }
+    }
+
+    e.zbuf.Reset()
+    if _, err := e.z.Write(tile.Bytes()); err != nil {
+        return err
+    }
+    if err := e.z.Flush(); err != nil {
+        return err
+    }
+
+    rectHeader(&e.buf, r, encodingZRLE)
+    var n [4]byte
+    binary.BigEndian.PutUint32(n[:], uint32(e.zbuf.Len()))
+    e.buf.Write(n[:])
+    e.buf.Write(e.zbuf.Bytes())
+
+    return nil
+}
+
+// zrleTileData writes a tile as a solid colour, a packed palette of up to
+// 16 colours or raw CPIXELs, whichever applies first.
+func zrleTileData(buf *bytes.Buffer, px []uint32, width int, pf PixelFormat) {
+    start, end := pf.compact()
+    b := make([]byte, 4)
+    cpixel := func(v uint32) {
+        pf.put(b, v)
+        buf.Write(b[start:end])
+    }
+
+    var palette []uint32
+    index := map[uint32]int{}
+    for _, v := range px {
+        if _, ok := index[v]; ok {
+            continue
+        }
+        if len(palette) == 16 {
+            palette = nil
+            break
+        }
+        index[v] = len(palette)
+        palette = append(palette, v)
+    }
+
+    switch {
+    case len(palette) == 1:
+        buf.WriteByte(1)
+        cpixel(palette[0])
+
+    case len(palette) > 1:
+        buf.WriteByte(byte(len(palette)))
+        for _, v := range palette {
+            cpixel(v)
+        }
+
+        bits := 4
+        if len(palette) <= 2 {
+            bits = 1
+        } else if len(palette) <= 4 {
+            bits = 2
+        }
+
+        // Rows start on a byte
+        for row := 0; row < len(px); row += width {
+            var cur byte
+            n := 0
+            for _, v := range px[row : row+width] {
+                cur = cur<<bits | byte(index[v])
+                n += bits
+                if n == 8 {
+                    buf.WriteByte(cur)
+                    cur, n = 0, 0
+                }
+            }
+            if n > 0 {
+                buf.WriteByte(cur << (8 - n))
+            }
+        }
+
+    default:
+        buf.WriteByte(0)
+        for _, v := range px {
+            cpixel(v)
+        }
+    }
+}
+
+// tight writes r in rectangles Tight can take, and returns how many.
+func (e *encoder) tight(img *image.RGBA, r image.Rectangle, pf PixelFormat) (int, error) {
+    w := min(r.Dx(), tightMaxWidth)
+    h := max(tightMaxSize/w, 1)
+
+    n := 0
+    for y := r.Min.Y; y < r.Max.Y; y += h {
+        for x := r.Min.X; x < r.Max.X; x += w {
+            t := image.Rect(x, y, x+w, y+h).Intersect(r)
+            if err := e.tightRect(img, t, pf); err != nil {
+                return n, err
+            }
+            n++
+        }
+    }
+    return n, nil
+}
+
+// tightRect writes r as a fill if it is of a single colour, with a palette
+// if it has few, or as full colour pixels.
+func (e *encoder) tightRect(img *image.RGBA, r image.Rectangle, pf PixelFormat) error {
+    rectHeader(&e.buf, r, encodingTight)
+    px := pixels(img, r, pf)
+
+    var palette []uint32
+    index := map[uint32]int{}
+    for _, v := range px {
+        if _, ok := index[v]; ok {
+            continue
+        }
+        if len(palette) == tightMaxColours {
+            palette = nil
+            break
+        }
+        index[v] = len(palette)
+        palette = append(palette, v)
+    }
+
+    var data bytes.Buffer
+    switch {
+    case len(palette) == 1:
+        e.buf.WriteByte(tightFill)
+        pf.putTight(&e.buf, palette[0])
+        return nil
+
+    case len(palette) == 2:
+        e.buf.WriteByte(tightStreamMono<<4 | tightExplicitFilter)
+        e.buf.WriteByte(tightFilterPalette)
+        e.buf.WriteByte(1)
+        pf.putTight(&e.buf, palette[0])
+        pf.putTight(&e.buf, palette[1])
+
+        // A bit per pixel, rows start on a byte
+        width := r.Dx()
+        for row := 0; row < len(px); row += width {
+            var cur byte
+            for i, v := range px[row : row+width] {
+                cur |= byte(index[v]) << (7 - i%8)
+                if i%8 == 7 {
+                    data.WriteByte(cur)
+                    cur = 0
+                }
+            }
+            if width%8 != 0 {
+                data.WriteByte(cur)
+            }
+        }
+        return e.tightData(tightStreamMono, data.Bytes())
+
+    case len(palette) > 2 && len(palette) <= len(px)/2:
+        e.buf.WriteByte(tightStreamIndexed<<4 | tightExplicitFilter)
+        e.buf.WriteByte(tightFilterPalette)
+        e.buf.WriteByte(byte(len(palette) - 1))
+        for _, v := range palette {
+            pf.putTight(&e.buf, v)
+        }
+
+        for _, v := range px {
+            data.WriteByte(byte(index[v]))
+        }
+        return e.tightData(tightStreamIndexed, data.Bytes())
+
+    default:
+        // No explicit filter is the copy filter
+        e.buf.WriteByte(tightStreamFull << 4)
+        for _, v := range px {
+            pf.putTight(&data, v)
+        }
+        return e.tightData(tightStreamFull, data.Bytes())
+    }
+}
+
+// tightData writes data as is if it is short, otherwise compressed through
+// stream after its compact length.
+func (e *encoder) tightData(stream int, data []byte) error {
+    if len(data) < tightMinToCompress {
+        e.buf.Write(data)
+        return nil
+    }
+
+    if e.tz[stream] == nil {
+        e.tz[stream] = zlib.NewWriter(&e.zbuf)
+    }
+
+    e.zbuf.Reset()
+    if _, err := e.tz[stream].Write(data); err != nil {
+        return err
+    }
+    if err := e.tz[stream].Flush(); err != nil {
+        return err
+    }
+
+    // 7 bits a byte, the lowest first, the top bit set if more follow
+    n := e.zbuf.Len()
+    for {
+        b := byte(n & 0x7f)
+        n >>= 7
+        if n == 0 {
+            e.buf.WriteByte(b)
+            break
+        }
+        e.buf.WriteByte(b | 0x80)
+    }
+    e.buf.Write(e.zbuf.Bytes())
+
+    return nil
+}
+
+// cursor sends the cursor shape, or an empty one to hide it. Pixels are
+// shown where the alpha is at least half.
+func (e *encoder) cursor(img *image.NRGBA, hot image.Point, pf PixelFormat) {
+    if img == nil {
+        rectHeader(&e.buf, image.Rectangle{}, encodingCursor)
+        return
+    }
+
+    r := img.Rect
+    rectHeader(&e.buf, image.Rect(hot.X, hot.Y, hot.X+r.Dx(), hot.Y+r.Dy()), encodingCursor)
+
+    b := make([]byte, pf.bytes())
+    stride := (r.Dx() + 7) / 8
+    mask := make([]byte, stride*r.Dy())
+
+    for y := 0; y < r.Dy(); y++ {
+        for x := 0; x < r.Dx(); x++ {
+            c := img.NRGBAAt(r.Min.X+x, r.Min.Y+y)
+            pf.put(b, pf.pixel(c.R, c.G, c.B))
+            e.buf.Write(b)
+
+            if c.A >= 0x80 {
+                mask[y*stride+x/8] |= 0x80 >> (x % 8)
+            }
+        }
+    }
+    e.buf.Write(mask)
+}
+
+func (e *encoder) desktopSize(size image.Point) {
+    rectHeader(&e.buf, image.Rectangle{Max: size}, encodingDesktopSize)
+}
@@ -0,0 +1,75 @@
+package vncbridge
+
+import (
+    "image"
+
+    "qemu"
+    "qemu/keymap"
+)
+
+// RFB pointer button bits, in mask order
+var buttons = []qemu.MouseButton{
+    qemu.ButtonLeft,
+    qemu.ButtonMiddle,
+    qemu.ButtonRight,
+    qemu.ButtonWheelUp,
+    qemu.ButtonWheelDown,
+    qemu.ButtonWheelLeft,
+    qemu.ButtonWheelRight,
+}
+
+func (c *conn) key(keysym uint32, down bool) {
+    key, ok := keymap.LookupKeysymValue(keysym)
+    if !ok {
+        c.s.logf("VNC client %s: unknown keysym %#x\n", c.c.RemoteAddr(), keysym)
+        return
+    }
+
+    if down {
+        c.keys[key.Qnum] = true
+        c.s.keyboard.Press(key.Qnum)
+    } else if c.keys[key.Qnum] {
+        delete(c.keys, key.Qnum)
+        c.s.keyboard.Release(key.Qnum)
+    }
+}
+
+func (c *conn) pointerEvent(mask uint8, pos image.Point) {
+    mouse := c.s.mouse
+    if mouse.IsAbsolute() {
+        if !c.pointerMoved || pos != c.pointer {
+            mouse.SetAbsPosition(uint32(pos.X), uint32(pos.Y))
+        }
+    } else if c.pointerMoved && pos != c.pointer {
+        // The client has its own pointer, the guest one lags behind
+        d := pos.Sub(c.pointer)
+        mouse.RelMotion(int32(d.X), int32(d.Y))
+    }
+    c.pointer, c.pointerMoved = pos, true
+
+    for i, button := range buttons {
+        bit := uint8(1) << i
+        switch {
+        case mask&bit != 0 && c.buttons&bit == 0:
+            mouse.Press(button)
+        case mask&bit == 0 && c.buttons&bit != 0:
+            mouse.Release(button)
+        }
+    }
+    c.buttons = mask
+}
+
+// releaseInput lets go of what the client held when it left.
+func (c *conn) releaseInput() {
+    for qnum := range c.keys {
+        c.s.keyboard.Release(qnum)
+    }
+    c.keys = map[uint32]bool{}
+
+    for i, button := range buttons {
+        if c.buttons&(1<<i) != 0 {
+            c.s.mouse.Release(button)
+        }
+    }
+    c.buttons = 0
+}
@@ -0,0 +1,148 @@
+package vncbridge
+
+import (
+    "bytes"
+    "encoding/binary"
+    "fmt"
+)
+
+// PixelFormat is how a client wants its pixels, only true colour formats
+// are supported.
+type PixelFormat struct {
+    BPP        uint8
+    Depth      uint8
+    BigEndian  bool
+    TrueColour bool
+
+    RedMax, GreenMax, BlueMax       uint16
+    RedShift, GreenShift, BlueShift uint8
+}
+
+// DefaultPixelFormat is what clients get until they ask for another one
+var DefaultPixelFormat = PixelFormat{
+    BPP:        32,
+    Depth:      24,
+    TrueColour: true,
+    RedMax:     255,
+    GreenMax:   255,
+    BlueMax:    255,
+    RedShift:   16,
+    GreenShift: 8,
+    BlueShift:  0,
+}
+
+func parsePixelFormat(b []byte) PixelFormat {
+    return PixelFormat{
+        BPP:        b[0],
+        Depth:      b[1],
+        BigEndian:  b[2] != 0,
+        TrueColour: b[3] != 0,
+        RedMax:     binary.BigEndian.Uint16(b[4:]),
+        GreenMax:   binary.BigEndian.Uint16(b[6:]),
+        BlueMax:    binary.BigEndian.Uint16(b[8:]),
+        RedShift:   b[10],
+        GreenShift: b[11],
+        BlueShift:  b[12],
+    }
+}
+
+func (pf PixelFormat) marshal() []byte {
+    b := make([]byte, 16)
+    b[0], b[1] = pf.BPP, pf.Depth
+    if pf.BigEndian {
+        b[2] = 1
+    }
+    if pf.TrueColour {
+        b[3] = 1
+    }
+    binary.BigEndian.PutUint16(b[4:], pf.RedMax)
+    binary.BigEndian.PutUint16(b[6:], pf.GreenMax)
+    binary.BigEndian.PutUint16(b[8:], pf.BlueMax)
+    b[10], b[11], b[12] = pf.RedShift, pf.GreenShift, pf.BlueShift
+
+    return b
+}
+
+func (pf PixelFormat) validate() error {
+    if !pf.TrueColour {
+        return fmt.Errorf("colour map pixel formats are not supported")
+    }
+    if pf.BPP != 8 && pf.BPP != 16 && pf.BPP != 32 {
+        return fmt.Errorf("%d bits per pixel are not supported", pf.BPP)
+    }
+    return nil
+}
+
+func (pf PixelFormat) bytes() int {
+    return int(pf.BPP) / 8
+}
+
+func scale(c uint8, max uint16) uint32 {
+    return (uint32(c)*uint32(max) + 127) / 255
+}
+
+// pixel converts an 8 bit per channel colour.
+func (pf PixelFormat) pixel(r, g, b uint8) uint32 {
+    return scale(r, pf.RedMax)<<pf.RedShift |
+        scale(g, pf.GreenMax)<<pf.GreenShift |
+        scale(b, pf.BlueMax)<<pf.BlueShift
+}
+
+// put writes the bytes of pixel v.
+func (pf PixelFormat) put(dst []byte, v uint32) {
+    switch pf.BPP {
+    case 8:
+        dst[0] = uint8(v)
+    case 16:
+        if pf.BigEndian {
+            binary.BigEndian.PutUint16(dst, uint16(v))
+        } else {
+            binary.LittleEndian.PutUint16(dst, uint16(v))
+        }
+    default:
+        if pf.BigEndian {
+            binary.BigEndian.PutUint32(dst, v)
+        } else {
+            binary.LittleEndian.PutUint32(dst, v)
+        }
+    }
+}
+
+// putTight writes pixel v as a Tight TPIXEL: red, green and blue bytes for
+// 24 bit colour in 32 bit pixels, like put otherwise.
+func (pf PixelFormat) putTight(buf *bytes.Buffer, v uint32) {
+    if pf.BPP == 32 && pf.Depth == 24 && pf.RedMax == 255 && pf.GreenMax == 255 && pf.BlueMax == 255 {
+        buf.Write([]byte{byte(v >> pf.RedShift), byte(v >> pf.GreenShift), byte(v >> pf.BlueShift)})
+        return
+    }
+
+    b := make([]byte, 4)
+    pf.put(b, v)
+    buf.Write(b[:pf.bytes()])
+}
+
+// compact tells which bytes of a 32 bit pixel ZRLE sends as a CPIXEL. It
+// drops the unused byte if the colours fit in the other three.
+func (pf PixelFormat) compact() (start, end int) {
+    if pf.BPP != 32 || pf.Depth > 24 {
+        return 0, pf.bytes()
+    }
+
+    used := uint32(pf.RedMax)<<pf.RedShift | uint32(pf.GreenMax)<<pf.GreenShift | uint32(pf.BlueMax)<<pf.BlueShift
+
+    // Whether the unused byte comes first in memory
+    var first bool
+    switch {
+    case used&0xff000000 == 0:
+        first = pf.BigEndian
+    case used&0xff == 0:
+        first = !pf.BigEndian
+    default:
+        return 0, 4
+    }
+
+    if first {
+        return 1, 4
+    }
+    return 0, 3
+}
@@ -0,0 +1,593 @@
+// Package vncbridge serves a QEMU console to VNC clients.
+//
+// It speaks RFB 3.8, and the older 3.7 and 3.3, without authentication:
+// keep it on a trusted network or behind an SSH tunnel. The frames come
+// from a framebuffer.Framebuffer registered on the console and are sent
+// Raw, ZRLE or Tight encoded, the first of them the client lists. Tight
+// never uses JPEG, the frames stay lossless. The guest cursor goes with the
+// Cursor pseudo-encoding and resolution changes with DesktopSize. QEMU does
+// not tell when an area was moved, so CopyRect is accepted but never used.
+//
+// Key and pointer events of the clients go to the console keyboard and
+// mouse, keys are taken as the US layout keysyms.
+package vncbridge
+
+import (
+    "bufio"
+    "context"
+    "encoding/binary"
+    "fmt"
+    "image"
+    "io"
+    "net"
+    "sync"
+    "time"
+
+    "qemu"
+    "qemu/framebuffer"
+)
+
+const protocolVersion = "RFB 003.008\n"
+
+// securityNone is the only security type offered
+const securityNone = 1
+
+// Client to server messages
+const (
+    msgSetPixelFormat           = 0
+    msgSetEncodings             = 2
+    msgFramebufferUpdateRequest = 3
+    msgKeyEvent                 = 4
+    msgPointerEvent             = 5
+    msgClientCutText            = 6
+)
+
+// Server to client messages
+const msgFramebufferUpdate = 0
+
+// firstFrameTimeout is how long a new client waits for the console to show
+// something before it gets a blank screen of the console size
+const firstFrameTimeout = 2 * time.Second
+
+// maxCutText is the largest clipboard text read from a client
+const maxCutText = 1 << 20
+
+type Server struct {
+    // Logf gets the connections coming and going, if set
+    Logf func(format string, args ...interface{})
+
+    console  *qemu.Console
+    name     string
+    fb       *framebuffer.Framebuffer
+    keyboard *qemu.Keyboard
+    mouse    *qemu.Mouse
+
+    mu        sync.Mutex
+    closed    bool
+    listeners map[net.Listener]struct{}
+    conns     map[net.Conn]struct{}
+}
+
+// New serves console under name, which clients show as the desktop name.
+func New(console *qemu.Console, name string) (*Server, error) {
+    keyboard, err := console.GetKeyboard()
+    if err != nil {
+        return nil, err
+    }
+
+    mouse, err := console.GetMouse()
+    if err != nil {
+        return nil, err
+    }
+
+    s := &Server{
+        console:   console,
+        name:      name,
+        fb:        framebuffer.New(),
+        keyboard:  keyboard,
+        mouse:     mouse,
+        listeners: map[net.Listener]struct{}{},
+        conns:     map[net.Conn]struct{}{},
+    }
+
+    if err = console.RegisterListener(s.fb); err != nil {
+        return nil, err
+    }
+
+    return s, nil
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+    if s.Logf != nil {
+        s.Logf(format, args...)
+    }
+}
+
+// Serve accepts clients on l until it fails or the server is closed.
+func (s *Server) Serve(l net.Listener) error {
+    s.mu.Lock()
+    if s.closed {
+        s.mu.Unlock()
+        return net.ErrClosed
+    }
+    s.listeners[l] = struct{}{}
+    s.mu.Unlock()
+
+    defer func() {
+        s.mu.Lock()
+        delete(s.listeners, l)
+        s.mu.Unlock()
+    }()
+
+    for {
+        c, err := l.Accept()
+        if err != nil {
+            return err
+        }
+
+        go func() {
+            s.logf("VNC client %s connected\n", c.RemoteAddr())
+            err := s.ServeConn(c)
+            if err != nil && err != io.EOF {
+                s.logf("VNC client %s: %v\n", c.RemoteAddr(), err)
+            } else {
+                s.logf("VNC client %s disconnected\n", c.RemoteAddr())
+            }
+        }()
+    }
+}
+
+// ServeConn talks RFB on c until the client goes away, and closes it.
+func (s *Server) ServeConn(c net.Conn) error {
+    s.mu.Lock()
+    if s.closed {
+        s.mu.Unlock()
+        c.Close()
+        return net.ErrClosed
+    }
+    s.conns[c] = struct{}{}
+    s.mu.Unlock()
+
+    defer func() {
+        s.mu.Lock()
+        delete(s.conns, c)
+        s.mu.Unlock()
+        c.Close()
+    }()
+
+    cc := &conn{
+        s:    s,
+        c:    c,
+        r:    bufio.NewReader(c),
+        w:    bufio.NewWriter(c),
+        pf:   DefaultPixelFormat,
+        keys: map[uint32]bool{},
+    }
+    return cc.serve()
+}
+
+// Close disconnects every client and stops listening on the console, only
+// the first call does anything.
+func (s *Server) Close() error {
+    s.mu.Lock()
+    if s.closed {
+        s.mu.Unlock()
+        return nil
+    }
+    s.closed = true
+    for l := range s.listeners {
+        l.Close()
+    }
+    for c := range s.conns {
+        c.Close()
+    }
+    s.mu.Unlock()
+
+    s.console.UnregisterListener(s.fb)
+    return s.fb.Close()
+}
+
+type updateRequest struct {
+    incremental bool
+    rect        image.Rectangle
+}
+
+type setEncodings []int32
+
+// conn is one client. Reading and writing run apart, the reader hands what
+// changes the updates to the writer through msgs.
+type conn struct {
+    s *Server
+    c net.Conn
+    r *bufio.Reader
+    w *bufio.Writer
+
+    // Used by the writer only
+    pf          PixelFormat
+    size        image.Point
+    encoding    int32
+    cursor      bool
+    desktopSize bool
+    enc         encoder
+
+    // Used by the reader only
+    keys         map[uint32]bool
+    buttons      uint8
+    pointer      image.Point
+    pointerMoved bool
+}
+
+func (c *conn) serve() error {
+    if err := c.handshake(); err != nil {
+        return err
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    damage := c.s.fb.Watch(ctx)
+
+    // Wait for something to show, the first damage covers the whole frame
+    var first framebuffer.Damage
+    select {
+    case first = <-damage:
+    case <-time.After(firstFrameTimeout):
+    }
+
+    c.size = c.s.fb.Size()
+    if c.size == (image.Point{}) {
+        c.size = image.Pt(int(c.s.console.Width()), int(c.s.console.Height()))
+    }
+
+    if err := c.serverInit(); err != nil {
+        return err
+    }
+
+    msgs := make(chan interface{})
+    readErr := make(chan error, 1)
+    go func() {
+        readErr <- c.readMessages(ctx, msgs)
+        cancel()
+    }()
+    defer c.releaseInput()
+
+    err := c.writeUpdates(ctx, first, damage, msgs)
+    cancel()
+
+    // Unblock the reader if the writer failed
+    c.c.Close()
+    if rerr := <-readErr; err == nil {
+        err = rerr
+    }
+    return err
+}
+
+func (c *conn) handshake() error {
+    if _, err := c.w.WriteString(protocolVersion); err != nil {
+        return err
+    }
+    if err := c.w.Flush(); err != nil {
+        return err
+    }
+
+    var version [12]byte
+    if _, err := io.ReadFull(c.r, version[:]); err != nil {
+        return err
+    }
+
+    var major, minor int
+    if _, err := fmt.Sscanf(string(version[:]), "RFB %03d.%03d\n", &major, &minor); err != nil || major != 3 {
+        return fmt.Errorf("unsupported protocol version %q", version)
+    }
+
+    if minor < 7 {
+        // 3.3: the server picks the security type
+        if err := binary.Write(c.w, binary.BigEndian, uint32(securityNone)); err != nil {
+            return err
+        }
+    } else {
+        c.w.Write([]byte{1, securityNone})
+        if err := c.w.Flush(); err != nil {
+            return err
+        }
+
+        sec, err := c.r.ReadByte()
+        if err != nil {
+            return err
+        }
+        if sec != securityNone {
+            return fmt.Errorf("client wants security type %d", sec)
+        }
+
+        if minor >= 8 {
+            if err := binary.Write(c.w, binary.BigEndian, uint32(0)); err != nil {
+                return err
+            }
+        }
+    }
+    if err := c.w.Flush(); err != nil {
+        return err
+    }
+
+    // ClientInit, every client shares the console anyway
+    _, err := c.r.ReadByte()
+    return err
+}
+
+func (c *conn) serverInit() error {
+    var b [4]byte
+    binary.BigEndian.PutUint16(b[0:], uint16(c.size.X))
+    binary.BigEndian.PutUint16(b[2:], uint16(c.size.Y))
+    c.w.Write(b[:])
+    c.w.Write(c.pf.marshal())
+
+    binary.Write(c.w, binary.BigEndian, uint32(len(c.s.name)))
+    c.w.WriteString(c.s.name)
+
+    return c.w.Flush()
+}
+
+// readMessages reads the client messages, handling the input events and
+// passing the rest to the writer.
+func (c *conn) readMessages(ctx context.Context, msgs chan<- interface{}) error {
+    send := func(msg interface{}) error {
+        select {
+        case msgs <- msg:
+            return nil
+        case <-ctx.Done():
+            return ctx.Err()
+        }
+    }
+
+    for {
+        t, err := c.r.ReadByte()
+        if err != nil {
+            return err
+        }
+
+        switch t {
+        case msgSetPixelFormat:
+            var b [19]byte
+            if _, err = io.ReadFull(c.r, b[:]); err != nil {
+                return err
+            }
+
+            pf := parsePixelFormat(b[3:])
+            if err = pf.validate(); err != nil {
+                return err
+            }
+            err = send(pf)
+
+        case msgSetEncodings:
+            var b [3]byte
+            if _, err = io.ReadFull(c.r, b[:]); err != nil {
+                return err
+            }
+
+            encodings := make(setEncodings, binary.BigEndian.Uint16(b[1:]))
+            if err = binary.Read(c.r, binary.BigEndian, encodings); err != nil {
+                return err
+            }
+            err = send(encodings)
+
+        case msgFramebufferUpdateRequest:
+            var b [9]byte
+            if _, err = io.ReadFull(c.r, b[:]); err != nil {
+                return err
+            }
+
+            x, y := int(binary.BigEndian.Uint16(b[1:])), int(binary.BigEndian.Uint16(b[3:]))
+            w, h := int(binary.BigEndian.Uint16(b[5:])), int(binary.BigEndian.Uint16(b[7:]))
+            err = send(updateRequest{b[0] != 0, image.Rect(x, y, x+w, y+h)})
+
+        case msgKeyEvent:
+            var b [7]byte
+            if _, err = io.ReadFull(c.r, b[:]); err != nil {
+                return err
+            }
+            c.key(binary.BigEndian.Uint32(b[3:]), b[0] != 0)
+
+        case msgPointerEvent:
+            var b [5]byte
+            if _, err = io.ReadFull(c.r, b[:]); err != nil {
+                return err
+            }
+            c.pointerEvent(b[0], image.Pt(int(binary.BigEndian.Uint16(b[1:])), int(binary.BigEndian.Uint16(b[3:]))))
+
+        case msgClientCutText:
+            var b [7]byte
+            if _, err = io.ReadFull(c.r, b[:]); err != nil {
+                return err
+            }
+
+            n := binary.BigEndian.Uint32(b[3:])
+            if n > maxCutText {
+                return fmt.Errorf("clipboard text of %d bytes is too long", n)
+            }
+            // The clipboard is not shared
+            _, err = c.r.Discard(int(n))
+
+        default:
+            return fmt.Errorf("unknown client message %d", t)
+        }
+
+        if err != nil {
+            return err
+        }
+    }
+}
+
+// writeUpdates answers the update requests with what changed since the
+// last update, or with the requested area if not incremental.
+func (c *conn) writeUpdates(ctx context.Context, first framebuffer.Damage, damage <-chan framebuffer.Damage, msgs <-chan interface{}) error {
+    var (
+        pending *updateRequest
+        dirty   = first.Rect
+        resized bool
+
+        sentCursor  framebuffer.Cursor
+        cursorSent  bool
+        cursorDirty = true
+    )
+
+    for {
+        select {
+        case d, ok := <-damage:
+            if !ok {
+                return nil
+            }
+            dirty = dirty.Union(d.Rect)
+            resized = resized || d.Resized
+            cursorDirty = cursorDirty || d.Cursor
+
+        case msg := <-msgs:
+            switch msg := msg.(type) {
+            case PixelFormat:
+                c.pf = msg
+            case setEncodings:
+                c.setEncodings(msg)
+                // The cursor may only now be wanted
+                cursorSent = false
+            case updateRequest:
+                pending = &msg
+                if !msg.incremental {
+                    dirty = dirty.Union(msg.rect)
+                }
+            }
+
+        case <-ctx.Done():
+            return nil
+        }
+
+        if pending == nil {
+            continue
+        }
+
+        img, _ := c.s.fb.Snapshot().(*image.RGBA)
+        if img == nil {
+            // Nothing shown yet, the client gets black
+            img = image.NewRGBA(image.Rectangle{Max: c.size})
+        }
+
+        size := img.Rect.Size()
+        var newSize bool
+        if resized && size != c.size && c.desktopSize {
+            c.size = size
+            newSize = true
+            dirty = image.Rectangle{Max: size}
+        }
+        resized = false
+
+        var cursor framebuffer.Cursor
+        var sendCursor bool
+        if cursorDirty && c.cursor {
+            cursor = c.s.fb.Cursor()
+            sendCursor = !cursorSent || !cursor.SameShape(sentCursor)
+        }
+        cursorDirty = false
+
+        // What the client cannot be shown is never sent
+        dirty = dirty.Intersect(image.Rectangle{Max: c.size}).Intersect(img.Rect)
+        area := dirty.Intersect(pending.rect)
+        if area.Empty() && !newSize && !sendCursor {
+            continue
+        }
+
+        if err := c.update(img, area, newSize, sendCursor, cursor); err != nil {
+            return err
+        }
+
+        if sendCursor {
+            sentCursor, cursorSent = cursor, true
+        }
+        dirty = without(dirty, area)
+        pending = nil
+    }
+}
+
+// without returns the bounding box of what is left of r once sent is taken
+// away, which is smaller than r only if sent covers it from side to side.
+func without(r, sent image.Rectangle) image.Rectangle {
+    s := sent.Intersect(r)
+    switch {
+    case s == r:
+        return image.Rectangle{}
+    case s.Empty():
+    case s.Min.X == r.Min.X && s.Max.X == r.Max.X:
+        if s.Min.Y == r.Min.Y {
+            r.Min.Y = s.Max.Y
+        } else if s.Max.Y == r.Max.Y {
+            r.Max.Y = s.Min.Y
+        }
+    case s.Min.Y == r.Min.Y && s.Max.Y == r.Max.Y:
+        if s.Min.X == r.Min.X {
+            r.Min.X = s.Max.X
+        } else if s.Max.X == r.Max.X {
+            r.Max.X = s.Min.X
+        }
+    }
+    return r
+}
+
+func (c *conn) setEncodings(encodings setEncodings) {
+    c.encoding = encodingRaw
+    c.cursor, c.desktopSize = false, false
+
+    chosen := false
+    for _, e := range encodings {
+        switch e {
+        case encodingRaw, encodingZRLE, encodingTight:
+            if !chosen {
+                c.encoding, chosen = e, true
+            }
+        case encodingCursor:
+            c.cursor = true
+        case encodingDesktopSize:
+            c.desktopSize = true
+        }
+    }
+}
+
+func (c *conn) update(img *image.RGBA, area image.Rectangle, newSize, sendCursor bool, cursor framebuffer.Cursor) error {
+    e := &c.enc
+    e.buf.Reset()
+
+    n := 0
+    if newSize {
+        e.desktopSize(c.size)
+        n++
+    }
+    if sendCursor {
+        if cursor.Visible {
+            e.cursor(cursor.Image, cursor.Hot, c.pf)
+        } else {
+            e.cursor(nil, image.Point{}, c.pf)
+        }
+        n++
+    }
+    if !area.Empty() {
+        switch c.encoding {
+        case encodingZRLE:
+            if err := e.zrle(img, area, c.pf); err != nil {
+                return err
+            }
+            n++
+        case encodingTight:
+            rects, err := e.tight(img, area, c.pf)
+            if err != nil {
+                return err
+            }
+            n += rects
+        default:
+            e.raw(img, area, c.pf)
+            n++
+        }
+    }
+
+    var b [4]byte
+    b[0] = msgFramebufferUpdate
+    binary.BigEndian.PutUint16(b[2:], uint16(n))
+    c.w.Write(b[:])
+    c.w.Write(e.buf.Bytes())
+
+    return c.w.Flush()
+}
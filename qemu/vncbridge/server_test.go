@@ -0,0 +1,720 @@
+package vncbridge_test
+
+import (
+    "bytes"
+    "compress/zlib"
+    "context"
+    "encoding/binary"
+    "fmt"
+    "image"
+    "io"
+    "net"
+    "slices"
+    "testing"
+    "time"
+
+    "qemu"
+    "qemu/keymap"
+    "qemu/qemutest"
+    "qemu/vncbridge"
+)
+
+const (
+    encodingRaw         = 0
+    encodingTight       = 7
+    encodingZRLE        = 16
+    encodingCursor      = -239
+    encodingDesktopSize = -223
+)
+
+// bridge is a vncbridge server on the console of a fake QEMU.
+type bridge struct {
+    qemu     *qemutest.Server
+    listener *qemutest.Listener
+    vnc      *vncbridge.Server
+}
+
+func newBridge(t *testing.T) *bridge {
+    t.Helper()
+
+    srv, err := qemutest.NewServer(qemutest.VM{Name: "vnctest"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { srv.Close() })
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    vm, err := qemu.Connect(ctx, qemu.WithAddress(srv.Address()))
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(vm.Close)
+
+    console, err := vm.GetConsole(0)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    s, err := vncbridge.New(console, vm.Name())
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { s.Close() })
+
+    l, err := srv.WaitListener(ctx, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    return &bridge{srv, l, s}
+}
+
+// frame is what the guest shows, as pixels of the default pixel format.
+type frame struct {
+    w, h int
+    px   []uint32
+}
+
+// scanout shows a new frame of w x h where the pixel at x, y is colour(x, y).
+func (b *bridge) scanout(t *testing.T, w, h int, colour func(x, y int) uint32) *frame {
+    t.Helper()
+
+    f := &frame{w, h, make([]uint32, w*h)}
+    data := make([]byte, w*h*4)
+    for y := 0; y < h; y++ {
+        for x := 0; x < w; x++ {
+            f.px[y*w+x] = colour(x, y)
+            binary.LittleEndian.PutUint32(data[(y*w+x)*4:], colour(x, y))
+        }
+    }
+
+    err := b.listener.Play(qemutest.Scanout{Width: uint32(w), Height: uint32(h), Stride: uint32(w * 4), Format: qemutest.FormatX8R8G8B8, Data: data})
+    if err != nil {
+        t.Fatal(err)
+    }
+    return f
+}
+
+// update changes r of the frame to colour(x, y).
+func (b *bridge) update(t *testing.T, f *frame, r image.Rectangle, colour func(x, y int) uint32) {
+    t.Helper()
+
+    data := make([]byte, 0, r.Dx()*r.Dy()*4)
+    for y := r.Min.Y; y < r.Max.Y; y++ {
+        for x := r.Min.X; x < r.Max.X; x++ {
+            f.px[y*f.w+x] = colour(x, y)
+            data = binary.LittleEndian.AppendUint32(data, colour(x, y))
+        }
+    }
+
+    err := b.listener.Play(qemutest.Update{X: int32(r.Min.X), Y: int32(r.Min.Y), Width: int32(r.Dx()), Height: int32(r.Dy()), Stride: uint32(r.Dx() * 4), Format: qemutest.FormatX8R8G8B8, Data: data})
+    if err != nil {
+        t.Fatal(err)
+    }
+}
+
+// client is a small RFB client, using the default pixel format.
+type client struct {
+    t    *testing.T
+    c    net.Conn
+    name string
+
+    w, h int
+    fb   []uint32
+
+    // The zlib streams of ZRLE and Tight
+    zrle  zstream
+    tight [4]zstream
+
+    cursor    []uint32
+    cursorHot image.Rectangle
+
+    // The rectangles of the last update
+    rects []image.Rectangle
+}
+
+type zstream struct {
+    src bytes.Buffer
+    r   io.ReadCloser
+}
+
+// inflate reads n bytes out of the stream, data being the next compressed
+// bytes of it.
+func (z *zstream) inflate(data []byte, n int) ([]byte, error) {
+    z.src.Write(data)
+    if z.r == nil {
+        r, err := zlib.NewReader(&z.src)
+        if err != nil {
+            return nil, err
+        }
+        z.r = r
+    }
+
+    b := make([]byte, n)
+    _, err := io.ReadFull(z.r, b)
+    return b, err
+}
+
+// dial connects a client with the given RFB version to b.
+func (b *bridge) dial(t *testing.T, version string) *client {
+    t.Helper()
+
+    server, c := net.Pipe()
+    go b.vnc.ServeConn(server)
+    t.Cleanup(func() { c.Close() })
+    c.SetDeadline(time.Now().Add(10 * time.Second))
+
+    cl := &client{t: t, c: c}
+    if got := string(cl.read(12)); got != "RFB 003.008\n" {
+        t.Fatalf("server version is %q", got)
+    }
+    cl.write([]byte(version))
+
+    if version == "RFB 003.003\n" {
+        if sec := cl.u32(); sec != 1 {
+            t.Fatalf("security type is %d, want None", sec)
+        }
+    } else {
+        if types := cl.read(2); !bytes.Equal(types, []byte{1, 1}) {
+            t.Fatalf("security types are %v, want None only", types)
+        }
+        cl.write([]byte{1})
+        if version == "RFB 003.008\n" {
+            if result := cl.u32(); result != 0 {
+                t.Fatalf("security result is %d", result)
+            }
+        }
+    }
+
+    // ClientInit, shared
+    cl.write([]byte{1})
+
+    cl.w, cl.h = cl.u16(), cl.u16()
+    if pf := cl.read(16); pf[0] != 32 || pf[1] != 24 {
+        t.Fatalf("pixel format is %v", pf)
+    }
+    cl.name = string(cl.read(int(cl.u32())))
+    cl.fb = make([]uint32, cl.w*cl.h)
+
+    return cl
+}
+
+func (c *client) read(n int) []byte {
+    c.t.Helper()
+
+    b := make([]byte, n)
+    if _, err := io.ReadFull(c.c, b); err != nil {
+        c.t.Fatal(err)
+    }
+    return b
+}
+
+func (c *client) write(b []byte) {
+    c.t.Helper()
+
+    if _, err := c.c.Write(b); err != nil {
+        c.t.Fatal(err)
+    }
+}
+
+func (c *client) u8() int     { return int(c.read(1)[0]) }
+func (c *client) u16() int    { return int(binary.BigEndian.Uint16(c.read(2))) }
+func (c *client) u32() uint32 { return binary.BigEndian.Uint32(c.read(4)) }
+
+func (c *client) setEncodings(encodings ...int32) {
+    b := []byte{2, 0}
+    b = binary.BigEndian.AppendUint16(b, uint16(len(encodings)))
+    for _, e := range encodings {
+        b = binary.BigEndian.AppendUint32(b, uint32(e))
+    }
+    c.write(b)
+}
+
+func (c *client) request(incremental bool) {
+    c.requestRect(incremental, image.Rect(0, 0, c.w, c.h))
+}
+
+func (c *client) requestRect(incremental bool, r image.Rectangle) {
+    b := []byte{3, 0}
+    if incremental {
+        b[1] = 1
+    }
+    for _, v := range []int{r.Min.X, r.Min.Y, r.Dx(), r.Dy()} {
+        b = binary.BigEndian.AppendUint16(b, uint16(v))
+    }
+    c.write(b)
+}
+
+func (c *client) key(keysym uint32, down bool) {
+    b := []byte{4, 0, 0, 0}
+    if down {
+        b[1] = 1
+    }
+    c.write(binary.BigEndian.AppendUint32(b, keysym))
+}
+
+func (c *client) pointer(mask uint8, x, y int) {
+    b := []byte{5, mask}
+    b = binary.BigEndian.AppendUint16(b, uint16(x))
+    c.write(binary.BigEndian.AppendUint16(b, uint16(y)))
+}
+
+// readUpdate decodes a FramebufferUpdate and returns the encodings of its
+// rectangles.
+func (c *client) readUpdate() []int32 {
+    c.t.Helper()
+
+    if msg := c.read(2)[0]; msg != 0 {
+        c.t.Fatalf("server message %d, want FramebufferUpdate", msg)
+    }
+
+    var encodings []int32
+    c.rects = nil
+    for n := c.u16(); n > 0; n-- {
+        var r image.Rectangle
+        r.Min = image.Pt(c.u16(), c.u16())
+        r.Max = r.Min.Add(image.Pt(c.u16(), c.u16()))
+        e := int32(c.u32())
+        encodings = append(encodings, e)
+        c.rects = append(c.rects, r)
+
+        var err error
+        switch e {
+        case encodingRaw:
+            for y := r.Min.Y; y < r.Max.Y; y++ {
+                for x := r.Min.X; x < r.Max.X; x++ {
+                    c.fb[y*c.w+x] = binary.LittleEndian.Uint32(c.read(4))
+                }
+            }
+        case encodingZRLE:
+            err = c.zrleRect(r)
+        case encodingTight:
+            err = c.tightRect(r)
+        case encodingCursor:
+            c.cursorHot = r
+            c.cursor = make([]uint32, r.Dx()*r.Dy())
+            for i := range c.cursor {
+                c.cursor[i] = binary.LittleEndian.Uint32(c.read(4))
+            }
+            mask := c.read((r.Dx() + 7) / 8 * r.Dy())
+            for i := range c.cursor {
+                x, y := i%r.Dx(), i/r.Dx()
+                if mask[y*((r.Dx()+7)/8)+x/8]&(0x80>>(x%8)) == 0 {
+                    c.cursor[i] = 0xffffffff
+                }
+            }
+        case encodingDesktopSize:
+            c.w, c.h = r.Dx(), r.Dy()
+            c.fb = make([]uint32, c.w*c.h)
+        default:
+            c.t.Fatalf("unexpected encoding %d", e)
+        }
+        if err != nil {
+            c.t.Fatalf("encoding %d: %v", e, err)
+        }
+    }
+    return encodings
+}
+
+func (c *client) zrleRect(r image.Rectangle) error {
+    data := c.read(int(c.u32()))
+    c.zrle.src.Write(data)
+
+    in := func(n int) ([]byte, error) {
+        return c.zrle.inflate(nil, n)
+    }
+    cpixel := func() (uint32, error) {
+        b, err := in(3)
+        if err != nil {
+            return 0, err
+        }
+        return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16, nil
+    }
+
+    for ty := r.Min.Y; ty < r.Max.Y; ty += 64 {
+        for tx := r.Min.X; tx < r.Max.X; tx += 64 {
+            tile := image.Rect(tx, ty, tx+64, ty+64).Intersect(r)
+
+            sub, err := in(1)
+            if err != nil {
+                return err
+            }
+
+            switch n := int(sub[0]); {
+            case n == 0:
+                for y := tile.Min.Y; y < tile.Max.Y; y++ {
+                    for x := tile.Min.X; x < tile.Max.X; x++ {
+                        if c.fb[y*c.w+x], err = cpixel(); err != nil {
+                            return err
+                        }
+                    }
+                }
+
+            case n <= 16:
+                palette := make([]uint32, n)
+                for i := range palette {
+                    if palette[i], err = cpixel(); err != nil {
+                        return err
+                    }
+                }
+
+                bits := 4
+                if n == 1 {
+                    bits = 0
+                } else if n == 2 {
+                    bits = 1
+                } else if n <= 4 {
+                    bits = 2
+                }
+
+                for y := tile.Min.Y; y < tile.Max.Y; y++ {
+                    row, err := in((tile.Dx()*bits + 7) / 8)
+                    if err != nil {
+                        return err
+                    }
+                    for x := 0; x < tile.Dx(); x++ {
+                        i := 0
+                        if bits > 0 {
+                            bit := x * bits
+                            i = int(row[bit/8]>>(8-bits-bit%8)) & (1<<bits - 1)
+                        }
+                        c.fb[y*c.w+tile.Min.X+x] = palette[i]
+                    }
+                }
+
+            default:
+                return fmt.Errorf("unexpected ZRLE subencoding %d", n)
+            }
+        }
+    }
+    return nil
+}
+
+func (c *client) tightRect(r image.Rectangle) error {
+    tpixel := func(b []byte) uint32 {
+        return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+    }
+
+    // data reads n bytes of basic compression data through stream
+    data := func(stream, n int) ([]byte, error) {
+        if n < 12 {
+            return c.read(n), nil
+        }
+
+        length, shift := 0, 0
+        for i := 0; i < 3; i++ {
+            b := c.u8()
+            length |= (b & 0x7f) << shift
+            shift += 7
+            if b&0x80 == 0 {
+                break
+            }
+        }
+        return c.tight[stream].inflate(c.read(length), n)
+    }
+
+    fill := func(px func(x, y int) uint32) {
+        for y := 0; y < r.Dy(); y++ {
+            for x := 0; x < r.Dx(); x++ {
+                c.fb[(r.Min.Y+y)*c.w+r.Min.X+x] = px(x, y)
+            }
+        }
+    }
+
+    control := c.u8()
+    if control&0x0f != 0 {
+        return fmt.Errorf("unexpected stream reset %#x", control)
+    }
+
+    switch {
+    case control == 0x80:
+        v := tpixel(c.read(3))
+        fill(func(x, y int) uint32 { return v })
+        return nil
+    case control&0x80 != 0:
+        return fmt.Errorf("unexpected compression %#x", control)
+    }
+
+    stream := control >> 4 & 3
+    filter := 0
+    if control&0x40 != 0 {
+        filter = c.u8()
+    }
+
+    switch filter {
+    case 0:
+        b, err := data(stream, r.Dx()*r.Dy()*3)
+        if err != nil {
+            return err
+        }
+        fill(func(x, y int) uint32 { return tpixel(b[(y*r.Dx()+x)*3:]) })
+
+    case 1:
+        palette := make([]uint32, c.u8()+1)
+        for i := range palette {
+            palette[i] = tpixel(c.read(3))
+        }
+
+        if len(palette) == 2 {
+            stride := (r.Dx() + 7) / 8
+            b, err := data(stream, stride*r.Dy())
+            if err != nil {
+                return err
+            }
+            fill(func(x, y int) uint32 { return palette[b[y*stride+x/8]>>(7-x%8)&1] })
+        } else {
+            b, err := data(stream, r.Dx()*r.Dy())
+            if err != nil {
+                return err
+            }
+            fill(func(x, y int) uint32 { return palette[b[y*r.Dx()+x]] })
+        }
+
+    default:
+        return fmt.Errorf("unexpected filter %d", filter)
+    }
+    return nil
+}
+
+// check compares what the client shows with f.
+func (c *client) check(f *frame) {
+    c.t.Helper()
+
+    if c.w != f.w || c.h != f.h {
+        c.t.Fatalf("client shows %dx%d, want %dx%d", c.w, c.h, f.w, f.h)
+    }
+    for i, v := range f.px {
+        if got := c.fb[i] & 0xffffff; got != v&0xffffff {
+            c.t.Fatalf("pixel %d,%d is %#06x, want %#06x", i%f.w, i/f.w, got, v&0xffffff)
+        }
+    }
+}
+
+// gradient has a different colour for every pixel of frames up to 4096
+// pixels wide.
+func gradient(x, y int) uint32 {
+    return uint32(x)&0xff | uint32(x>>8)<<8 | uint32(y)<<12
+}
+
+func TestHandshake(t *testing.T) {
+    b := newBridge(t)
+    b.scanout(t, 32, 16, gradient)
+
+    for _, version := range []string{"RFB 003.003\n", "RFB 003.007\n", "RFB 003.008\n"} {
+        c := b.dial(t, version)
+        if c.w != 32 || c.h != 16 || c.name != "vnctest" {
+            t.Errorf("%q: ServerInit is %dx%d %q", version, c.w, c.h, c.name)
+        }
+    }
+}
+
+func TestEncodings(t *testing.T) {
+    for _, encoding := range []int32{encodingRaw, encodingZRLE, encodingTight} {
+        t.Run(fmt.Sprint(encoding), func(t *testing.T) {
+            b := newBridge(t)
+
+            // Wider than a Tight rectangle may be
+            f := b.scanout(t, 2100, 40, gradient)
+
+            c := b.dial(t, "RFB 003.008\n")
+            c.setEncodings(encoding)
+            c.request(false)
+            if got := c.readUpdate(); got[0] != encoding {
+                t.Fatalf("update is in %v, want %d", got, encoding)
+            }
+            c.check(f)
+
+            colours := []uint32{0x102030, 0xffffff, 0x000080}
+            updates := []struct {
+                rect   image.Rectangle
+                colour func(x, y int) uint32
+            }{
+                // A palette of three
+                {image.Rect(5, 5, 25, 15), func(x, y int) uint32 { return colours[(x+y)%3] }},
+                // Two colours
+                {image.Rect(100, 0, 170, 40), func(x, y int) uint32 { return colours[(x/3+y)%2] }},
+                // Two colours, too few to compress
+                {image.Rect(0, 30, 3, 32), func(x, y int) uint32 { return colours[x%2] }},
+                // A single colour
+                {image.Rect(300, 10, 400, 30), func(x, y int) uint32 { return colours[2] }},
+                // Full colour again
+                {image.Rect(2000, 0, 2100, 40), func(x, y int) uint32 { return gradient(y, x) }},
+            }
+            for _, u := range updates {
+                b.update(t, f, u.rect, u.colour)
+                c.request(true)
+                c.readUpdate()
+                c.check(f)
+            }
+        })
+    }
+}
+
+func TestPartialRequest(t *testing.T) {
+    b := newBridge(t)
+    f := b.scanout(t, 64, 32, gradient)
+
+    c := b.dial(t, "RFB 003.008\n")
+    c.setEncodings(encodingRaw)
+    c.request(false)
+    c.readUpdate()
+
+    b.update(t, f, image.Rect(0, 0, 64, 8), func(x, y int) uint32 { return 0xff0000 })
+    b.update(t, f, image.Rect(0, 24, 64, 32), func(x, y int) uint32 { return 0x00ff00 })
+
+    // The top half is sent now, and not again with the rest
+    c.requestRect(true, image.Rect(0, 0, 64, 16))
+    c.readUpdate()
+    c.request(true)
+    c.readUpdate()
+    if len(c.rects) != 1 || c.rects[0].Min.Y < 16 {
+        t.Errorf("update after the top half has %v", c.rects)
+    }
+    c.check(f)
+
+    // A larger frame is cut to the size of the client, which cannot be
+    // resized, and what is cut off is not sent again and again
+    f = b.scanout(t, 80, 40, gradient)
+    c.request(true)
+    c.readUpdate()
+    if len(c.rects) != 1 || c.rects[0] != image.Rect(0, 0, 64, 32) {
+        t.Errorf("update after the resize has %v", c.rects)
+    }
+
+    b.update(t, f, image.Rect(1, 1, 3, 3), func(x, y int) uint32 { return 0x0000ff })
+    c.request(true)
+    c.readUpdate()
+    if len(c.rects) != 1 || c.rects[0] != image.Rect(1, 1, 3, 3) {
+        t.Errorf("update of 1,1-3,3 has %v", c.rects)
+    }
+}
+
+func TestPseudoEncodings(t *testing.T) {
+    b := newBridge(t)
+    b.scanout(t, 32, 16, gradient)
+
+    c := b.dial(t, "RFB 003.008\n")
+    c.setEncodings(encodingRaw, encodingCursor, encodingDesktopSize)
+    c.request(false)
+    c.readUpdate()
+
+    // A8R8G8B8: opaque red, transparent
+    err := b.listener.Play(
+        qemutest.CursorDefine{Width: 2, Height: 1, HotX: 1, HotY: 0, Data: []byte{0, 0, 0xff, 0xff, 0, 0, 0, 0}},
+        qemutest.MouseSet{X: 4, Y: 4, On: 1},
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    // An empty cursor comes first, the guest has not shown one yet
+    for len(c.cursor) == 0 {
+        c.request(true)
+        c.readUpdate()
+    }
+    if c.cursorHot != image.Rect(1, 0, 3, 1) {
+        t.Errorf("cursor rectangle is %v, want hot spot 1,0 and size 2x1", c.cursorHot)
+    }
+    if want := []uint32{0xff0000, 0xffffffff}; !slices.Equal(c.cursor, want) {
+        t.Errorf("cursor is %x, want %x", c.cursor, want)
+    }
+
+    f := b.scanout(t, 48, 24, gradient)
+    for c.w != 48 {
+        c.request(true)
+        c.readUpdate()
+    }
+    if c.h != 24 {
+        t.Fatalf("client resized to %dx%d, want 48x24", c.w, c.h)
+    }
+    // The new frame follows the new size
+    for {
+        c.request(false)
+        c.readUpdate()
+        if c.fb[len(c.fb)-1]&0xffffff == f.px[len(f.px)-1] {
+            break
+        }
+    }
+    c.check(f)
+}
+
+// waitCalls waits until srv got n input calls and returns them.
+func waitCalls(t *testing.T, srv *qemutest.Server, n int) []qemutest.Call {
+    t.Helper()
+
+    deadline := time.Now().Add(5 * time.Second)
+    for {
+        calls := srv.Calls()
+        if len(calls) >= n {
+            return calls
+        }
+        if time.Now().After(deadline) {
+            t.Fatalf("got %d input calls, want %d: %v", len(calls), n, calls)
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
+
+func TestInput(t *testing.T) {
+    b := newBridge(t)
+    b.scanout(t, 32, 16, gradient)
+
+    c := b.dial(t, "RFB 003.008\n")
+    b.qemu.ResetCalls()
+
+    a, _ := keymap.LookupQCode("a")
+    ctrl, _ := keymap.LookupQCode("ctrl")
+
+    c.key('a', true)
+    c.key('a', false)
+    // Releasing a key which is not down is ignored
+    c.key(0xff0d, false)
+    c.pointer(1, 10, 20)
+    c.pointer(0, 10, 20)
+    // Held when the client leaves
+    c.key(0xffe3, true)
+
+    want := []qemutest.Call{
+        {Method: "org.qemu.Display1.Keyboard.Press", Args: []interface{}{a.Qnum}},
+        {Method: "org.qemu.Display1.Keyboard.Release", Args: []interface{}{a.Qnum}},
+        {Method: "org.qemu.Display1.Mouse.SetAbsPosition", Args: []interface{}{uint32(10), uint32(20)}},
+        {Method: "org.qemu.Display1.Mouse.Press", Args: []interface{}{uint32(qemu.ButtonLeft)}},
+        {Method: "org.qemu.Display1.Mouse.Release", Args: []interface{}{uint32(qemu.ButtonLeft)}},
+        {Method: "org.qemu.Display1.Keyboard.Press", Args: []interface{}{ctrl.Qnum}},
+    }
+    got := waitCalls(t, b.qemu, len(want))
+
+    c.c.Close()
+    want = append(want, qemutest.Call{Method: "org.qemu.Display1.Keyboard.Release", Args: []interface{}{ctrl.Qnum}})
+    got = waitCalls(t, b.qemu, len(want))
+
+    if len(got) != len(want) {
+        t.Fatalf("got %v, want %v", got, want)
+    }
+    for i := range want {
+        if got[i].Method != want[i].Method || fmt.Sprint(got[i].Args) != fmt.Sprint(want[i].Args) {
+            t.Errorf("call %d is %s%v, want %s%v", i, got[i].Method, got[i].Args, want[i].Method, want[i].Args)
+        }
+    }
+}
+
+func TestCloseTwice(t *testing.T) {
+    b := newBridge(t)
+    b.scanout(t, 32, 16, gradient)
+    c := b.dial(t, "RFB 003.008\n")
+
+    errs := make(chan error, 2)
+    for range 2 {
+        go func() { errs <- b.vnc.Close() }()
+    }
+    for range 2 {
+        if err := <-errs; err != nil {
+            t.Errorf("Close returned %v", err)
+        }
+    }
+
+    if _, err := c.c.Read(make([]byte, 1)); err == nil {
+        t.Error("the client is still connected after Close")
+    }
+}
@@ -0,0 +1,460 @@
+// Package webbridge shows a QEMU console in a web browser.
+//
+// A Server is an http.Handler serving a small HTML5 canvas viewer and a
+// WebSocket, over which the changed parts of the screen go to the browser
+// as PNG tiles and keyboard and mouse events come back. Every request must
+// carry the token of the server as ?token=, which is all the
+// authentication there is: beyond localhost put it behind HTTPS or an SSH
+// tunnel.
+package webbridge
+
+import (
+    "bytes"
+    "context"
+    "crypto/rand"
+    "crypto/subtle"
+    _ "embed"
+    "encoding/base64"
+    "encoding/binary"
+    "encoding/hex"
+    "encoding/json"
+    "image"
+    "image/png"
+    "io"
+    "net/http"
+    "sync"
+
+    "qemu"
+    "qemu/framebuffer"
+    "qemu/keymap"
+)
+
+//go:embed index.html
+var indexHTML []byte
+
+// tileSize is the side of the squares the screen is sent in, only the
+// changed ones are sent again
+const tileSize = 64
+
+// DOM MouseEvent.button values
+var buttons = map[int]qemu.MouseButton{
+    0: qemu.ButtonLeft,
+    1: qemu.ButtonMiddle,
+    2: qemu.ButtonRight,
+    3: qemu.ButtonSide,
+    4: qemu.ButtonExtra,
+}
+
+type Server struct {
+    // Logf gets the connections coming and going, if set
+    Logf func(format string, args ...interface{})
+
+    console  *qemu.Console
+    name     string
+    token    string
+    fb       *framebuffer.Framebuffer
+    keyboard *qemu.Keyboard
+
+    // Mouse.Scroll keeps what is left of a wheel step
+    mouseMu sync.Mutex
+    mouse   *qemu.Mouse
+
+    mu     sync.Mutex
+    closed bool
+    conns  map[*websocket]struct{}
+}
+
+// NewToken returns a random token for New.
+func NewToken() (string, error) {
+    b := make([]byte, 16)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(b), nil
+}
+
+// New serves console under name, the browser shows it as the page title.
+// Requests without token are turned away.
+func New(console *qemu.Console, name, token string) (*Server, error) {
+    keyboard, err := console.GetKeyboard()
+    if err != nil {
+        return nil, err
+    }
+
+    mouse, err := console.GetMouse()
+    if err != nil {
+        return nil, err
+    }
+
+    s := &Server{
+        console:  console,
+        name:     name,
+        token:    token,
+        fb:       framebuffer.New(),
+        keyboard: keyboard,
+        mouse:    mouse,
+        conns:    map[*websocket]struct{}{},
+    }
+
+    if err = console.RegisterListener(s.fb); err != nil {
+        return nil, err
+    }
+
+    return s, nil
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+    if s.Logf != nil {
+        s.Logf(format, args...)
+    }
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    token := r.URL.Query().Get("token")
+    if s.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+        http.Error(w, "wrong or missing token", http.StatusForbidden)
+        return
+    }
+
+    switch r.URL.Path {
+    case "/":
+        w.Header().Set("Content-Type", "text/html; charset=utf-8")
+        w.Header().Set("Cache-Control", "no-store")
+        w.Write(indexHTML)
+    case "/ws":
+        s.serveWebsocket(w, r)
+    default:
+        http.NotFound(w, r)
+    }
+}
+
+// Close disconnects every browser and stops listening on the console, only
+// the first call does anything.
+func (s *Server) Close() error {
+    s.mu.Lock()
+    if s.closed {
+        s.mu.Unlock()
+        return nil
+    }
+    s.closed = true
+    for ws := range s.conns {
+        ws.conn.Close()
+    }
+    s.mu.Unlock()
+
+    s.console.UnregisterListener(s.fb)
+    return s.fb.Close()
+}
+
+func (s *Server) serveWebsocket(w http.ResponseWriter, r *http.Request) {
+    ws, err := upgrade(w, r)
+    if err != nil {
+        s.logf("Browser %s: %v\n", r.RemoteAddr, err)
+        return
+    }
+
+    s.mu.Lock()
+    if s.closed {
+        s.mu.Unlock()
+        ws.Close()
+        return
+    }
+    s.conns[ws] = struct{}{}
+    s.mu.Unlock()
+
+    defer func() {
+        s.mu.Lock()
+        delete(s.conns, ws)
+        s.mu.Unlock()
+        ws.Close()
+    }()
+
+    s.logf("Browser %s connected\n", r.RemoteAddr)
+
+    c := &client{s: s, ws: ws, keys: map[uint32]bool{}, buttons: map[qemu.MouseButton]bool{}}
+    err = c.serve()
+    if err != nil && err != io.EOF {
+        s.logf("Browser %s: %v\n", r.RemoteAddr, err)
+    } else {
+        s.logf("Browser %s disconnected\n", r.RemoteAddr)
+    }
+}
+
+// event is what the browser sends
+type event struct {
+    T      string  `json:"t"`
+    Code   string  `json:"code"`
+    Down   bool    `json:"down"`
+    X      int     `json:"x"`
+    Y      int     `json:"y"`
+    DX     float64 `json:"dx"`
+    DY     float64 `json:"dy"`
+    Button int     `json:"button"`
+}
+
+// client is one browser. The screen is sent in batches ending with "end",
+// the next one goes once the browser acked the last.
+type client struct {
+    s  *Server
+    ws *websocket
+
+    // Used by the reader only
+    keys    map[uint32]bool
+    buttons map[qemu.MouseButton]bool
+}
+
+func (c *client) sendJSON(v interface{}) error {
+    b, err := json.Marshal(v)
+    if err != nil {
+        return err
+    }
+    return c.ws.WriteText(b)
+}
+
+func (c *client) serve() error {
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    damage := c.s.fb.Watch(ctx)
+
+    // The page is told when the guest switches mouse mode, if QEMU can
+    // tell us
+    absolute := c.s.mouse.IsAbsolute()
+    modes, err := c.s.mouse.WatchAbsolute(ctx)
+    if err == nil {
+        absolute = <-modes
+    } else {
+        c.s.logf("Cannot watch the mouse mode: %v\n", err)
+    }
+
+    err = c.sendJSON(map[string]interface{}{"t": "init", "name": c.s.name, "absolute": absolute})
+    if err != nil {
+        return err
+    }
+
+    acks := make(chan struct{}, 1)
+    readErr := make(chan error, 1)
+    go func() {
+        readErr <- c.readEvents(acks)
+        cancel()
+    }()
+    defer c.releaseInput()
+
+    err = c.writeUpdates(ctx, damage, modes, acks)
+    cancel()
+
+    // Unblock the reader if the writer failed
+    c.ws.conn.Close()
+    if rerr := <-readErr; err == nil {
+        err = rerr
+    }
+    return err
+}
+
+func (c *client) readEvents(acks chan<- struct{}) error {
+    for {
+        op, msg, err := c.ws.ReadMessage()
+        if err != nil {
+            return err
+        }
+        if op != opText {
+            continue
+        }
+
+        var e event
+        if err = json.Unmarshal(msg, &e); err != nil {
+            return err
+        }
+
+        switch e.T {
+        case "ack":
+            select {
+            case acks <- struct{}{}:
+            default:
+            }
+        case "key":
+            c.key(e.Code, e.Down)
+        case "move":
+            if e.X >= 0 && e.Y >= 0 {
+                c.s.mouse.SetAbsPosition(uint32(e.X), uint32(e.Y))
+            }
+        case "rel":
+            c.s.mouse.RelMotion(int32(e.DX), int32(e.DY))
+        case "button":
+            c.button(e.Button, e.Down)
+        case "wheel":
+            c.s.mouseMu.Lock()
+            c.s.mouse.Scroll(e.DX, e.DY)
+            c.s.mouseMu.Unlock()
+        }
+    }
+}
+
+func (c *client) key(code string, down bool) {
+    key, ok := keymap.LookupDOMCode(code)
+    if !ok {
+        return
+    }
+
+    if down {
+        c.keys[key.Qnum] = true
+        c.s.keyboard.Press(key.Qnum)
+    } else if c.keys[key.Qnum] {
+        delete(c.keys, key.Qnum)
+        c.s.keyboard.Release(key.Qnum)
+    }
+}
+
+func (c *client) button(n int, down bool) {
+    button, ok := buttons[n]
+    if !ok {
+        return
+    }
+
+    if down {
+        c.buttons[button] = true
+        c.s.mouse.Press(button)
+    } else if c.buttons[button] {
+        delete(c.buttons, button)
+        c.s.mouse.Release(button)
+    }
+}
+
+// releaseInput lets go of what the browser held when it left.
+func (c *client) releaseInput() {
+    for qnum := range c.keys {
+        c.s.keyboard.Release(qnum)
+    }
+    for button := range c.buttons {
+        c.s.mouse.Release(button)
+    }
+}
+
+func (c *client) writeUpdates(ctx context.Context, damage <-chan framebuffer.Damage, modes <-chan bool, acks <-chan struct{}) error {
+    var (
+        last        *image.RGBA
+        dirty       image.Rectangle
+        resized     bool
+        cursorDirty bool
+        ready       = true
+
+        sentCursor framebuffer.Cursor
+    )
+
+    enc := &png.Encoder{CompressionLevel: png.BestSpeed}
+
+    for {
+        select {
+        case d, ok := <-damage:
+            if !ok {
+                return nil
+            }
+            dirty = dirty.Union(d.Rect)
+            resized = resized || d.Resized
+            cursorDirty = cursorDirty || d.Cursor
+        case absolute, ok := <-modes:
+            if !ok {
+                modes = nil
+                continue
+            }
+            if err := c.sendJSON(map[string]interface{}{"t": "mouse", "absolute": absolute}); err != nil {
+                return err
+            }
+        case <-acks:
+            ready = true
+        case <-ctx.Done():
+            return nil
+        }
+
+        if !ready || dirty.Empty() && !resized && !cursorDirty {
+            continue
+        }
+
+        img, _ := c.s.fb.Snapshot().(*image.RGBA)
+        if img == nil {
+            continue
+        }
+
+        if last == nil || last.Rect != img.Rect {
+            size := img.Rect.Size()
+            if err := c.sendJSON(map[string]interface{}{"t": "size", "w": size.X, "h": size.Y}); err != nil {
+                return err
+            }
+            last = nil
+            dirty = img.Rect
+        }
+
+        if err := c.sendTiles(enc, img, last, dirty.Intersect(img.Rect)); err != nil {
+            return err
+        }
+
+        if cursor := c.s.fb.Cursor(); cursorDirty && cursor.Image != nil && !cursor.SameShape(sentCursor) {
+            if err := c.sendCursor(cursor); err != nil {
+                return err
+            }
+            sentCursor = cursor
+        }
+
+        if err := c.sendJSON(map[string]string{"t": "end"}); err != nil {
+            return err
+        }
+
+        last = img
+        dirty, resized, cursorDirty, ready = image.Rectangle{}, false, false, false
+    }
+}
+
+// sendTiles sends the tiles touching r which differ from last.
+func (c *client) sendTiles(enc *png.Encoder, img, last *image.RGBA, r image.Rectangle) error {
+    var buf bytes.Buffer
+
+    for y := r.Min.Y / tileSize * tileSize; y < r.Max.Y; y += tileSize {
+        for x := r.Min.X / tileSize * tileSize; x < r.Max.X; x += tileSize {
+            t := image.Rect(x, y, x+tileSize, y+tileSize).Intersect(img.Rect)
+            if last != nil && sameTile(img, last, t) {
+                continue
+            }
+
+            buf.Reset()
+            var pos [4]byte
+            binary.BigEndian.PutUint16(pos[0:], uint16(t.Min.X))
+            binary.BigEndian.PutUint16(pos[2:], uint16(t.Min.Y))
+            buf.Write(pos[:])
+
+            if err := enc.Encode(&buf, img.SubImage(t)); err != nil {
+                return err
+            }
+            if err := c.ws.WriteBinary(buf.Bytes()); err != nil {
+                return err
+            }
+        }
+    }
+
+    return nil
+}
+
+func sameTile(a, b *image.RGBA, t image.Rectangle) bool {
+    for y := t.Min.Y; y < t.Max.Y; y++ {
+        i, j := a.PixOffset(t.Min.X, y), a.PixOffset(t.Max.X, y)
+        if !bytes.Equal(a.Pix[i:j], b.Pix[i:j]) {
+            return false
+        }
+    }
+    return true
+}
+
+// sendCursor sends the cursor as a PNG for CSS, or nothing to hide it.
+// Until the guest defines one the browser keeps its own.
+func (c *client) sendCursor(cursor framebuffer.Cursor) error {
+    msg := map[string]interface{}{"t": "cursor"}
+    if cursor.Visible {
+        var buf bytes.Buffer
+        if err := png.Encode(&buf, cursor.Image); err != nil {
+            return err
+        }
+
+        msg["png"] = base64.StdEncoding.EncodeToString(buf.Bytes())
+        msg["x"], msg["y"] = cursor.Hot.X, cursor.Hot.Y
+    }
+
+    return c.sendJSON(msg)
+}
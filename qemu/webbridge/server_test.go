@@ -0,0 +1,586 @@
+package webbridge_test
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "crypto/sha1"
+    "encoding/base64"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "image"
+    "image/color"
+    "image/draw"
+    "image/png"
+    "io"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "testing"
+    "time"
+
+    "qemu"
+    "qemu/keymap"
+    "qemu/qemutest"
+    "qemu/webbridge"
+)
+
+const token = "secret"
+
+const (
+    opContinuation = 0x0
+    opText         = 0x1
+    opBinary       = 0x2
+    opClose        = 0x8
+    opPing         = 0x9
+    opPong         = 0xa
+)
+
+// bridge is a webbridge server on the console of a fake QEMU, served over
+// HTTP.
+type bridge struct {
+    qemu     *qemutest.Server
+    listener *qemutest.Listener
+    http     *httptest.Server
+}
+
+func newBridge(t *testing.T) *bridge {
+    t.Helper()
+
+    srv, err := qemutest.NewServer(qemutest.VM{Name: "webtest"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { srv.Close() })
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    vm, err := qemu.Connect(ctx, qemu.WithAddress(srv.Address()))
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(vm.Close)
+
+    console, err := vm.GetConsole(0)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    s, err := webbridge.New(console, vm.Name(), token)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { s.Close() })
+
+    l, err := srv.WaitListener(ctx, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    ts := httptest.NewServer(s)
+    t.Cleanup(ts.Close)
+
+    return &bridge{srv, l, ts}
+}
+
+// scanout shows a new frame of w x h where the pixel at x, y is colour(x, y).
+func (b *bridge) scanout(t *testing.T, w, h int, colour func(x, y int) color.RGBA) *image.RGBA {
+    t.Helper()
+
+    img := image.NewRGBA(image.Rect(0, 0, w, h))
+    data := make([]byte, 0, w*h*4)
+    for y := 0; y < h; y++ {
+        for x := 0; x < w; x++ {
+            c := colour(x, y)
+            img.SetRGBA(x, y, c)
+            data = append(data, c.B, c.G, c.R, 0)
+        }
+    }
+
+    err := b.listener.Play(qemutest.Scanout{Width: uint32(w), Height: uint32(h), Stride: uint32(w * 4), Format: qemutest.FormatX8R8G8B8, Data: data})
+    if err != nil {
+        t.Fatal(err)
+    }
+    return img
+}
+
+// update changes r of img to colour(x, y).
+func (b *bridge) update(t *testing.T, img *image.RGBA, r image.Rectangle, colour func(x, y int) color.RGBA) {
+    t.Helper()
+
+    data := make([]byte, 0, r.Dx()*r.Dy()*4)
+    for y := r.Min.Y; y < r.Max.Y; y++ {
+        for x := r.Min.X; x < r.Max.X; x++ {
+            c := colour(x, y)
+            img.SetRGBA(x, y, c)
+            data = append(data, c.B, c.G, c.R, 0)
+        }
+    }
+
+    err := b.listener.Play(qemutest.Update{X: int32(r.Min.X), Y: int32(r.Min.Y), Width: int32(r.Dx()), Height: int32(r.Dy()), Stride: uint32(r.Dx() * 4), Format: qemutest.FormatX8R8G8B8, Data: data})
+    if err != nil {
+        t.Fatal(err)
+    }
+}
+
+func gradient(x, y int) color.RGBA {
+    return color.RGBA{byte(x * 2), byte(y * 3), byte(x ^ y), 0xff}
+}
+
+// get fetches path from the server and returns the status code.
+func (b *bridge) get(t *testing.T, path string) int {
+    t.Helper()
+
+    resp, err := http.Get(b.http.URL + path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer resp.Body.Close()
+    io.Copy(io.Discard, resp.Body)
+
+    return resp.StatusCode
+}
+
+// browser is the websocket end of a page, writing frames by hand.
+type browser struct {
+    t    *testing.T
+    conn net.Conn
+    r    *bufio.Reader
+}
+
+// handshake opens the websocket at path and returns the answer to the
+// upgrade request.
+func (b *bridge) handshake(t *testing.T, path string) (*browser, *http.Response) {
+    t.Helper()
+
+    conn, err := net.Dial("tcp", b.http.Listener.Addr().String())
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { conn.Close() })
+    conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+    key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+    fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+        "Sec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", path, b.http.Listener.Addr(), key)
+
+    r := bufio.NewReader(conn)
+    resp, err := http.ReadResponse(r, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if resp.StatusCode == http.StatusSwitchingProtocols {
+        sum := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+        if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != base64.StdEncoding.EncodeToString(sum[:]) {
+            t.Fatalf("handshake accepted with %q", accept)
+        }
+    }
+
+    return &browser{t, conn, r}, resp
+}
+
+// dial opens the websocket with the right token.
+func (b *bridge) dial(t *testing.T) *browser {
+    t.Helper()
+
+    c, resp := b.handshake(t, "/ws?token="+token)
+    if resp.StatusCode != http.StatusSwitchingProtocols {
+        t.Fatalf("handshake answered %s", resp.Status)
+    }
+    return c
+}
+
+// writeFrame sends a frame, which browsers always mask.
+func (c *browser) writeFrame(fin bool, op byte, payload []byte, masked bool) {
+    c.t.Helper()
+
+    h := []byte{op, 0}
+    if fin {
+        h[0] |= 0x80
+    }
+    switch {
+    case len(payload) < 126:
+        h[1] = byte(len(payload))
+    case len(payload) <= 0xffff:
+        h[1] = 126
+        h = binary.BigEndian.AppendUint16(h, uint16(len(payload)))
+    default:
+        h[1] = 127
+        h = binary.BigEndian.AppendUint64(h, uint64(len(payload)))
+    }
+
+    payload = bytes.Clone(payload)
+    if masked {
+        mask := []byte{0x12, 0x34, 0x56, 0x78}
+        h[1] |= 0x80
+        h = append(h, mask...)
+        for i := range payload {
+            payload[i] ^= mask[i%4]
+        }
+    }
+
+    if _, err := c.conn.Write(append(h, payload...)); err != nil {
+        c.t.Fatal(err)
+    }
+}
+
+func (c *browser) send(v interface{}) {
+    c.t.Helper()
+
+    b, err := json.Marshal(v)
+    if err != nil {
+        c.t.Fatal(err)
+    }
+    c.writeFrame(true, opText, b, true)
+}
+
+func (c *browser) readFrame() (op byte, payload []byte, err error) {
+    var h [2]byte
+    if _, err = io.ReadFull(c.r, h[:]); err != nil {
+        return
+    }
+    if h[0]&0x80 == 0 || h[1]&0x80 != 0 {
+        return 0, nil, fmt.Errorf("server sent a fragmented or masked frame: %x", h)
+    }
+
+    n := uint64(h[1])
+    switch n {
+    case 126:
+        var b [2]byte
+        if _, err = io.ReadFull(c.r, b[:]); err != nil {
+            return
+        }
+        n = uint64(binary.BigEndian.Uint16(b[:]))
+    case 127:
+        var b [8]byte
+        if _, err = io.ReadFull(c.r, b[:]); err != nil {
+            return
+        }
+        n = binary.BigEndian.Uint64(b[:])
+    }
+
+    payload = make([]byte, n)
+    if _, err = io.ReadFull(c.r, payload); err != nil {
+        return
+    }
+    return h[0] & 0x0f, payload, nil
+}
+
+func (c *browser) read() (op byte, payload []byte) {
+    c.t.Helper()
+
+    op, payload, err := c.readFrame()
+    if err != nil {
+        c.t.Fatal(err)
+    }
+    return op, payload
+}
+
+// expect reads a JSON message of type typ.
+func (c *browser) expect(typ string) map[string]interface{} {
+    c.t.Helper()
+
+    op, payload := c.read()
+    if op != opText {
+        c.t.Fatalf("got a frame of op %d, want a %q message", op, typ)
+    }
+
+    var msg map[string]interface{}
+    if err := json.Unmarshal(payload, &msg); err != nil {
+        c.t.Fatal(err)
+    }
+    if msg["t"] != typ {
+        c.t.Fatalf("got %s, want a %q message", payload, typ)
+    }
+    return msg
+}
+
+// tiles draws the tiles up to the next "end" onto img and returns where
+// they went.
+func (c *browser) tiles(img *image.RGBA) []image.Rectangle {
+    c.t.Helper()
+
+    var drawn []image.Rectangle
+    for {
+        op, payload := c.read()
+        if op == opText {
+            if !bytes.Contains(payload, []byte(`"t":"end"`)) {
+                c.t.Fatalf("got %s between tiles", payload)
+            }
+            return drawn
+        }
+        if op != opBinary || len(payload) < 4 {
+            c.t.Fatalf("got a frame of op %d and %d bytes, want a tile", op, len(payload))
+        }
+
+        tile, err := png.Decode(bytes.NewReader(payload[4:]))
+        if err != nil {
+            c.t.Fatal(err)
+        }
+        at := image.Pt(int(binary.BigEndian.Uint16(payload[0:])), int(binary.BigEndian.Uint16(payload[2:])))
+        r := tile.Bounds().Sub(tile.Bounds().Min).Add(at)
+        draw.Draw(img, r, tile, tile.Bounds().Min, draw.Src)
+        drawn = append(drawn, r)
+    }
+}
+
+// closed reads until the server closes the connection, skipping what it
+// sends on the way, and returns the payload of its close frame.
+func (c *browser) closed() []byte {
+    c.t.Helper()
+
+    var closing []byte
+    for {
+        op, payload, err := c.readFrame()
+        if errors.Is(err, os.ErrDeadlineExceeded) {
+            c.t.Fatal("connection left open")
+        }
+        if err != nil {
+            // Closed or reset by the server
+            return closing
+        }
+        if op == opClose {
+            closing = payload
+        }
+    }
+}
+
+func waitCalls(t *testing.T, srv *qemutest.Server, n int) []qemutest.Call {
+    t.Helper()
+
+    deadline := time.Now().Add(5 * time.Second)
+    for {
+        calls := srv.Calls()
+        if len(calls) >= n {
+            return calls
+        }
+        if time.Now().After(deadline) {
+            t.Fatalf("got %d input calls, want %d: %v", len(calls), n, calls)
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
+
+func checkCalls(t *testing.T, got, want []qemutest.Call) {
+    t.Helper()
+
+    if len(got) != len(want) {
+        t.Fatalf("got %v, want %v", got, want)
+    }
+    for i := range want {
+        if got[i].Method != want[i].Method || fmt.Sprint(got[i].Args) != fmt.Sprint(want[i].Args) {
+            t.Errorf("call %d is %s%v, want %s%v", i, got[i].Method, got[i].Args, want[i].Method, want[i].Args)
+        }
+    }
+}
+
+func TestToken(t *testing.T) {
+    b := newBridge(t)
+
+    for _, path := range []string{"/", "/?token=", "/?token=wrong", "/?token=" + token + "x"} {
+        if code := b.get(t, path); code != http.StatusForbidden {
+            t.Errorf("%s answered %d, want %d", path, code, http.StatusForbidden)
+        }
+    }
+    if code := b.get(t, "/?token="+token); code != http.StatusOK {
+        t.Errorf("page answered %d", code)
+    }
+
+    if _, resp := b.handshake(t, "/ws?token=wrong"); resp.StatusCode != http.StatusForbidden {
+        t.Errorf("websocket with a wrong token answered %s", resp.Status)
+    }
+}
+
+func TestTiles(t *testing.T) {
+    b := newBridge(t)
+    want := b.scanout(t, 100, 70, gradient)
+
+    c := b.dial(t)
+    if msg := c.expect("init"); msg["name"] != "webtest" || msg["absolute"] != true {
+        t.Errorf("init is %v", msg)
+    }
+    if msg := c.expect("size"); msg["w"] != 100.0 || msg["h"] != 70.0 {
+        t.Fatalf("size is %v", msg)
+    }
+
+    page := image.NewRGBA(image.Rect(0, 0, 100, 70))
+    if drawn := c.tiles(page); len(drawn) != 4 {
+        t.Errorf("screen sent as %v, want 4 tiles", drawn)
+    }
+    if !bytes.Equal(page.Pix, want.Pix) {
+        t.Fatal("page differs from the screen")
+    }
+
+    // Only the tile which changed is sent, once the page acked the last
+    // batch, though the update touches two
+    b.update(t, want, image.Rect(10, 10, 80, 20), func(x, y int) color.RGBA {
+        if x < 64 {
+            return gradient(x, y)
+        }
+        return color.RGBA{0xff, 0, 0, 0xff}
+    })
+    c.send(map[string]string{"t": "ack"})
+
+    drawn := c.tiles(page)
+    if len(drawn) != 1 || drawn[0] != image.Rect(64, 0, 100, 64) {
+        t.Errorf("update sent as %v, want the tile at 64,0", drawn)
+    }
+    if !bytes.Equal(page.Pix, want.Pix) {
+        t.Fatal("page differs from the screen after an update")
+    }
+
+    // A new size sends everything again
+    want = b.scanout(t, 30, 20, gradient)
+    c.send(map[string]string{"t": "ack"})
+
+    if msg := c.expect("size"); msg["w"] != 30.0 || msg["h"] != 20.0 {
+        t.Fatalf("size is %v", msg)
+    }
+    page = image.NewRGBA(image.Rect(0, 0, 30, 20))
+    if drawn := c.tiles(page); len(drawn) != 1 {
+        t.Errorf("screen sent as %v, want 1 tile", drawn)
+    }
+    if !bytes.Equal(page.Pix, want.Pix) {
+        t.Fatal("page differs from the resized screen")
+    }
+}
+
+func TestInput(t *testing.T) {
+    b := newBridge(t)
+    c := b.dial(t)
+    c.expect("init")
+    b.qemu.ResetCalls()
+
+    a, _ := keymap.LookupQCode("a")
+    ctrl, _ := keymap.LookupQCode("ctrl")
+
+    c.send(map[string]interface{}{"t": "key", "code": "KeyA", "down": true})
+    c.send(map[string]interface{}{"t": "key", "code": "KeyA", "down": false})
+    // Releasing a key which is not down is ignored, and so are unknown
+    // keys
+    c.send(map[string]interface{}{"t": "key", "code": "Enter", "down": false})
+    c.send(map[string]interface{}{"t": "key", "code": "NoSuchKey", "down": true})
+    c.send(map[string]interface{}{"t": "move", "x": 10, "y": 20})
+    c.send(map[string]interface{}{"t": "button", "button": 0, "down": true})
+    c.send(map[string]interface{}{"t": "button", "button": 0, "down": false})
+    c.send(map[string]interface{}{"t": "rel", "dx": 3, "dy": -4})
+    c.send(map[string]interface{}{"t": "wheel", "dx": 0, "dy": 1})
+    // Held when the page leaves
+    c.send(map[string]interface{}{"t": "key", "code": "ControlLeft", "down": true})
+
+    want := []qemutest.Call{
+        {Method: "org.qemu.Display1.Keyboard.Press", Args: []interface{}{a.Qnum}},
+        {Method: "org.qemu.Display1.Keyboard.Release", Args: []interface{}{a.Qnum}},
+        {Method: "org.qemu.Display1.Mouse.SetAbsPosition", Args: []interface{}{uint32(10), uint32(20)}},
+        {Method: "org.qemu.Display1.Mouse.Press", Args: []interface{}{uint32(qemu.ButtonLeft)}},
+        {Method: "org.qemu.Display1.Mouse.Release", Args: []interface{}{uint32(qemu.ButtonLeft)}},
+        {Method: "org.qemu.Display1.Mouse.RelMotion", Args: []interface{}{int32(3), int32(-4)}},
+        {Method: "org.qemu.Display1.Mouse.Press", Args: []interface{}{uint32(qemu.ButtonWheelUp)}},
+        {Method: "org.qemu.Display1.Mouse.Release", Args: []interface{}{uint32(qemu.ButtonWheelUp)}},
+        {Method: "org.qemu.Display1.Keyboard.Press", Args: []interface{}{ctrl.Qnum}},
+    }
+    got := waitCalls(t, b.qemu, len(want))
+
+    c.conn.Close()
+    want = append(want, qemutest.Call{Method: "org.qemu.Display1.Keyboard.Release", Args: []interface{}{ctrl.Qnum}})
+    got = waitCalls(t, b.qemu, len(want))
+
+    checkCalls(t, got, want)
+}
+
+func TestMouseMode(t *testing.T) {
+    b := newBridge(t)
+    c := b.dial(t)
+
+    if msg := c.expect("init"); msg["absolute"] != true {
+        t.Fatalf("init is %v", msg)
+    }
+
+    b.qemu.SetMouseAbsolute(0, false)
+    if msg := c.expect("mouse"); msg["absolute"] != false {
+        t.Errorf("got %v, want a relative mouse", msg)
+    }
+
+    b.qemu.SetMouseAbsolute(0, true)
+    if msg := c.expect("mouse"); msg["absolute"] != true {
+        t.Errorf("got %v, want an absolute mouse", msg)
+    }
+}
+
+func TestWebsocketFrames(t *testing.T) {
+    b := newBridge(t)
+    c := b.dial(t)
+    c.expect("init")
+    b.qemu.ResetCalls()
+
+    a, _ := keymap.LookupQCode("a")
+
+    // A message in fragments, with a ping in between
+    c.writeFrame(false, opText, []byte(`{"t":"key",`), true)
+    c.writeFrame(false, opContinuation, []byte(`"code":"Ke`), true)
+    c.writeFrame(true, opPing, []byte("hello"), true)
+    if op, payload := c.read(); op != opPong || string(payload) != "hello" {
+        t.Errorf("ping answered with op %d %q", op, payload)
+    }
+    c.writeFrame(true, opContinuation, []byte(`yA","down":true}`), true)
+
+    // Binary messages mean nothing and pongs are ignored
+    c.writeFrame(true, opBinary, []byte{1, 2, 3}, true)
+    c.writeFrame(true, opPong, nil, true)
+
+    checkCalls(t, waitCalls(t, b.qemu, 1), []qemutest.Call{
+        {Method: "org.qemu.Display1.Keyboard.Press", Args: []interface{}{a.Qnum}},
+    })
+
+    // A close is echoed, and the held key let go
+    c.writeFrame(true, opClose, []byte{0x03, 0xe8}, true)
+    if payload := c.closed(); !bytes.Equal(payload, []byte{0x03, 0xe8}) {
+        t.Errorf("close answered with %x", payload)
+    }
+    waitCalls(t, b.qemu, 2)
+
+    tests := []struct {
+        name   string
+        frames func(c *browser)
+    }{
+        {"unmasked frame", func(c *browser) {
+            c.writeFrame(true, opText, []byte(`{"t":"ack"}`), false)
+        }},
+        {"oversized frame", func(c *browser) {
+            // The header is enough
+            c.conn.Write([]byte{0x80 | opText, 0x80 | 127, 0, 0, 0, 0, 0, 1, 0, 1})
+        }},
+        {"oversized message", func(c *browser) {
+            part := bytes.Repeat([]byte(" "), 40<<10)
+            c.writeFrame(false, opText, part, true)
+            c.writeFrame(true, opContinuation, part, true)
+        }},
+        {"continuation without a message", func(c *browser) {
+            c.writeFrame(true, opContinuation, []byte(`{"t":"ack"}`), true)
+        }},
+        {"message within a message", func(c *browser) {
+            c.writeFrame(false, opText, []byte(`{"t":`), true)
+            c.writeFrame(true, opText, []byte(`{"t":"ack"}`), true)
+        }},
+        {"extension bits", func(c *browser) {
+            c.conn.Write([]byte{0xc0 | opText, 0x80, 0, 0, 0, 0})
+        }},
+        {"not JSON", func(c *browser) {
+            c.writeFrame(true, opText, []byte("ack"), true)
+        }},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            c := b.dial(t)
+            c.expect("init")
+            test.frames(c)
+            c.closed()
+        })
+    }
+}
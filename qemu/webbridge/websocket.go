@@ -0,0 +1,227 @@
+package webbridge
+
+import (
+    "bufio"
+    "crypto/sha1"
+    "encoding/base64"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "strings"
+    "sync"
+)
+
+// The server side of RFC 6455, as much as the viewer needs: no extensions,
+// no subprotocols.
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+    opContinuation = 0x0
+    opText         = 0x1
+    opBinary       = 0x2
+    opClose        = 0x8
+    opPing         = 0x9
+    opPong         = 0xa
+)
+
+// maxMessage is the largest message read from a browser, which only sends
+// small input events
+const maxMessage = 64 << 10
+
+var errMessageTooBig = errors.New("websocket message is too big")
+
+type websocket struct {
+    conn net.Conn
+    r    *bufio.Reader
+
+    mu sync.Mutex
+    w  *bufio.Writer
+}
+
+func headerContains(h http.Header, name, token string) bool {
+    for _, v := range h.Values(name) {
+        for _, t := range strings.Split(v, ",") {
+            if strings.EqualFold(strings.TrimSpace(t), token) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// upgrade takes over the connection of r and answers the handshake.
+func upgrade(w http.ResponseWriter, r *http.Request) (*websocket, error) {
+    key := r.Header.Get("Sec-WebSocket-Key")
+    if r.Method != http.MethodGet || key == "" ||
+        !headerContains(r.Header, "Connection", "upgrade") ||
+        !headerContains(r.Header, "Upgrade", "websocket") {
+        http.Error(w, "expected a websocket handshake", http.StatusBadRequest)
+        return nil, fmt.Errorf("not a websocket handshake")
+    }
+
+    if r.Header.Get("Sec-WebSocket-Version") != "13" {
+        w.Header().Set("Sec-WebSocket-Version", "13")
+        http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+        return nil, fmt.Errorf("unsupported websocket version %q", r.Header.Get("Sec-WebSocket-Version"))
+    }
+
+    hijacker, ok := w.(http.Hijacker)
+    if !ok {
+        http.Error(w, "cannot upgrade", http.StatusInternalServerError)
+        return nil, fmt.Errorf("connection cannot be hijacked")
+    }
+
+    conn, rw, err := hijacker.Hijack()
+    if err != nil {
+        return nil, err
+    }
+
+    sum := sha1.Sum([]byte(key + websocketGUID))
+    accept := base64.StdEncoding.EncodeToString(sum[:])
+
+    rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+    rw.WriteString("Upgrade: websocket\r\n")
+    rw.WriteString("Connection: Upgrade\r\n")
+    rw.WriteString("Sec-WebSocket-Accept: " + accept + "\r\n\r\n")
+    if err = rw.Flush(); err != nil {
+        conn.Close()
+        return nil, err
+    }
+
+    return &websocket{conn: conn, r: rw.Reader, w: rw.Writer}, nil
+}
+
+// ReadMessage returns the next text or binary message, answering pings on
+// the way. A close from the browser is answered and returned as io.EOF.
+func (ws *websocket) ReadMessage() (op byte, msg []byte, err error) {
+    for {
+        fin, frameOp, payload, err := ws.readFrame()
+        if err != nil {
+            return 0, nil, err
+        }
+
+        switch frameOp {
+        case opPing:
+            if err = ws.writeFrame(opPong, payload); err != nil {
+                return 0, nil, err
+            }
+            continue
+        case opPong:
+            continue
+        case opClose:
+            ws.writeFrame(opClose, payload)
+            return 0, nil, io.EOF
+        case opText, opBinary:
+            if op != 0 {
+                return 0, nil, fmt.Errorf("websocket message started within another")
+            }
+            op = frameOp
+        case opContinuation:
+            if op == 0 {
+                return 0, nil, fmt.Errorf("websocket continuation without a message")
+            }
+        default:
+            return 0, nil, fmt.Errorf("unknown websocket opcode %d", frameOp)
+        }
+
+        if len(msg)+len(payload) > maxMessage {
+            return 0, nil, errMessageTooBig
+        }
+        msg = append(msg, payload...)
+
+        if fin {
+            return op, msg, nil
+        }
+    }
+}
+
+func (ws *websocket) readFrame() (fin bool, op byte, payload []byte, err error) {
+    var h [2]byte
+    if _, err = io.ReadFull(ws.r, h[:]); err != nil {
+        return
+    }
+
+    fin, op = h[0]&0x80 != 0, h[0]&0x0f
+    if h[0]&0x70 != 0 {
+        return false, 0, nil, fmt.Errorf("websocket extensions are not supported")
+    }
+    if h[1]&0x80 == 0 {
+        return false, 0, nil, fmt.Errorf("websocket frame from the client is not masked")
+    }
+
+    n := uint64(h[1] & 0x7f)
+    switch n {
+    case 126:
+        var b [2]byte
+        if _, err = io.ReadFull(ws.r, b[:]); err != nil {
+            return
+        }
+        n = uint64(binary.BigEndian.Uint16(b[:]))
+    case 127:
+        var b [8]byte
+        if _, err = io.ReadFull(ws.r, b[:]); err != nil {
+            return
+        }
+        n = binary.BigEndian.Uint64(b[:])
+    }
+    if n > maxMessage {
+        return false, 0, nil, errMessageTooBig
+    }
+
+    var mask [4]byte
+    if _, err = io.ReadFull(ws.r, mask[:]); err != nil {
+        return
+    }
+
+    payload = make([]byte, n)
+    if _, err = io.ReadFull(ws.r, payload); err != nil {
+        return
+    }
+    for i := range payload {
+        payload[i] ^= mask[i%4]
+    }
+
+    return fin, op, payload, nil
+}
+
+func (ws *websocket) writeFrame(op byte, payload []byte) error {
+    ws.mu.Lock()
+    defer ws.mu.Unlock()
+
+    var h [10]byte
+    h[0] = 0x80 | op
+    n := 2
+    switch {
+    case len(payload) < 126:
+        h[1] = byte(len(payload))
+    case len(payload) <= 0xffff:
+        h[1] = 126
+        binary.BigEndian.PutUint16(h[2:], uint16(len(payload)))
+        n = 4
+    default:
+        h[1] = 127
+        binary.BigEndian.PutUint64(h[2:], uint64(len(payload)))
+        n = 10
+    }
+
+    ws.w.Write(h[:n])
+    ws.w.Write(payload)
+    return ws.w.Flush()
+}
+
+func (ws *websocket) WriteText(msg []byte) error {
+    return ws.writeFrame(opText, msg)
+}
+
+func (ws *websocket) WriteBinary(msg []byte) error {
+    return ws.writeFrame(opBinary, msg)
+}
+
+func (ws *websocket) Close() error {
+    ws.writeFrame(opClose, nil)
+    return ws.conn.Close()
+}
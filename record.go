@@ -0,0 +1,268 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "os"
+    "os/signal"
+    "sync"
+    "syscall"
+    "time"
+
+    "github.com/go-gst/go-glib/glib"
+    "github.com/go-gst/go-gst/gst"
+    "github.com/go-gst/go-gst/gst/app"
+    "github.com/godbus/dbus/v5"
+
+    "qemu"
+)
+
+const (
+    defaultVideoEncoder = "x264enc speed-preset=veryfast tune=zerolatency"
+    defaultAudioEncoder = "opusenc"
+    defaultMuxer        = "matroskamux"
+
+    // glRecordPipeline brings GL frames back to memory, the right way up
+    glRecordPipeline = "glupload ! glcolorconvert ! glviewconvert input-mode-override=left name=flip ! gldownload ! "
+
+    // eosTimeout is how long the muxer gets to finish the file
+    eosTimeout = 10 * time.Second
+)
+
+// AudioRecorder feeds the guest playback to the audio branch of a
+// recording. QEMU has a single playback voice in practice, the last one
+// announced is recorded.
+type AudioRecorder struct {
+    src    *app.Source
+    volume *gst.Element
+
+    mu    sync.Mutex
+    voice uint64
+    ok    bool
+}
+
+func (ar *AudioRecorder) Init(id uint64, bits byte, is_signed, is_float bool, freq uint32, nchannels byte, bytes_per_frame, bytes_per_second uint32, be bool) *dbus.Error {
+    ar.mu.Lock()
+    defer ar.mu.Unlock()
+
+    ar.src.SetCaps(gst.NewCapsFromString(fmt.Sprintf("audio/x-raw,format=%s,layout=interleaved,rate=%d,channels=%d", audioFormat(bits, is_signed, is_float, be), freq, nchannels)))
+    ar.voice, ar.ok = id, true
+
+    return nil
+}
+
+func (ar *AudioRecorder) Fini(id uint64) *dbus.Error {
+    ar.mu.Lock()
+    defer ar.mu.Unlock()
+
+    if ar.ok && ar.voice == id {
+        ar.ok = false
+    }
+
+    return nil
+}
+
+func (ar *AudioRecorder) SetEnabled(id uint64, enabled bool) *dbus.Error {
+    // A disabled voice does not write, the mixer fills in silence
+    return nil
+}
+
+func (ar *AudioRecorder) SetVolume(id uint64, mute bool, volume []byte) *dbus.Error {
+    ar.mu.Lock()
+    defer ar.mu.Unlock()
+
+    if !ar.ok || ar.voice != id {
+        return nil
+    }
+
+    level := 1.0
+    if len(volume) > 0 {
+        sum := 0
+        for _, v := range volume {
+            sum += int(v)
+        }
+        level = float64(sum) / float64(len(volume)) / 255
+    }
+
+    ar.volume.SetProperty("mute", mute)
+    ar.volume.SetProperty("volume", level)
+
+    return nil
+}
+
+func (ar *AudioRecorder) Write(id uint64, data []byte) *dbus.Error {
+    ar.mu.Lock()
+    current := ar.ok && ar.voice == id
+    ar.mu.Unlock()
+
+    if current {
+        ar.src.PushBuffer(gst.NewBufferFromBytes(data))
+    }
+
+    return nil
+}
+
+// recordPipeline describes the pipeline writing the video. Frames come
+// whenever the guest draws, videorate turns them into a steady stream so
+// that the time the screen stands still is kept in the video. With audio,
+// the guest playback is mixed into silence, which keeps the muxer going
+// while the guest is quiet.
+func recordPipeline(gl bool, fps int, videoEncoder, muxer string, audioEncoder string) string {
+    pipeline := "appsrc format=time do-timestamp=true stream-type=stream is-live=true name=src ! "
+    if gl {
+        pipeline += glRecordPipeline
+    }
+    pipeline += fmt.Sprintf("videorate ! video/x-raw,framerate=%d/1 ! videoconvert ! queue ! %s ! queue ! %s name=mux ! filesink name=sink", fps, videoEncoder, muxer)
+
+    if audioEncoder != "" {
+        pipeline += fmt.Sprintf(" audiotestsrc wave=silence is-live=true ! audiomixer name=mix ! audioconvert ! audioresample ! queue ! %s ! queue ! mux.", audioEncoder)
+        pipeline += " appsrc format=time do-timestamp=true is-live=true name=audio ! audioconvert ! audioresample ! volume name=volume ! mix."
+    }
+
+    return pipeline
+}
+
+func runRecord(fs *flag.FlagSet, args []string) error {
+    cf := addConnFlags(fs)
+    consoleSpec := fs.String("console", "0", "console to record: a console ID or a label")
+    output := fs.String("o", "", "video file to write")
+    fps := fs.Int("fps", 30, "frames per second of the video")
+    videoEncoder := fs.String("encoder", defaultVideoEncoder, "GStreamer video encoder")
+    audioEncoder := fs.String("audio-encoder", defaultAudioEncoder, "GStreamer audio encoder")
+    muxer := fs.String("muxer", defaultMuxer, "GStreamer muxer, which must take the video and audio encoders")
+    withAudio := fs.Bool("audio", true, "record the guest playback, when the VM has audio")
+    gl := fs.Bool("gl", false, "go through OpenGL, needed for consoles scanned out as DMA-BUFs")
+    fs.Parse(args)
+    needArgs(fs, 0, false)
+
+    if *output == "" {
+        return fmt.Errorf("-o is needed")
+    }
+    if *fps <= 0 {
+        return fmt.Errorf("-fps must be positive")
+    }
+
+    vm, closeVM, err := cf.connect()
+    if err != nil {
+        return err
+    }
+    defer closeVM()
+
+    console, err := findConsole(vm, *consoleSpec)
+    if err != nil {
+        return err
+    }
+
+    gst.Init(nil)
+
+    var audio *qemu.Audio
+    if *withAudio {
+        if audio, err = vm.GetAudio(); err != nil {
+            fmt.Println("Audio is not available:", err)
+            audio = nil
+        }
+    }
+    if audio == nil {
+        *audioEncoder = ""
+    }
+
+    pipeline, err := gst.NewPipelineFromString(recordPipeline(*gl, *fps, *videoEncoder, *muxer, *audioEncoder))
+    if err != nil {
+        return err
+    }
+    defer pipeline.BlockSetState(gst.StateNull)
+
+    sink, err := pipeline.GetElementByName("sink")
+    if err != nil {
+        return err
+    }
+    sink.SetProperty("location", *output)
+
+    elem, err := pipeline.GetElementByName("src")
+    if err != nil {
+        return err
+    }
+    flip, _ := pipeline.GetElementByName("flip")
+    listener := &DisplayListener{app.SrcFromElement(elem), flip, nil, nil}
+
+    var recorder *AudioRecorder
+    if audio != nil {
+        elem, err := pipeline.GetElementByName("audio")
+        if err != nil {
+            return err
+        }
+        volume, err := pipeline.GetElementByName("volume")
+        if err != nil {
+            return err
+        }
+        recorder = &AudioRecorder{src: app.SrcFromElement(elem), volume: volume}
+    }
+
+    mainLoop := glib.NewMainLoop(glib.MainContextDefault(), false)
+
+    var pipelineErr error
+    pipeline.GetPipelineBus().AddWatch(func(msg *gst.Message) bool {
+        switch msg.Type() {
+        case gst.MessageEOS:
+            mainLoop.Quit()
+        case gst.MessageError:
+            err := msg.ParseError()
+            pipelineErr = err
+            if debug := err.DebugString(); debug != "" {
+                debugf("DEBUG: %s\n", debug)
+            }
+            mainLoop.Quit()
+        }
+        return true
+    })
+
+    if err = pipeline.SetState(gst.StatePlaying); err != nil {
+        return err
+    }
+
+    if err = console.RegisterListener(listener); err != nil {
+        return err
+    }
+    if recorder != nil {
+        if err = audio.RegisterOutListener(recorder); err != nil {
+            fmt.Println("Audio is not available:", err)
+        } else {
+            defer audio.UnregisterListener(recorder)
+        }
+    }
+
+    signals := make(chan os.Signal, 1)
+    signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+    defer signal.Stop(signals)
+
+    stopped := make(chan struct{})
+    defer close(stopped)
+    go func() {
+        select {
+        case <-signals:
+        case <-vm.Disconnected():
+            fmt.Println("Disconnected from the VM")
+        case <-stopped:
+            return
+        }
+
+        // The last frame lasts until the end
+        console.UnregisterListener(listener)
+        listener.Repeat()
+
+        fmt.Println("Finishing", *output)
+        pipeline.SendEvent(gst.NewEOSEvent())
+
+        select {
+        case <-time.After(eosTimeout):
+            fmt.Println("The muxer did not finish in time, the file may be truncated")
+            mainLoop.Quit()
+        case <-stopped:
+        }
+    }()
+
+    fmt.Printf("Recording console %d of %s to %s, Ctrl+C to stop\n", console.ID(), vm.Name(), *output)
+    mainLoop.Run()
+
+    return pipelineErr
+}
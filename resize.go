@@ -0,0 +1,70 @@
+package main
+
+import (
+    "fmt"
+    "sync"
+    "time"
+
+    "qemu"
+)
+
+// Window sizes keep changing while the user drags the border, do not make
+// the guest modeset for each of them
+const resizeDebounce = 300 * time.Millisecond
+
+// Assumed when converting the window size to millimeters
+const resizeDPI = 96
+
+// Resizer asks the guest to follow the window size.
+type Resizer struct {
+    console *qemu.Console
+    scale   float64
+
+    mu     sync.Mutex
+    timer  *time.Timer
+    width  uint32
+    height uint32
+}
+
+// NewResizer makes the guest resolution the window size divided by scale,
+// which is 1 unless the guest should be blown up.
+func NewResizer(console *qemu.Console, scale float64) *Resizer {
+    if scale <= 0 {
+        scale = 1
+    }
+    return &Resizer{console: console, scale: scale}
+}
+
+func (r *Resizer) Resize(width, height uint32) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    if width == 0 || height == 0 || (width == r.width && height == r.height) {
+        return
+    }
+
+    r.width = width
+    r.height = height
+
+    if r.timer != nil {
+        r.timer.Stop()
+    }
+    r.timer = time.AfterFunc(resizeDebounce, r.apply)
+}
+
+func (r *Resizer) apply() {
+    r.mu.Lock()
+    width, height := r.width, r.height
+    r.mu.Unlock()
+
+    mm := func(px uint32) uint16 {
+        return uint16(min(uint64(px)*254/(resizeDPI*10), 0xffff))
+    }
+
+    // The physical size is the one of the window, only the resolution is
+    // scaled
+    err := r.console.SetUIInfo(mm(width), mm(height), 0, 0, uint32(float64(width)/r.scale), uint32(float64(height)/r.scale))
+    if err != nil {
+        fmt.Println("SetUIInfo failed:", err)
+    }
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "flag"
+    "fmt"
+    "image"
+    "image/png"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "qemu"
+    "qemu/framebuffer"
+)
+
+// cursorWait is how long to wait for the cursor after the first frame,
+// QEMU defines it right after the scanout
+const cursorWait = 200 * time.Millisecond
+
+func runScreenshot(fs *flag.FlagSet, args []string) error {
+    cf := addConnFlags(fs)
+    consoleSpec := fs.String("console", "0", "console to take: a console ID or a label")
+    out := fs.String("o", "", "file to write, - for the standard output")
+    format := fs.String("format", "", "png or ppm (default: from the file name, png for -)")
+    cursor := fs.Bool("cursor", false, "draw the mouse pointer")
+    timeout := fs.Duration("timeout", 10*time.Second, "how long to wait for a frame")
+    fs.Parse(args)
+    needArgs(fs, 0, false)
+
+    if *out == "" {
+        fs.Usage()
+        os.Exit(2)
+    }
+
+    if *format == "" {
+        *format = strings.TrimPrefix(strings.ToLower(filepath.Ext(*out)), ".")
+        if *out == "-" {
+            *format = "png"
+        }
+    }
+
+    var encode func(w io.Writer, img image.Image) error
+    switch *format {
+    case "png":
+        encode = png.Encode
+    case "ppm", "pnm":
+        encode = writePPM
+    default:
+        return fmt.Errorf("unknown image format %q, expected png or ppm", *format)
+    }
+
+    vm, closeVM, err := cf.connect()
+    if err != nil {
+        return err
+    }
+    defer closeVM()
+
+    console, err := findConsole(vm, *consoleSpec)
+    if err != nil {
+        return err
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+    defer cancel()
+
+    img, err := grabFrame(ctx, console, *cursor)
+    if err != nil {
+        return err
+    }
+
+    if *out == "-" {
+        w := bufio.NewWriter(os.Stdout)
+        if err = encode(w, img); err != nil {
+            return err
+        }
+        return w.Flush()
+    }
+
+    f, err := os.Create(*out)
+    if err != nil {
+        return err
+    }
+
+    w := bufio.NewWriter(f)
+    err = encode(w, img)
+    if err == nil {
+        err = w.Flush()
+    }
+    if cerr := f.Close(); err == nil {
+        err = cerr
+    }
+
+    return err
+}
+
+// grabFrame listens on console until the first full frame came.
+func grabFrame(ctx context.Context, console *qemu.Console, withCursor bool) (image.Image, error) {
+    fb := framebuffer.New()
+    defer fb.Close()
+
+    watchCtx, stop := context.WithCancel(ctx)
+    defer stop()
+    damage := fb.Watch(watchCtx)
+
+    if err := console.RegisterListener(fb); err != nil {
+        return nil, err
+    }
+    defer console.UnregisterListener(fb)
+
+    for d := range damage {
+        if !d.Resized {
+            continue
+        }
+
+        if !withCursor {
+            return fb.Snapshot(), nil
+        }
+
+        cursorCtx, cancel := context.WithTimeout(ctx, cursorWait)
+        for fb.Cursor().Image == nil && cursorCtx.Err() == nil {
+            select {
+            case <-damage:
+            case <-cursorCtx.Done():
+            }
+        }
+        cancel()
+
+        return fb.SnapshotWithCursor(), nil
+    }
+
+    return nil, fmt.Errorf("no frame from console %d, it may be blank or only have GL frames which cannot be read", console.ID())
+}
+
+// writePPM writes img as a binary PPM, dropping the alpha.
+func writePPM(w io.Writer, img image.Image) error {
+    r := img.Bounds()
+    if _, err := fmt.Fprintf(w, "P6\n%d %d\n255\n", r.Dx(), r.Dy()); err != nil {
+        return err
+    }
+
+    row := make([]byte, 0, r.Dx()*3)
+    for y := r.Min.Y; y < r.Max.Y; y++ {
+        row = row[:0]
+        for x := r.Min.X; x < r.Max.X; x++ {
+            cr, cg, cb, _ := img.At(x, y).RGBA()
+            row = append(row, byte(cr>>8), byte(cg>>8), byte(cb>>8))
+        }
+        if _, err := w.Write(row); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
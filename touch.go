@@ -0,0 +1,64 @@
+package main
+
+import (
+    "qemu"
+)
+
+// TouchInput maps GStreamer touch identifiers to the guest device slots.
+type TouchInput struct {
+    touch *qemu.MultiTouch
+    slots map[uint]uint64
+}
+
+func NewTouchInput(touch *qemu.MultiTouch) *TouchInput {
+    return &TouchInput{touch, make(map[uint]uint64)}
+}
+
+func (ti *TouchInput) allocSlot(id uint) (uint64, bool) {
+    used := make(map[uint64]bool, len(ti.slots))
+    for _, slot := range ti.slots {
+        used[slot] = true
+    }
+
+    for slot := range uint64(ti.touch.MaxSlots()) {
+        if !used[slot] {
+            ti.slots[id] = slot
+            return slot, true
+        }
+    }
+
+    return 0, false
+}
+
+func (ti *TouchInput) Down(id uint, x, y float64) {
+    slot, ok := ti.slots[id]
+    if !ok {
+        slot, ok = ti.allocSlot(id)
+        if !ok {
+            // Out of slots, the guest would not track this point anyway
+            return
+        }
+    }
+
+    ti.touch.SendEvent(qemu.TouchBegin, slot, x, y)
+}
+
+func (ti *TouchInput) Motion(id uint, x, y float64) {
+    if slot, ok := ti.slots[id]; ok {
+        ti.touch.SendEvent(qemu.TouchUpdate, slot, x, y)
+    }
+}
+
+func (ti *TouchInput) Up(id uint, x, y float64) {
+    if slot, ok := ti.slots[id]; ok {
+        ti.touch.SendEvent(qemu.TouchEnd, slot, x, y)
+        delete(ti.slots, id)
+    }
+}
+
+func (ti *TouchInput) Cancel() {
+    for id, slot := range ti.slots {
+        ti.touch.SendEvent(qemu.TouchCancel, slot, 0, 0)
+        delete(ti.slots, id)
+    }
+}
@@ -1,13 +1,13 @@
 package main
 
 import (
+    "context"
+    "flag"
     "fmt"
-    "os"
 
     "github.com/go-gst/go-glib/glib"
     "github.com/go-gst/go-gst/gst"
     "github.com/go-gst/go-gst/gst/app"
-    "github.com/go-gst/go-gst/gst/video"
     "github.com/godbus/dbus/v5"
 
     "qemu"
@@ -21,12 +21,36 @@ type DisplayListener struct {
     img  Picture
 }
 
+// setFlipped turns the frames upside down, for the GL frames which start at
+// the bottom. Without a flip element the frames are shown as they come.
+func (dl *DisplayListener) setFlipped(flipped bool) {
+    if dl.flip == nil {
+        return
+    }
+
+    if flipped {
+        dl.flip.SetArg("input-flags-override", "left-flipped")
+        dl.flip.SetArg("video-direction", "vert")
+    } else {
+        dl.flip.SetArg("input-flags-override", "none")
+        dl.flip.SetArg("video-direction", "identity")
+    }
+}
+
+// Repeat pushes the current frame again.
+func (dl *DisplayListener) Repeat() {
+    if dl.img == nil {
+        return
+    }
+
+    sample := gst.NewSample(dl.img.CreateBuffer(), dl.caps)
+    dl.src.PushSample(sample)
+}
 
 func (dl *DisplayListener) Scanout(width, height, stride, format uint32, data []byte) *dbus.Error {
-    // fmt.Printf("Scanout: resolution %dx%d, stride %d, fmt %x, data %d\n", width, height, stride, format, len(data))
+    debugf("Scanout: resolution %dx%d, stride %d, fmt %x, data %d\n", width, height, stride, format, len(data))
 
-    dl.flip.SetArg("input-flags-override", "none")
-    dl.flip.SetArg("video-direction", "identity")
+    dl.setFlipped(false)
 
     dl.img = NewRawPicture(width, height, stride, format, data)
     dl.caps = dl.img.CreateCaps()
@@ -38,7 +62,7 @@ func (dl *DisplayListener) Scanout(width, height, stride, format uint32, data []
 }
 
 func (dl *DisplayListener) Update(x, y, width, height int32, stride, format uint32, data []byte) *dbus.Error {
-    // fmt.Printf("Update: rect pos (%d,%d) size %dx%d, stride %d, fmt %x, data %d\n", x, y, width, height, stride, format, len(data))
+    debugf("Update: rect pos (%d,%d) size %dx%d, stride %d, fmt %x, data %d\n", x, y, width, height, stride, format, len(data))
     if dl.img == nil {
         fmt.Println("Update before Scanout?")
         return nil
@@ -52,15 +76,9 @@ func (dl *DisplayListener) Update(x, y, width, height int32, stride, format uint
 }
 
 func (dl *DisplayListener) ScanoutDMABUF(fd dbus.UnixFD, width, height, stride, fourcc uint32, modifier uint64, y0_top bool) *dbus.Error {
-    // fmt.Printf("ScanoutDMABUF: resolution %dx%d, stride %d, fmt %x:%x, fd %d, normal y: %t\n", width, height, stride, fourcc, modifier, fd, y0_top)
+    debugf("ScanoutDMABUF: resolution %dx%d, stride %d, fmt %x:%x, fd %d, normal y: %t\n", width, height, stride, fourcc, modifier, fd, y0_top)
 
-    if y0_top {
-        dl.flip.SetArg("input-flags-override", "left-flipped")
-        dl.flip.SetArg("video-direction", "vert")
-    } else {
-        dl.flip.SetArg("input-flags-override", "none")
-        dl.flip.SetArg("video-direction", "identity")
-    }
+    dl.setFlipped(y0_top)
 
     dl.img = NewDmaPicture(int(fd), width, height, []uint32{0}, []uint32{stride}, fourcc, modifier, y0_top)
     dl.caps = dl.img.CreateCaps()
@@ -72,7 +90,7 @@ func (dl *DisplayListener) ScanoutDMABUF(fd dbus.UnixFD, width, height, stride,
 }
 
 func (dl *DisplayListener) UpdateDMABUF(x, y, width, height int32) *dbus.Error {
-    // fmt.Printf("UpdateDMABUF: rect pos (%d,%d) size %dx%d\n", x, y, width, height)
+    debugf("UpdateDMABUF: rect pos (%d,%d) size %dx%d\n", x, y, width, height)
     if dl.img == nil {
         fmt.Println("UpdateDMABUF before ScanoutDMABUF?")
         return nil
@@ -86,25 +104,24 @@ func (dl *DisplayListener) UpdateDMABUF(x, y, width, height int32) *dbus.Error {
 }
 
 func (dl *DisplayListener) Disable() *dbus.Error {
-    fmt.Printf("Disable\n")
+    debugf("Disable\n")
     return nil
 }
 
 func (dl *DisplayListener) MouseSet(x, y, on int) *dbus.Error {
-    fmt.Printf("MouseSet: %d,%d -> %d\n", x, y, on)
+    debugf("MouseSet: %d,%d -> %d\n", x, y, on)
     return nil
 }
 
 func (dl *DisplayListener) CursorDefine(width, height, hot_x, hot_y int, data []byte) *dbus.Error {
-    fmt.Printf("CursorDefine: %dx%d (%d,%d) -> <pixels>\n", width, height, hot_x, hot_y)
+    debugf("CursorDefine: %dx%d (%d,%d) -> <pixels>\n", width, height, hot_x, hot_y)
     return nil
 }
 
 func (dl *DisplayListener) ScanoutMap(fd dbus.UnixFD, offset, width, height, stride, format uint32) *dbus.Error {
-    // fmt.Printf("ScanoutMap: resolution %dx%d, stride %d, fmt %x, fd %d, offset %d\n", width, height, stride, format, fd, offset)
+    debugf("ScanoutMap: resolution %dx%d, stride %d, fmt %x, fd %d, offset %d\n", width, height, stride, format, fd, offset)
 
-    dl.flip.SetArg("input-flags-override", "none")
-    dl.flip.SetArg("video-direction", "identity")
+    dl.setFlipped(false)
 
     dl.img = NewShmemPicture(int(fd), offset, width, height, stride, format)
     dl.caps = dl.img.CreateCaps()
@@ -116,7 +133,7 @@ func (dl *DisplayListener) ScanoutMap(fd dbus.UnixFD, offset, width, height, str
 }
 
 func (dl *DisplayListener) UpdateMap(x, y, width, height int32) *dbus.Error {
-    // fmt.Printf("UpdateMap: rect pos (%d,%d) size %dx%d\n", x, y, width, height)
+    debugf("UpdateMap: rect pos (%d,%d) size %dx%d\n", x, y, width, height)
     if dl.img == nil {
         fmt.Println("UpdateMap before ScanoutMap?")
         return nil
@@ -130,15 +147,9 @@ func (dl *DisplayListener) UpdateMap(x, y, width, height int32) *dbus.Error {
 }
 
 func (dl *DisplayListener) ScanoutDMABUF2(fd []dbus.UnixFD, x, y, width, height uint32, offset, stride []uint32, num_planes, fourcc, backing_width, backing_height uint32, modifier uint64, y0_top bool) *dbus.Error {
-    // fmt.Printf("ScanoutDMABUF2: resolution %dx%d (%dx%d), num_planes %d, offset %v, stride %v, fmt %x:%x, fd %d, normal y: %t\n", width, height, backing_width, backing_height, num_planes, offset, stride, fourcc, modifier, fd, y0_top)
+    debugf("ScanoutDMABUF2: resolution %dx%d (%dx%d), num_planes %d, offset %v, stride %v, fmt %x:%x, fd %d, normal y: %t\n", width, height, backing_width, backing_height, num_planes, offset, stride, fourcc, modifier, fd, y0_top)
 
-    if y0_top {
-        dl.flip.SetArg("input-flags-override", "left-flipped")
-        dl.flip.SetArg("video-direction", "vert")
-    } else {
-        dl.flip.SetArg("input-flags-override", "none")
-        dl.flip.SetArg("video-direction", "identity")
-    }
+    dl.setFlipped(y0_top)
 
     if len(fd) != 1 {
         panic(fmt.Errorf("cannot handle dma buffers with %d fds", len(fd)))
@@ -153,171 +164,114 @@ func (dl *DisplayListener) ScanoutDMABUF2(fd []dbus.UnixFD, x, y, width, height
     return nil
 }
 
-func main() {
-    vm, err := qemu.NewVM()
-    if err != nil {
-        panic(err)
+func runView(fs *flag.FlagSet, args []string) error {
+    cf := addConnFlags(fs)
+    syncLocks := fs.Bool("sync-locks", true, "match guest Caps/Num Lock to the host")
+    reconnect := fs.Bool("reconnect", true, "reconnect when the VM restarts or the bus drops")
+    consoles := fs.String("console", "all", "consoles to show: all, a console ID or a label")
+    pipeline := fs.String("pipeline", DefaultViewPipeline, "GStreamer pipeline showing the frames, fed by an appsrc")
+    scale := fs.Float64("scale", 1, "window pixels per guest pixel")
+    fs.Parse(args)
+    needArgs(fs, 0, false)
+
+    if *scale <= 0 {
+        return fmt.Errorf("-scale must be positive")
     }
-    defer vm.Close()
-
-    fmt.Printf("Session ADDR: %s\n", os.Getenv("DBUS_SESSION_BUS_ADDRESS"))
-    fmt.Println("Connected to a VM:")
-
-    fmt.Printf("  Name: %v\n", vm.Name())
-    fmt.Printf("  UUID: %v\n", vm.UUID())
-    fmt.Printf("  Number of consoles: %d\n", vm.NumConsoles())
 
-    console, err := vm.GetConsole(0)
+    monitor, err := cf.monitor()
     if err != nil {
-        panic(err)
+        return err
+    }
+    if monitor != nil {
+        defer monitor.Close()
     }
 
-    fmt.Println("Connected to a console 0:")
-    fmt.Printf("  %s display \"%s\": %dx%d\n", console.Type(), console.Label(), console.Width(), console.Height())
-
-    mouse, err := console.GetMouse()
+    dial, err := cf.dialer(monitor)
     if err != nil {
-        panic(err)
+        return err
     }
 
-    keyboard, err := console.GetKeyboard()
-    if err != nil {
-        panic(err)
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+
+    var power *Power
+    if monitor != nil {
+        power = NewPower(ctx, monitor)
     }
 
-    fmt.Printf("  Mouse is absolute: %t\n", mouse.IsAbsolute())
+    var x11 *X11
+    if UseX11() {
+        x11, err = OpenX11()
+        if err != nil {
+            fmt.Println("Grabbed pointers stay visible:", err)
+        } else {
+            defer x11.Close()
+        }
+    }
 
     gst.Init(nil)
 
     mainLoop := glib.NewMainLoop(glib.MainContextDefault(), false)
 
-    pipeline, err := gst.NewPipelineFromString("appsrc format=time do-timestamp=true stream-type=stream is-live=true name=src ! glupload ! glcolorconvert ! glviewconvert input-mode-override=left name=flip ! glimagesink")
+    session := qemu.NewSessionFunc(context.Background(), dial)
 
-    if err != nil {
-        panic(err)
-    }
-
-    elem, err := pipeline.GetElementByName("src")
-    if err != nil {
-        panic(err)
-    }
+    audioOut := NewAudioOutput()
+    err = session.OnConnect(func(ctx context.Context, vm *qemu.VM) error {
+        printVM(vm)
+        if power != nil {
+            fmt.Printf("  %s\n", hotkeysHelp)
+        }
 
-    src := app.SrcFromElement(elem)
+        audio, err := vm.GetAudio()
+        if err == nil {
+            err = audio.RegisterOutListener(audioOut)
+        }
+        if err != nil {
+            fmt.Println("Audio is not available:", err)
+            return nil
+        }
 
-    flip, err := pipeline.GetElementByName("flip")
+        go func() {
+            <-ctx.Done()
+            audio.UnregisterListener(audioOut)
+            audioOut.Reset()
+        }()
+        return nil
+    })
     if err != nil {
-        panic(err)
+        return err
     }
 
-    listener := &DisplayListener{src, flip, nil, nil}
-
-    err = console.RegisterListener(listener)
+    windows := NewWindows(ParseConsoleFilter(*consoles), &ViewOptions{
+        Pipeline:  *pipeline,
+        Scale:     *scale,
+        SyncLocks: *syncLocks,
+        Power:     power,
+        Quit:      mainLoop.Quit,
+        X11:       x11,
+    })
+    err = session.OnConnect(windows.Attach)
     if err != nil {
-        panic(err)
+        return err
     }
 
-    pipeline.GetPipelineBus().AddWatch(func(msg *gst.Message) bool {
-        switch msg.Type() {
-        case gst.MessageEOS:
-            pipeline.BlockSetState(gst.StateNull)
-            mainLoop.Quit()
-        case gst.MessageError:
-            err := msg.ParseError()
-            fmt.Println("ERROR:", err.Error())
-            if debug := err.DebugString(); debug != "" {
-                fmt.Println("DEBUG:", debug)
-            }
-            mainLoop.Quit()
-        case gst.MessageElement:
-            if nav := video.ToNavigationMessage(msg); nav != nil {
-                if nav.GetType() == video.NavigationMessageEvent {
-                    event := nav.GetEvent()
-
-                    switch event.GetType() {
-                    case video.NavigationEventInvalid:
-                        fmt.Println("Invalid navigation event:", event)
-                    case video.NavigationEventKeyPress:
-                        key, ok := event.ParseKeyEvent()
-                        if ok {
-                            keycode, ok := keymap[key]
-                            if ok {
-                                keyboard.Press(keycode)
-                            } else {
-                                fmt.Println("Unknown key down:", key)
-                            }
-                        } else {
-                            panic("wtf")
-                        }
-                    case video.NavigationEventKeyRelease:
-                        key, ok := event.ParseKeyEvent()
-                        if ok {
-                            keycode, ok := keymap[key]
-                            if ok {
-                                keyboard.Release(keycode)
-                            } else {
-                                fmt.Println("Unknown key down:", key)
-                            }
-                        } else {
-                            panic("wtf")
-                        }
-                    case video.NavigationEventMouseButtonPress:
-                        button, x, y, ok := event.ParseMouseButtonEvent()
-                        if ok {
-                            _ = x
-                            _ = y
-                            //fmt.Println("Mouse down:", button, int(x), int(y))
-                            mouse.Press(uint32(button - 1))
-                        } else {
-                            panic("wtf")
-                        }
-                    case video.NavigationEventMouseButtonRelease:
-                        button, x, y, ok := event.ParseMouseButtonEvent()
-
-                        if ok {
-                            _ = x
-                            _ = y
-                            //fmt.Println("Mouse up:", button, int(x), int(y))
-                            mouse.Release(uint32(button - 1))
-                        } else {
-                            panic("wtf")
-                        }
-                    case video.NavigationEventMouseMove:
-                        x, y, ok := event.ParseMouseMoveEvent()
-                        if ok {
-                            //fmt.Println("Mouse move:", int(x), int(y))
-                            mouse.SetAbsPosition(uint32(x), uint32(y))
-                        } else {
-                            panic("wtf")
-                        }
-                    case video.NavigationEventCommand:
-                        cmd, ok := event.ParseCommandEvent()
-                        if ok {
-                            fmt.Println("Command:", cmd)
-                        } else {
-                            panic("wtf")
-                        }
-                    case video.NavigationEventMouseScroll:
-                        x, y, dx, dy, ok := event.ParseMouseScrollEvent()
-                        if ok {
-                            fmt.Println("Mouse scroll:", x, y, dx, dy)
-                        } else {
-                            panic("wtf")
-                        }
-                    }
-                } else {
-                    fmt.Println("Unhandled navigation:", nav.GetType())
+    go func() {
+        for ev := range session.Events() {
+            switch ev.Kind {
+            case qemu.SessionDisconnected:
+                if !*reconnect {
+                    fmt.Println("Disconnected from the VM")
+                    mainLoop.Quit()
+                    return
                 }
-            } else {
-                // Unknown message
-                //fmt.Println("Unknown element message:", msg)
+                fmt.Println("Disconnected from the VM, reconnecting")
+            case qemu.SessionFailed:
+                fmt.Printf("Cannot connect to the VM, retrying in %v: %v\n", ev.Retry, ev.Err)
             }
-        default:
-            //fmt.Println("Unknown message:", msg)
         }
-        return true
-    })
-
-    pipeline.SetState(gst.StatePlaying)
+    }()
 
     mainLoop.Run()
+
+    return nil
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "net"
+    "os"
+
+    "qemu/vncbridge"
+)
+
+const defaultVNCAddress = "localhost:5900"
+
+func runServeVNC(fs *flag.FlagSet, args []string) error {
+    cf := addConnFlags(fs)
+    consoleSpec := fs.String("console", "0", "console to serve: a console ID or a label")
+    fs.Parse(args)
+    if fs.NArg() > 1 {
+        fs.Usage()
+        os.Exit(2)
+    }
+
+    address := defaultVNCAddress
+    if fs.NArg() == 1 {
+        address = fs.Arg(0)
+    }
+
+    vm, closeVM, err := cf.connect()
+    if err != nil {
+        return err
+    }
+    defer closeVM()
+
+    console, err := findConsole(vm, *consoleSpec)
+    if err != nil {
+        return err
+    }
+
+    server, err := vncbridge.New(console, vm.Name())
+    if err != nil {
+        return err
+    }
+    defer server.Close()
+    server.Logf = debugf
+
+    l, err := net.Listen("tcp", address)
+    if err != nil {
+        return err
+    }
+
+    go func() {
+        <-vm.Disconnected()
+        server.Close()
+    }()
+
+    fmt.Printf("Serving console %d of %s over VNC on %s\n", console.ID(), vm.Name(), l.Addr())
+    err = server.Serve(l)
+
+    select {
+    case <-vm.Disconnected():
+        return fmt.Errorf("disconnected from the VM")
+    default:
+        return err
+    }
+}
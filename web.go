@@ -0,0 +1,76 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "net"
+    "net/http"
+    "os"
+
+    "qemu/webbridge"
+)
+
+const defaultWebAddress = "localhost:8080"
+
+func runServeWeb(fs *flag.FlagSet, args []string) error {
+    cf := addConnFlags(fs)
+    consoleSpec := fs.String("console", "0", "console to serve: a console ID or a label")
+    token := fs.String("token", "", "token the browser must give, random by default")
+    fs.Parse(args)
+    if fs.NArg() > 1 {
+        fs.Usage()
+        os.Exit(2)
+    }
+
+    address := defaultWebAddress
+    if fs.NArg() == 1 {
+        address = fs.Arg(0)
+    }
+
+    if *token == "" {
+        var err error
+        if *token, err = webbridge.NewToken(); err != nil {
+            return err
+        }
+    }
+
+    vm, closeVM, err := cf.connect()
+    if err != nil {
+        return err
+    }
+    defer closeVM()
+
+    console, err := findConsole(vm, *consoleSpec)
+    if err != nil {
+        return err
+    }
+
+    server, err := webbridge.New(console, vm.Name(), *token)
+    if err != nil {
+        return err
+    }
+    defer server.Close()
+    server.Logf = debugf
+
+    l, err := net.Listen("tcp", address)
+    if err != nil {
+        return err
+    }
+
+    httpServer := &http.Server{Handler: server}
+    go func() {
+        <-vm.Disconnected()
+        server.Close()
+        httpServer.Close()
+    }()
+
+    fmt.Printf("Serving console %d of %s on http://%s/?token=%s\n", console.ID(), vm.Name(), l.Addr(), *token)
+    err = httpServer.Serve(l)
+
+    select {
+    case <-vm.Disconnected():
+        return fmt.Errorf("disconnected from the VM")
+    default:
+        return err
+    }
+}
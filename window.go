@@ -0,0 +1,318 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "sync/atomic"
+
+    "github.com/go-gst/go-glib/glib"
+    "github.com/go-gst/go-gst/gst"
+    "github.com/go-gst/go-gst/gst/app"
+    "github.com/go-gst/go-gst/gst/video"
+
+    "qemu"
+    "qemu/keymap"
+)
+
+// DefaultViewPipeline shows the frames in a window, flip turns GL frames
+// the right way up and sink asks the guest to follow the window size.
+const DefaultViewPipeline = "glupload ! glcolorconvert ! glviewconvert input-mode-override=left name=flip ! glimagesink name=sink"
+
+// ViewOptions are the settings shared by all windows.
+type ViewOptions struct {
+    // Pipeline is the part of the pipeline after the source of frames.
+    // Elements called flip and sink, as in DefaultViewPipeline, are used
+    // if present.
+    Pipeline string
+    // Scale is the number of window pixels per guest pixel
+    Scale     float64
+    SyncLocks bool
+    Power     *Power
+    Quit      func()
+    // X11 hides and confines grabbed pointers, nil if the windows are not
+    // on X11
+    X11 *X11
+}
+
+// Window shows one console in a window of its own and forwards the input
+// it gets to the console. It outlives the connection: while the VM is away
+// the last frame stays up and input is dropped.
+type Window struct {
+    id       uint32
+    opts     *ViewOptions
+    pipeline *gst.Pipeline
+    listener *DisplayListener
+    hotkeys  *Hotkeys
+
+    // set once the X11 focus of the window is followed
+    watchingFocus bool
+
+    // devices of the attached console, nil while detached
+    devices atomic.Pointer[Devices]
+}
+
+func NewWindow(id uint32, opts *ViewOptions) (*Window, error) {
+    pipeline, err := gst.NewPipelineFromString("appsrc format=time do-timestamp=true stream-type=stream is-live=true name=src ! " + opts.Pipeline)
+    if err != nil {
+        return nil, err
+    }
+
+    elem, err := pipeline.GetElementByName("src")
+    if err != nil {
+        return nil, err
+    }
+
+    src := app.SrcFromElement(elem)
+
+    // Both are optional with a custom pipeline
+    flip, _ := pipeline.GetElementByName("flip")
+    sink, _ := pipeline.GetElementByName("sink")
+
+    w := &Window{
+        id:       id,
+        opts:     opts,
+        pipeline: pipeline,
+        listener: &DisplayListener{src, flip, nil, nil},
+        hotkeys:  &Hotkeys{},
+    }
+
+    if sink != nil {
+        sink.Connect("client-reshape", func(self *glib.Object, context *glib.Object, width, height uint) bool {
+            if dev := w.devices.Load(); dev != nil {
+                dev.resizer.Resize(uint32(width), uint32(height))
+            }
+            // Let glimagesink do its own reshape
+            return false
+        })
+    }
+
+    pipeline.GetPipelineBus().AddWatch(w.handleMessage)
+    pipeline.SetState(gst.StatePlaying)
+
+    return w, nil
+}
+
+// Attach connects the window to console until ctx is done.
+func (w *Window) Attach(ctx context.Context, console *qemu.Console) error {
+    dev, err := NewDevices(ctx, console, w.opts)
+    if err != nil {
+        return err
+    }
+
+    if err = console.RegisterListener(w.listener); err != nil {
+        return err
+    }
+
+    w.devices.Store(dev)
+    go func() {
+        <-ctx.Done()
+        w.devices.CompareAndSwap(dev, nil)
+        dev.pointer.Detach()
+        console.UnregisterListener(w.listener)
+    }()
+
+    states, err := console.Watch(ctx)
+    if err != nil {
+        fmt.Println("Cannot watch console changes:", err)
+        return nil
+    }
+    go func() {
+        // The first one is the state we just printed
+        <-states
+        for st := range states {
+            fmt.Printf("Console %d is now \"%s\": %dx%d\n", w.id, st.Label, st.Width, st.Height)
+        }
+    }()
+
+    return nil
+}
+
+func (w *Window) Close() {
+    w.devices.Store(nil)
+    w.pipeline.BlockSetState(gst.StateNull)
+}
+
+// watchFocus syncs the lock keys whenever the window gets the focus back.
+// The window is only known to X11 once it has the focus, so it is called on
+// key presses.
+func (w *Window) watchFocus() {
+    if w.opts.X11 == nil || w.watchingFocus {
+        return
+    }
+    w.watchingFocus = true
+
+    err := w.opts.X11.WatchFocus(func() {
+        if dev := w.devices.Load(); dev != nil && dev.locks != nil {
+            dev.locks.Sync()
+        }
+    })
+    if err != nil {
+        fmt.Println("Cannot follow the window focus:", err)
+    }
+}
+
+func (w *Window) handleMessage(msg *gst.Message) bool {
+    switch msg.Type() {
+    case gst.MessageEOS:
+        w.pipeline.BlockSetState(gst.StateNull)
+        w.opts.Quit()
+    case gst.MessageError:
+        err := msg.ParseError()
+        fmt.Println("ERROR:", err.Error())
+        if debug := err.DebugString(); debug != "" {
+            fmt.Println("DEBUG:", debug)
+        }
+        w.opts.Quit()
+    case gst.MessageElement:
+        if nav := video.ToNavigationMessage(msg); nav != nil {
+            if nav.GetType() == video.NavigationMessageEvent {
+                event := nav.GetEvent()
+
+                dev := w.devices.Load()
+                if dev == nil {
+                    // Disconnected, nowhere to send input to
+                    return true
+                }
+                keyboard, pointer, touch, locks := dev.keyboard, dev.pointer, dev.touch, dev.locks
+
+                switch event.GetType() {
+                case video.NavigationEventInvalid:
+                    fmt.Println("Invalid navigation event:", event)
+                case video.NavigationEventKeyPress:
+                    key, ok := event.ParseKeyEvent()
+                    if ok {
+                        switch hotkey := w.hotkeys.Press(key); hotkey {
+                        case HotkeyNone:
+                        case HotkeyGrab:
+                            pointer.ToggleGrab()
+                            return true
+                        default:
+                            if w.opts.Power != nil {
+                                w.opts.Power.Handle(hotkey)
+                            } else {
+                                fmt.Println("Power shortcuts need -qmp")
+                            }
+                            return true
+                        }
+                        qkey, ok := keymap.LookupKeysym(key)
+                        if ok {
+                            if locks != nil {
+                                w.watchFocus()
+                                locks.KeyPress(key)
+                            }
+                            keyboard.Press(qkey.Qnum)
+                        } else {
+                            fmt.Println("Unknown key down:", key)
+                        }
+                    } else {
+                        panic("wtf")
+                    }
+                case video.NavigationEventKeyRelease:
+                    key, ok := event.ParseKeyEvent()
+                    if ok {
+                        if w.hotkeys.Release(key) {
+                            break
+                        }
+                        qkey, ok := keymap.LookupKeysym(key)
+                        if ok {
+                            keyboard.Release(qkey.Qnum)
+                        } else {
+                            fmt.Println("Unknown key up:", key)
+                        }
+                    } else {
+                        panic("wtf")
+                    }
+                case video.NavigationEventMouseButtonPress:
+                    button, x, y, ok := event.ParseMouseButtonEvent()
+                    if ok {
+                        _ = x
+                        _ = y
+                        //fmt.Println("Mouse down:", button, int(x), int(y))
+                        if qbutton, ok := mouseButton(button); ok {
+                            pointer.Press(qbutton)
+                        } else {
+                            fmt.Println("Unknown mouse button down:", button)
+                        }
+                    } else {
+                        panic("wtf")
+                    }
+                case video.NavigationEventMouseButtonRelease:
+                    button, x, y, ok := event.ParseMouseButtonEvent()
+
+                    if ok {
+                        _ = x
+                        _ = y
+                        //fmt.Println("Mouse up:", button, int(x), int(y))
+                        if qbutton, ok := mouseButton(button); ok {
+                            pointer.Release(qbutton)
+                        } else {
+                            fmt.Println("Unknown mouse button up:", button)
+                        }
+                    } else {
+                        panic("wtf")
+                    }
+                case video.NavigationEventMouseMove:
+                    x, y, ok := event.ParseMouseMoveEvent()
+                    if ok {
+                        //fmt.Println("Mouse move:", int(x), int(y))
+                        pointer.Move(x, y)
+                    } else {
+                        panic("wtf")
+                    }
+                case video.NavigationEventCommand:
+                    cmd, ok := event.ParseCommandEvent()
+                    if ok {
+                        fmt.Println("Command:", cmd)
+                    } else {
+                        panic("wtf")
+                    }
+                case video.NavigationEventTouchDown, video.NavigationEventTouchMotion:
+                    id, x, y, _, ok := event.ParseTouchEvent()
+                    if !ok {
+                        panic("wtf")
+                    }
+                    if touch == nil {
+                        break
+                    }
+                    if event.GetType() == video.NavigationEventTouchDown {
+                        touch.Down(id, x, y)
+                    } else {
+                        touch.Motion(id, x, y)
+                    }
+                case video.NavigationEventTouchUp:
+                    id, x, y, ok := event.ParseTouchUpEvent()
+                    if !ok {
+                        panic("wtf")
+                    }
+                    if touch != nil {
+                        touch.Up(id, x, y)
+                    }
+                case video.NavigationEventTouchCancel:
+                    if touch != nil {
+                        touch.Cancel()
+                    }
+                case video.NavigationEventTouchFrame:
+                    // QEMU syncs every event on its own
+                case video.NavigationEventMouseScroll:
+                    x, y, dx, dy, ok := event.ParseMouseScrollEvent()
+                    if ok {
+                        _ = x
+                        _ = y
+                        //fmt.Println("Mouse scroll:", x, y, dx, dy)
+                        pointer.Scroll(dx, dy)
+                    } else {
+                        panic("wtf")
+                    }
+                }
+            } else {
+                fmt.Println("Unhandled navigation:", nav.GetType())
+            }
+        } else {
+            // Unknown message
+            //fmt.Println("Unknown element message:", msg)
+        }
+    default:
+        //fmt.Println("Unknown message:", msg)
+    }
+    return true
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "slices"
+    "strconv"
+    "sync"
+
+    "qemu"
+)
+
+// ConsoleFilter picks the consoles to show.
+type ConsoleFilter func(console *qemu.Console) bool
+
+// ParseConsoleFilter parses the -console flag: "all", a console ID or a
+// console label.
+func ParseConsoleFilter(spec string) ConsoleFilter {
+    if spec == "all" {
+        return func(*qemu.Console) bool {
+            return true
+        }
+    }
+
+    if id, err := strconv.ParseUint(spec, 10, 32); err == nil {
+        return func(console *qemu.Console) bool {
+            return console.ID() == uint32(id)
+        }
+    }
+
+    return func(console *qemu.Console) bool {
+        return console.Label() == spec
+    }
+}
+
+// Windows keeps a window open for every shown console of the VM, opening
+// and closing them as consoles are plugged and unplugged. Each window sends
+// its input to its own console, so the focused one gets the keyboard.
+type Windows struct {
+    filter ConsoleFilter
+    opts   *ViewOptions
+
+    mu      sync.Mutex
+    windows map[uint32]*Window
+}
+
+func NewWindows(filter ConsoleFilter, opts *ViewOptions) *Windows {
+    return &Windows{
+        filter:  filter,
+        opts:    opts,
+        windows: map[uint32]*Window{},
+    }
+}
+
+// Attach follows the consoles of vm until ctx is done. Windows of consoles
+// that are gone after a reconnection are closed.
+func (ws *Windows) Attach(ctx context.Context, vm *qemu.VM) error {
+    events, err := vm.WatchConsoles(ctx)
+    if err != nil {
+        return err
+    }
+
+    go func() {
+        for ev := range events {
+            ws.update(ctx, vm, ev)
+        }
+    }()
+
+    return nil
+}
+
+func (ws *Windows) update(ctx context.Context, vm *qemu.VM, ev qemu.ConsolesEvent) {
+    ws.mu.Lock()
+    defer ws.mu.Unlock()
+
+    for id, w := range ws.windows {
+        if !slices.Contains(ev.IDs, id) {
+            fmt.Printf("Console %d is gone\n", id)
+            w.Close()
+            delete(ws.windows, id)
+        }
+    }
+
+    for _, id := range ev.Added {
+        console, err := vm.GetConsoleByID(id)
+        if err != nil {
+            fmt.Printf("Cannot get console %d: %v\n", id, err)
+            continue
+        }
+
+        w, ok := ws.windows[id]
+        if !ok {
+            if !ws.filter(console) {
+                continue
+            }
+
+            w, err = NewWindow(id, ws.opts)
+            if err != nil {
+                fmt.Printf("Cannot open a window for console %d: %v\n", id, err)
+                continue
+            }
+            ws.windows[id] = w
+        }
+
+        if err := w.Attach(ctx, console); err != nil {
+            fmt.Printf("Cannot attach to console %d: %v\n", id, err)
+        }
+    }
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "sync"
+
+    "github.com/jezek/xgb"
+    "github.com/jezek/xgb/xfixes"
+    "github.com/jezek/xgb/xproto"
+)
+
+// X11 is a connection of our own to the X server the windows are on. It
+// does what glimagesink does not: hide the grabbed pointer and keep it in
+// the window, and tell when a window gets the focus. It only knows the
+// windows as the one with the focus.
+type X11 struct {
+    conn *xgb.Conn
+    root xproto.Window
+
+    mu      sync.Mutex
+    focusIn map[xproto.Window]func()
+}
+
+// UseX11 makes GStreamer open its windows on X11, through XWayland in a
+// Wayland session, so that OpenX11 reaches them. A GST_GL_WINDOW of the
+// user's own is kept, it returns false if that is not X11.
+func UseX11() bool {
+    switch os.Getenv("GST_GL_WINDOW") {
+    case "":
+        if os.Getenv("DISPLAY") == "" {
+            return false
+        }
+        os.Setenv("GST_GL_WINDOW", "x11")
+        return true
+    case "x11":
+        return true
+    }
+    return false
+}
+
+// OpenX11 connects to $DISPLAY. A hidden pointer comes back when the
+// connection closes, even if the process dies.
+func OpenX11() (*X11, error) {
+    conn, err := xgb.NewConn()
+    if err != nil {
+        return nil, err
+    }
+
+    if err = xfixes.Init(conn); err != nil {
+        conn.Close()
+        return nil, err
+    }
+
+    version, err := xfixes.QueryVersion(conn, 4, 0).Reply()
+    if err != nil {
+        conn.Close()
+        return nil, err
+    }
+    if version.MajorVersion < 4 {
+        conn.Close()
+        return nil, fmt.Errorf("XFixes %d.%d cannot hide the pointer", version.MajorVersion, version.MinorVersion)
+    }
+
+    x := &X11{
+        conn:    conn,
+        root:    xproto.Setup(conn).DefaultScreen(conn).Root,
+        focusIn: map[xproto.Window]func(){},
+    }
+    go x.events()
+
+    return x, nil
+}
+
+func (x *X11) events() {
+    for {
+        ev, err := x.conn.WaitForEvent()
+        if ev == nil && err == nil {
+            // Closed
+            return
+        }
+
+        if focus, ok := ev.(xproto.FocusInEvent); ok {
+            x.mu.Lock()
+            f := x.focusIn[focus.Event]
+            x.mu.Unlock()
+
+            if f != nil {
+                f()
+            }
+        }
+    }
+}
+
+// WatchFocus calls f, from the event goroutine, every time the window
+// that has the focus right now gets it again. It is meant to be called on
+// a key press, which only goes to the focused window.
+func (x *X11) WatchFocus(f func()) error {
+    focus, err := xproto.GetInputFocus(x.conn).Reply()
+    if err != nil {
+        return err
+    }
+    if focus.Focus == xproto.WindowNone || focus.Focus == xproto.InputFocusPointerRoot {
+        return fmt.Errorf("no window has the focus")
+    }
+
+    err = xproto.ChangeWindowAttributesChecked(x.conn, focus.Focus, xproto.CwEventMask, []uint32{xproto.EventMaskFocusChange}).Check()
+    if err != nil {
+        return err
+    }
+
+    x.mu.Lock()
+    x.focusIn[focus.Focus] = f
+    x.mu.Unlock()
+    return nil
+}
+
+func (x *X11) Close() {
+    x.conn.Close()
+}
+
+// focusCenter returns the middle of the focused window in root
+// coordinates, or where the pointer is if no window has the focus.
+func (x *X11) focusCenter() (int16, int16, error) {
+    focus, err := xproto.GetInputFocus(x.conn).Reply()
+    if err != nil {
+        return 0, 0, err
+    }
+
+    if focus.Focus == xproto.WindowNone || focus.Focus == xproto.InputFocusPointerRoot {
+        pointer, err := xproto.QueryPointer(x.conn, x.root).Reply()
+        if err != nil {
+            return 0, 0, err
+        }
+        return pointer.RootX, pointer.RootY, nil
+    }
+
+    geom, err := xproto.GetGeometry(x.conn, xproto.Drawable(focus.Focus)).Reply()
+    if err != nil {
+        return 0, 0, err
+    }
+
+    center, err := xproto.TranslateCoordinates(x.conn, focus.Focus, x.root, int16(geom.Width/2), int16(geom.Height/2)).Reply()
+    if err != nil {
+        return 0, 0, err
+    }
+    return center.DstX, center.DstY, nil
+}
+
+func (x *X11) warp(rootX, rootY int16) error {
+    return xproto.WarpPointerChecked(x.conn, xproto.WindowNone, x.root, 0, 0, 0, 0, rootX, rootY).Check()
+}
+
+// Confiner hides the pointer of one window while it is grabbed and keeps
+// it in the middle of the window: every motion is read as a delta and
+// undone, so the pointer never reaches the window edges.
+type Confiner struct {
+    x11 *X11
+
+    mu      sync.Mutex
+    active  bool
+    centerX int16
+    centerY int16
+}
+
+func (x *X11) NewConfiner() *Confiner {
+    return &Confiner{x11: x}
+}
+
+// Start hides the pointer and moves it to the middle of the focused
+// window, the one that got the grabbing click or hotkey.
+func (c *Confiner) Start() error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if c.active {
+        return nil
+    }
+
+    x, y, err := c.x11.focusCenter()
+    if err != nil {
+        return err
+    }
+    if err = c.x11.warp(x, y); err != nil {
+        return err
+    }
+    if err = xfixes.HideCursorChecked(c.x11.conn, c.x11.root).Check(); err != nil {
+        return err
+    }
+
+    c.active = true
+    c.centerX = x
+    c.centerY = y
+    return nil
+}
+
+// Delta returns how far the pointer went since the last call and moves it
+// back to the middle. It returns false unless the confiner is started.
+func (c *Confiner) Delta() (int32, int32, bool) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if !c.active {
+        return 0, 0, false
+    }
+
+    pointer, err := xproto.QueryPointer(c.x11.conn, c.x11.root).Reply()
+    if err != nil {
+        return 0, 0, false
+    }
+
+    dx := int32(pointer.RootX) - int32(c.centerX)
+    dy := int32(pointer.RootY) - int32(c.centerY)
+    if dx != 0 || dy != 0 {
+        // The warp comes back as a motion without a delta
+        c.x11.warp(c.centerX, c.centerY)
+    }
+    return dx, dy, true
+}
+
+// Stop shows the pointer again, where it was left. It may be called from
+// any goroutine and more than once.
+func (c *Confiner) Stop() {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if !c.active {
+        return
+    }
+
+    c.active = false
+    xfixes.ShowCursorChecked(c.x11.conn, c.x11.root).Check()
+}